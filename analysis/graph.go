@@ -0,0 +1,409 @@
+// Package analysis answers questions about the structure of a state machine, such as which
+// states can be reached, by which events, and which states every path must pass through.
+//
+// Guards are not evaluated: every guarded transition is assumed to be possibly taken and
+// possibly not, so results describe what the machine could do, not what it will do.
+package analysis
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	fsm "github.com/johngrange/gofsm"
+)
+
+// Edge is a transition of the machine.
+type Edge struct {
+	From        string
+	To          string
+	TriggerType fsm.TriggerType
+	Event       string        // empty unless triggered by an event
+	Timer       time.Duration // zero unless triggered by a timer
+	Guard       string        // guard labels, empty if unguarded or unlabelled
+	Guarded     bool
+}
+
+// Trigger describes what causes the transition, as an event name, "after <duration>" or "".
+func (e Edge) Trigger() string {
+	switch e.TriggerType {
+	case fsm.EventTrigger:
+		return e.Event
+	case fsm.TimerTrigger:
+		return "after " + e.Timer.String()
+	default:
+		return ""
+	}
+}
+
+func (e Edge) String() string {
+	s := fmt.Sprintf("%s --> %s", e.From, e.To)
+	if trigger := e.Trigger(); trigger != "" {
+		s += " : " + trigger
+	}
+	if e.Guarded {
+		guard := e.Guard
+		if guard == "" {
+			guard = "guard"
+		}
+		s += " [" + guard + "]"
+	}
+	return s
+}
+
+// Path is a sequence of transitions, each starting where the previous one ended.
+type Path []Edge
+
+// Events returns the events that drive the machine along the path, skipping transitions
+// that are eventless or timed.
+func (p Path) Events() []string {
+	events := []string{}
+	for _, e := range p {
+		if e.TriggerType == fsm.EventTrigger {
+			events = append(events, e.Event)
+		}
+	}
+	return events
+}
+
+// Guards returns the guards that must be true for the machine to follow the path.
+func (p Path) Guards() []string {
+	guards := []string{}
+	for _, e := range p {
+		if e.Guarded {
+			guards = append(guards, e.String())
+		}
+	}
+	return guards
+}
+
+// Graph is the states and transitions of a machine, identified by state name.
+type Graph struct {
+	States []string // in the order the machine visits them
+	Edges  []Edge
+	out    map[string][]int // indexes into Edges by source state
+	in     map[string][]int // indexes into Edges by target state
+}
+
+type graphVisitor struct {
+	g *Graph
+}
+
+func (v graphVisitor) VisitState(state fsm.State) {
+	v.g.addState(state.Name())
+}
+
+func (v graphVisitor) VisitTransition(t fsm.Transition) {
+	e := Edge{
+		From:        t.Source().Name(),
+		To:          t.Target().Name(),
+		TriggerType: t.TriggerType(),
+		Event:       t.EventName(),
+		Guard:       strings.Join(t.GuardLabels(), " "),
+		Guarded:     t.IsGuarded(),
+	}
+	if e.TriggerType == fsm.TimerTrigger {
+		e.Timer = t.TimerDuration()
+	}
+	v.g.out[e.From] = append(v.g.out[e.From], len(v.g.Edges))
+	v.g.in[e.To] = append(v.g.in[e.To], len(v.g.Edges))
+	v.g.Edges = append(v.g.Edges, e)
+}
+
+// FromMachine builds the graph of a machine.
+func FromMachine(machine fsm.Visitable) *Graph {
+	g := &Graph{
+		States: []string{},
+		Edges:  []Edge{},
+		out:    make(map[string][]int),
+		in:     make(map[string][]int),
+	}
+	machine.Visit(graphVisitor{g: g})
+	for _, e := range g.Edges {
+		g.addState(e.To) // targets that are not part of the machine
+	}
+	return g
+}
+
+func (g *Graph) addState(name string) {
+	if _, ok := g.out[name]; ok {
+		return
+	}
+	g.States = append(g.States, name)
+	g.out[name] = []int{}
+}
+
+// HasState returns true if the machine has a state with the given name.
+func (g *Graph) HasState(name string) bool {
+	_, ok := g.out[name]
+	return ok
+}
+
+// Outgoing returns the transitions leaving a state.
+func (g *Graph) Outgoing(state string) []Edge {
+	edges := []Edge{}
+	for _, idx := range g.out[state] {
+		edges = append(edges, g.Edges[idx])
+	}
+	return edges
+}
+
+// Reachable returns the states that can be reached from a state, including the state itself,
+// in breadth first order.  Paths through the avoided states are not followed.
+func (g *Graph) Reachable(from string, avoid ...string) []string {
+	reached := []string{}
+	g.search(from, avoid, func(state string, via int) bool {
+		reached = append(reached, state)
+		return false
+	})
+	return reached
+}
+
+// CanReach returns true if there is a path from one state to another that does not pass through
+// any of the avoided states.
+func (g *Graph) CanReach(from, to string, avoid ...string) bool {
+	_, ok := g.ShortestPath(from, to, avoid...)
+	return ok
+}
+
+// ShortestPath returns a path with the fewest transitions between two states that does not pass
+// through any of the avoided states.  The path from a state to itself is empty.
+func (g *Graph) ShortestPath(from, to string, avoid ...string) (Path, bool) {
+	via := make(map[string]int)
+	found := false
+	g.search(from, avoid, func(state string, edge int) bool {
+		via[state] = edge
+		found = state == to
+		return found
+	})
+	if !found {
+		return nil, false
+	}
+	path := Path{}
+	for state := to; state != from; {
+		e := g.Edges[via[state]]
+		path = append(Path{e}, path...)
+		state = e.From
+	}
+	return path, true
+}
+
+// search visits states breadth first from a state, calling visit with each state and the index
+// of the edge it was first reached by, -1 for the starting state, until visit returns true.
+func (g *Graph) search(from string, avoid []string, visit func(state string, edge int) bool) {
+	if !g.HasState(from) {
+		return
+	}
+	seen := map[string]bool{from: true}
+	for _, a := range avoid {
+		if a != from {
+			seen[a] = true
+		}
+	}
+	if visit(from, -1) {
+		return
+	}
+	queue := []string{from}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		for _, idx := range g.out[state] {
+			next := g.Edges[idx].To
+			if seen[next] {
+				continue
+			}
+			seen[next] = true
+			if visit(next, idx) {
+				return
+			}
+			queue = append(queue, next)
+		}
+	}
+}
+
+// SCCs returns the strongly connected components of the graph.  Each component lists its states
+// in visit order, and components are listed so that no component has a transition into an
+// earlier one.
+func (g *Graph) SCCs() [][]string {
+	index := make(map[string]int)
+	lowlink := make(map[string]int)
+	onStack := make(map[string]bool)
+	stack := []string{}
+	components := [][]string{}
+	next := 0
+
+	var connect func(state string)
+	connect = func(state string) {
+		index[state] = next
+		lowlink[state] = next
+		next++
+		stack = append(stack, state)
+		onStack[state] = true
+		for _, idx := range g.out[state] {
+			target := g.Edges[idx].To
+			if _, visited := index[target]; !visited {
+				connect(target)
+				lowlink[state] = min(lowlink[state], lowlink[target])
+			} else if onStack[target] {
+				lowlink[state] = min(lowlink[state], index[target])
+			}
+		}
+		if lowlink[state] == index[state] {
+			members := make(map[string]bool)
+			for {
+				top := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[top] = false
+				members[top] = true
+				if top == state {
+					break
+				}
+			}
+			components = append(components, g.inVisitOrder(members))
+		}
+	}
+	for _, state := range g.States {
+		if _, visited := index[state]; !visited {
+			connect(state)
+		}
+	}
+	// Tarjan finds components in reverse topological order
+	for i, j := 0, len(components)-1; i < j; i, j = i+1, j-1 {
+		components[i], components[j] = components[j], components[i]
+	}
+	return components
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func (g *Graph) inVisitOrder(members map[string]bool) []string {
+	ordered := []string{}
+	for _, state := range g.States {
+		if members[state] {
+			ordered = append(ordered, state)
+		}
+	}
+	return ordered
+}
+
+// Traps returns the sets of states that, once entered, the machine can never leave, other than
+// the final state.  A trap of a single state with no transitions is a dead end.
+func (g *Graph) Traps() [][]string {
+	traps := [][]string{}
+	for _, component := range g.SCCs() {
+		members := make(map[string]bool)
+		for _, state := range component {
+			members[state] = true
+		}
+		closed := true
+		for _, state := range component {
+			for _, idx := range g.out[state] {
+				if !members[g.Edges[idx].To] {
+					closed = false
+				}
+			}
+		}
+		if closed && !(len(component) == 1 && component[0] == fsm.FinalStateName) {
+			traps = append(traps, component)
+		}
+	}
+	return traps
+}
+
+// Dominators returns the immediate dominator of every state reachable from root, other than root
+// itself.  A state d dominates s if every path from root to s passes through d.  The map is
+// empty if root is not a state of the graph.
+func (g *Graph) Dominators(root string) map[string]string {
+	// Cooper, Harvey and Kennedy, "A Simple, Fast Dominance Algorithm"
+	order := g.reversePostorder(root)
+	if len(order) == 0 {
+		return map[string]string{}
+	}
+	position := make(map[string]int)
+	for idx, state := range order {
+		position[state] = idx
+	}
+	idom := map[string]string{root: root}
+	intersect := func(a, b string) string {
+		for a != b {
+			for position[a] > position[b] {
+				a = idom[a]
+			}
+			for position[b] > position[a] {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, state := range order[1:] {
+			newIdom := ""
+			for _, idx := range g.in[state] {
+				pred := g.Edges[idx].From
+				if _, processed := idom[pred]; !processed {
+					continue
+				}
+				if newIdom == "" {
+					newIdom = pred
+				} else {
+					newIdom = intersect(pred, newIdom)
+				}
+			}
+			if idom[state] != newIdom {
+				idom[state] = newIdom
+				changed = true
+			}
+		}
+	}
+	delete(idom, root)
+	return idom
+}
+
+func (g *Graph) reversePostorder(root string) []string {
+	if !g.HasState(root) {
+		return []string{}
+	}
+	seen := map[string]bool{}
+	post := []string{}
+	var walk func(state string)
+	walk = func(state string) {
+		seen[state] = true
+		for _, idx := range g.out[state] {
+			if target := g.Edges[idx].To; !seen[target] {
+				walk(target)
+			}
+		}
+		post = append(post, state)
+	}
+	walk(root)
+	for i, j := 0, len(post)-1; i < j; i, j = i+1, j-1 {
+		post[i], post[j] = post[j], post[i]
+	}
+	return post
+}
+
+// Dominates returns true if every path from root to state passes through dominator.  Every
+// state dominates itself.  It returns false if state is not reachable from root.
+func (g *Graph) Dominates(root, dominator, state string) bool {
+	idom := g.Dominators(root)
+	if state != root {
+		if _, reachable := idom[state]; !reachable {
+			return false
+		}
+	}
+	for {
+		if state == dominator {
+			return true
+		}
+		if state == root {
+			return false
+		}
+		state = idom[state]
+	}
+}
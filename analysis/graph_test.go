@@ -0,0 +1,128 @@
+package analysis_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	fsm "github.com/johngrange/gofsm"
+	"github.com/johngrange/gofsm/analysis"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Graph analysis", func() {
+	var g *analysis.Graph
+
+	BeforeEach(func() {
+		smb := fsm.NewFSMBuilder()
+		idle := smb.NewState("idle")
+		accepting := smb.NewState("acceptingPayment")
+		printing := smb.NewState("printingTicket")
+		fault := smb.NewState("fault")
+		maintenance := smb.NewState("maintenance")
+		smb.GetInitialState().AddTransition(idle)
+		idle.AddTransition(accepting).SetEventTrigger("evInsertCoin")
+		idle.AddTransition(fault).SetEventTrigger("evFault")
+		accepting.AddTransition(accepting).SetEventTrigger("evInsertCoin")
+		accepting.AddTransition(printing).SetEventTrigger("evPrintTicket").SetGuard(
+			func(fsmData, eventData interface{}) bool { return false }, "paid")
+		accepting.AddTransition(idle).SetTimedTrigger(time.Minute)
+		printing.AddTransition(idle)
+		fault.AddTransition(fault).SetEventTrigger("evPoke")
+		maintenance.AddTransition(idle).SetEventTrigger("evDone")
+		sm, err := smb.BuildImmediateFSM()
+		Expect(err).NotTo(HaveOccurred())
+		g = analysis.FromMachine(sm)
+	})
+
+	It("should build the graph from the machine", func() {
+		Expect(g.States).To(Equal([]string{"initial", "idle", "acceptingPayment", "printingTicket", "fault", "maintenance"}))
+		Expect(g.Edges).To(HaveLen(9))
+		Expect(g.Outgoing("acceptingPayment")[1].String()).To(Equal("acceptingPayment --> printingTicket : evPrintTicket [paid]"))
+		Expect(g.Outgoing("acceptingPayment")[2].Trigger()).To(Equal("after 1m0s"))
+	})
+	It("should find reachable states, treating guards as possibly true", func() {
+		Expect(g.Reachable("initial")).To(Equal([]string{"initial", "idle", "acceptingPayment", "fault", "printingTicket"}))
+		Expect(g.Reachable("fault")).To(Equal([]string{"fault"}))
+		Expect(g.Reachable("nosuchstate")).To(BeEmpty())
+	})
+	It("should answer reachability questions that avoid states", func() {
+		Expect(g.CanReach("initial", "printingTicket")).To(BeTrue())
+		Expect(g.CanReach("initial", "printingTicket", "acceptingPayment")).To(BeFalse())
+		Expect(g.CanReach("initial", "fault", "acceptingPayment")).To(BeTrue())
+		Expect(g.Reachable("initial", "idle")).To(Equal([]string{"initial"}))
+	})
+	It("should find the shortest event path between states", func() {
+		path, ok := g.ShortestPath("initial", "printingTicket")
+		Expect(ok).To(BeTrue())
+		Expect(path).To(HaveLen(3))
+		Expect(path.Events()).To(Equal([]string{"evInsertCoin", "evPrintTicket"}))
+		Expect(path.Guards()).To(Equal([]string{"acceptingPayment --> printingTicket : evPrintTicket [paid]"}))
+
+		path, ok = g.ShortestPath("idle", "idle")
+		Expect(ok).To(BeTrue())
+		Expect(path).To(BeEmpty())
+		_, ok = g.ShortestPath("fault", "idle")
+		Expect(ok).To(BeFalse())
+	})
+	It("should find strongly connected components and traps", func() {
+		Expect(g.SCCs()).To(Equal([][]string{
+			{"maintenance"},
+			{"initial"},
+			{"idle", "acceptingPayment", "printingTicket"},
+			{"fault"},
+		}))
+		Expect(g.Traps()).To(Equal([][]string{{"fault"}}))
+	})
+	It("should find dominators", func() {
+		Expect(g.Dominators("initial")).To(Equal(map[string]string{
+			"idle":             "initial",
+			"acceptingPayment": "idle",
+			"printingTicket":   "acceptingPayment",
+			"fault":            "idle",
+		}))
+		Expect(g.Dominates("initial", "acceptingPayment", "printingTicket")).To(BeTrue())
+		Expect(g.Dominates("initial", "acceptingPayment", "fault")).To(BeFalse())
+		Expect(g.Dominates("initial", "idle", "idle")).To(BeTrue())
+		Expect(g.Dominates("initial", "idle", "maintenance")).To(BeFalse())
+	})
+	It("should find no dominators from an unknown root", func() {
+		Expect(g.Dominators("nope")).To(BeEmpty())
+		Expect(g.Dominates("nope", "idle", "idle")).To(BeFalse())
+	})
+	It("should export a report", func() {
+		report := g.Analyse()
+		Expect(report.Unreachable).To(Equal([]string{"maintenance"}))
+		Expect(report.Cycles).To(Equal([][]string{{"idle", "acceptingPayment", "printingTicket"}, {"fault"}}))
+		Expect(report.Paths["fault"]).To(Equal([]string{"evFault"}))
+
+		buf := bytes.Buffer{}
+		Expect(report.WriteJSON(&buf)).To(Succeed())
+		var decoded analysis.Report
+		Expect(json.Unmarshal(buf.Bytes(), &decoded)).To(Succeed())
+		Expect(decoded).To(Equal(report))
+
+		buf.Reset()
+		Expect(report.WriteText(&buf)).To(Succeed())
+		Expect(buf.String()).To(Equal(`states: 6, transitions: 9
+unreachable: maintenance
+cycles:
+  idle, acceptingPayment, printingTicket
+  fault
+traps:
+  fault
+paths from initial:
+  acceptingPayment: evInsertCoin
+  fault: evFault
+  idle: 
+  initial: 
+  printingTicket: evInsertCoin, evPrintTicket
+immediate dominators:
+  acceptingPayment: idle
+  fault: idle
+  idle: initial
+  printingTicket: acceptingPayment
+`))
+	})
+})
@@ -0,0 +1,108 @@
+package analysis
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	fsm "github.com/johngrange/gofsm"
+)
+
+// Report summarises the structure of a machine, as seen from its initial state.
+type Report struct {
+	States      int        `json:"states"`
+	Transitions int        `json:"transitions"`
+	Unreachable []string   `json:"unreachable"`
+	Cycles      [][]string `json:"cycles"` // strongly connected components with more than one state, or a self transition
+	Traps       [][]string `json:"traps"`
+	// Dominators maps each reachable state to its immediate dominator.
+	Dominators map[string]string `json:"dominators"`
+	// Paths holds the shortest event sequence from the initial state to each reachable state.
+	Paths map[string][]string `json:"paths"`
+}
+
+// Analyse builds a report of the graph, starting from the initial state.
+func (g *Graph) Analyse() Report {
+	r := Report{
+		States:      len(g.States),
+		Transitions: len(g.Edges),
+		Unreachable: []string{},
+		Cycles:      [][]string{},
+		Traps:       g.Traps(),
+		Dominators:  g.Dominators(fsm.InitialStateName),
+		Paths:       make(map[string][]string),
+	}
+	reachable := make(map[string]bool)
+	for _, state := range g.Reachable(fsm.InitialStateName) {
+		reachable[state] = true
+		path, _ := g.ShortestPath(fsm.InitialStateName, state)
+		r.Paths[state] = path.Events()
+	}
+	for _, state := range g.States {
+		if !reachable[state] {
+			r.Unreachable = append(r.Unreachable, state)
+		}
+	}
+	for _, component := range g.SCCs() {
+		if len(component) > 1 || g.hasSelfTransition(component[0]) {
+			r.Cycles = append(r.Cycles, component)
+		}
+	}
+	return r
+}
+
+func (g *Graph) hasSelfTransition(state string) bool {
+	for _, idx := range g.out[state] {
+		if g.Edges[idx].To == state {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteText writes the report in a form suitable for reading in a design review.
+func (r Report) WriteText(w io.Writer) error {
+	lines := []string{
+		fmt.Sprintf("states: %d, transitions: %d", r.States, r.Transitions),
+		"unreachable: " + strings.Join(r.Unreachable, ", "),
+		"cycles:",
+	}
+	for _, c := range r.Cycles {
+		lines = append(lines, "  "+strings.Join(c, ", "))
+	}
+	lines = append(lines, "traps:")
+	for _, t := range r.Traps {
+		lines = append(lines, "  "+strings.Join(t, ", "))
+	}
+	lines = append(lines, "paths from "+fsm.InitialStateName+":")
+	for _, state := range sortedKeys(r.Paths) {
+		lines = append(lines, fmt.Sprintf("  %s: %s", state, strings.Join(r.Paths[state], ", ")))
+	}
+	lines = append(lines, "immediate dominators:")
+	for _, state := range sortedKeys(r.Dominators) {
+		lines = append(lines, fmt.Sprintf("  %s: %s", state, r.Dominators[state]))
+	}
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
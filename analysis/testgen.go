@@ -0,0 +1,370 @@
+package analysis
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"strings"
+	"text/template"
+
+	fsm "github.com/johngrange/gofsm"
+)
+
+// Coverage is the criterion test sequences are generated for.
+type Coverage int
+
+const (
+	CoverStates          Coverage = iota // every state entered
+	CoverTransitions                     // every transition taken
+	CoverTransitionPairs                 // every transition followed by every transition leaving its target
+)
+
+// Sequence is a test case: a path from the initial state.
+type Sequence struct {
+	Steps Path
+}
+
+// Events returns the events to dispatch to drive the machine along the sequence.
+func (s Sequence) Events() []string {
+	return s.Steps.Events()
+}
+
+// Annotated describes each step of the sequence, with the guards and timers it needs.
+func (s Sequence) Annotated() []string {
+	steps := make([]string, len(s.Steps))
+	for idx, e := range s.Steps {
+		var step string
+		switch e.TriggerType {
+		case fsm.EventTrigger:
+			step = "dispatch " + e.Event
+		case fsm.TimerTrigger:
+			step = "wait " + e.Timer.String()
+		default:
+			step = "automatic"
+		}
+		step += fmt.Sprintf(": %s --> %s", e.From, e.To)
+		if e.Guarded {
+			guard := e.Guard
+			if guard == "" {
+				guard = "unlabelled guard"
+			}
+			step += fmt.Sprintf(" (requires [%s])", guard)
+		}
+		steps[idx] = step
+	}
+	return steps
+}
+
+// coverageNode is a position in the search for uncovered items: a state, and the edge the
+// machine arrived by, -1 for none.
+type coverageNode struct {
+	state string
+	edge  int
+}
+
+// GenerateSequences returns test sequences, each starting from the initial state, that
+// together cover the machine to the given criterion, treating guards as possibly true.
+// Sequences are built greedily, extending each one to the nearest uncovered item, so the set is
+// small but not guaranteed minimal.  Items that cannot be reached are returned as uncovered:
+// state names for CoverStates, transitions for the others, as "<edge>" or "<edge> ; <edge>".
+func (g *Graph) GenerateSequences(c Coverage) ([]Sequence, []string) {
+	items := g.coverageItems(c)
+	covered := make(map[string]bool)
+	itemsOf := func(node coverageNode, edge int) []string {
+		e := g.Edges[edge]
+		switch c {
+		case CoverStates:
+			return []string{e.To}
+		case CoverTransitions:
+			return []string{e.String()}
+		default:
+			if node.edge < 0 {
+				return nil
+			}
+			return []string{g.Edges[node.edge].String() + " ; " + e.String()}
+		}
+	}
+	if c == CoverStates {
+		covered[fsm.InitialStateName] = true
+	}
+
+	sequences := []Sequence{}
+	for {
+		current := coverageNode{state: fsm.InitialStateName, edge: -1}
+		seq := Sequence{Steps: Path{}}
+		for {
+			path, end, ok := g.nearestUncovered(current, covered, itemsOf)
+			if !ok {
+				break
+			}
+			node := current
+			for _, idx := range path {
+				for _, item := range itemsOf(node, idx) {
+					covered[item] = true
+				}
+				seq.Steps = append(seq.Steps, g.Edges[idx])
+				node = coverageNode{state: g.Edges[idx].To, edge: idx}
+			}
+			current = end
+		}
+		if len(seq.Steps) == 0 {
+			break
+		}
+		sequences = append(sequences, seq)
+	}
+
+	uncovered := []string{}
+	for _, item := range items {
+		if !covered[item] {
+			uncovered = append(uncovered, item)
+		}
+	}
+	return sequences, uncovered
+}
+
+func (g *Graph) coverageItems(c Coverage) []string {
+	items := []string{}
+	switch c {
+	case CoverStates:
+		items = append(items, g.States...)
+	case CoverTransitions:
+		for _, e := range g.Edges {
+			items = append(items, e.String())
+		}
+	default:
+		for _, e := range g.Edges {
+			for _, next := range g.Outgoing(e.To) {
+				items = append(items, e.String()+" ; "+next.String())
+			}
+		}
+	}
+	return items
+}
+
+// nearestUncovered searches breadth first from a node for the shortest path, as edge indexes,
+// whose last edge covers an uncovered item.
+func (g *Graph) nearestUncovered(from coverageNode, covered map[string]bool,
+	itemsOf func(node coverageNode, edge int) []string) ([]int, coverageNode, bool) {
+	type visit struct {
+		prev coverageNode
+		edge int
+	}
+	seen := map[coverageNode]visit{from: {edge: -1}}
+	queue := []coverageNode{from}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for _, idx := range g.out[node.state] {
+			next := coverageNode{state: g.Edges[idx].To, edge: idx}
+			newItem := false
+			for _, item := range itemsOf(node, idx) {
+				if !covered[item] {
+					newItem = true
+				}
+			}
+			if _, ok := seen[next]; ok && !newItem {
+				continue
+			}
+			seen[next] = visit{prev: node, edge: idx}
+			if newItem {
+				path := []int{idx}
+				for n := node; n != from; n = seen[n].prev {
+					path = append([]int{seen[n].edge}, path...)
+				}
+				return path, next, true
+			}
+			queue = append(queue, next)
+		}
+	}
+	return nil, coverageNode{}, false
+}
+
+var skeletonTemplate = template.Must(template.New("skeleton").Parse(`package {{.Package}}
+
+import (
+	"time"
+
+	fsm "github.com/johngrange/gofsm"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// Generated test sequences.  {{.Constructor}} must return a new, unstarted machine using the
+// given clock.  Make the guards noted in each sequence true before the step that needs them.
+var _ = Describe("{{.Name}} generated sequences", func() {
+	var (
+		clock *fsm.FakeClock
+		sm    fsm.ImmediateFSM
+	)
+	BeforeEach(func() {
+		clock = fsm.NewFakeClock(time.Now())
+		sm = {{.Constructor}}(clock)
+	})
+{{range $idx, $seq := .Sequences}}
+	It("sequence {{$idx}}", func() {
+		sm.Start()
+{{- range $seq}}
+{{- range .Comments}}
+		// {{.}}
+{{- end}}
+{{- if .Code}}
+		{{.Code}}
+{{- end}}
+{{- if .State}}
+		Expect(sm.CurrentState().Name()).To(Equal({{printf "%q" .State}}))
+{{- end}}
+{{- end}}
+	})
+{{end -}}
+})
+`))
+
+type skeletonStep struct {
+	Comments []string
+	Code     string
+	State    string
+}
+
+// WriteGinkgoSkeleton writes a ginkgo test file that drives an immediate machine along each
+// sequence.  constructor names a func(*fsm.FakeClock) fsm.ImmediateFSM in the test package.
+func WriteGinkgoSkeleton(w io.Writer, pkg, name, constructor string, sequences []Sequence) error {
+	data := struct {
+		Package, Name, Constructor string
+		Sequences                  [][]skeletonStep
+	}{pkg, name, constructor, [][]skeletonStep{}}
+	for _, seq := range sequences {
+		steps := []skeletonStep{}
+		for idx, e := range seq.Steps {
+			step := skeletonStep{State: e.To, Comments: []string{e.String()}}
+			if idx+1 < len(seq.Steps) && seq.Steps[idx+1].TriggerType == fsm.NoTrigger {
+				step.State = "" // the machine moves straight on
+			}
+			if e.Guarded {
+				step.Comments = append(step.Comments, "TODO: make guard true")
+			}
+			switch e.TriggerType {
+			case fsm.EventTrigger:
+				step.Code = fmt.Sprintf("sm.Dispatch(fsm.NewEvent(%q, nil))", e.Event)
+			case fsm.TimerTrigger:
+				step.Code = fmt.Sprintf("clock.Advance(%d + 1) // just past %s\n\t\tsm.Tick()", int64(e.Timer), e.Timer)
+			}
+			if e.From == fsm.InitialStateName && e.TriggerType == fsm.NoTrigger {
+				step.Comments = append(step.Comments, "taken by Start")
+			}
+			steps = append(steps, step)
+		}
+		data.Sequences = append(data.Sequences, steps)
+	}
+	buf := bytes.Buffer{}
+	if err := skeletonTemplate.Execute(&buf, data); err != nil {
+		return err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+// Blocked is a step of a sequence that the machine did not take.
+type Blocked struct {
+	Sequence int
+	Step     int
+	Edge     Edge
+	State    string // the state the machine was in instead
+	Guarded  bool   // true if the transition is guarded, so was probably blocked by its guard
+}
+
+func (b Blocked) String() string {
+	reason := "machine in " + b.State
+	if b.Guarded {
+		reason = "blocked by guard"
+	}
+	return fmt.Sprintf("sequence %d step %d: %s: %s", b.Sequence, b.Step, b.Edge, reason)
+}
+
+// RunResult reports the steps taken and blocked when running sequences.
+type RunResult struct {
+	Taken   []Edge
+	Blocked []Blocked
+}
+
+type transitionRecorder struct {
+	transitions [][2]string
+}
+
+func (r *transitionRecorder) OnEntry(state fsm.State, fsmData interface{}) {}
+func (r *transitionRecorder) OnExit(state fsm.State, fsmData interface{})  {}
+func (r *transitionRecorder) OnTransition(ev fsm.Event, sourceState, targetState fsm.State, fsmData interface{}) {
+	r.transitions = append(r.transitions, [2]string{sourceState.Name(), targetState.Name()})
+}
+func (r *transitionRecorder) OnRejectedEvent(ev fsm.Event, state fsm.State, fsmData interface{}) {}
+
+// next returns the oldest recorded transition not yet checked, if any.
+func (r *transitionRecorder) next() ([2]string, bool) {
+	if len(r.transitions) == 0 {
+		return [2]string{}, false
+	}
+	t := r.transitions[0]
+	r.transitions = r.transitions[1:]
+	return t, true
+}
+
+// RunSequences drives new machines along each sequence, using a fake clock for timed
+// transitions.  Events are dispatched without payloads.  A sequence stops at its first blocked
+// step.
+func RunSequences(newMachine func(clock *fsm.FakeClock) (fsm.ImmediateFSM, error), sequences []Sequence) (RunResult, error) {
+	result := RunResult{Taken: []Edge{}, Blocked: []Blocked{}}
+	for seqIdx, seq := range sequences {
+		clock := fsm.NewFakeClock(fsm.RealClock().Now())
+		sm, err := newMachine(clock)
+		if err != nil {
+			return result, err
+		}
+		recorder := &transitionRecorder{}
+		sm.AddTracer(recorder)
+		sm.Start()
+		for stepIdx, e := range seq.Steps {
+			// eventless transitions are taken along with the step before them, so are only
+			// stimulated if the machine has not already taken them
+			if e.TriggerType != fsm.NoTrigger || len(recorder.transitions) == 0 {
+				if len(recorder.transitions) > 0 {
+					recorder.transitions = nil // the machine went somewhere unexpected
+				}
+				switch e.TriggerType {
+				case fsm.EventTrigger:
+					sm.Dispatch(fsm.NewEvent(e.Event, nil))
+				case fsm.TimerTrigger:
+					clock.Advance(e.Timer + 1) // timers fire once their deadline has passed
+					sm.Tick()
+				default:
+					sm.Tick()
+				}
+			}
+			if taken, ok := recorder.next(); !ok || taken != [2]string{e.From, e.To} {
+				result.Blocked = append(result.Blocked, Blocked{
+					Sequence: seqIdx,
+					Step:     stepIdx,
+					Edge:     e,
+					State:    sm.CurrentState().Name(),
+					Guarded:  e.Guarded,
+				})
+				break
+			}
+			result.Taken = append(result.Taken, e)
+		}
+		sm.Stop()
+	}
+	return result, nil
+}
+
+// String lists the blocked steps, one per line.
+func (r RunResult) String() string {
+	lines := []string{fmt.Sprintf("%d steps taken, %d blocked", len(r.Taken), len(r.Blocked))}
+	for _, b := range r.Blocked {
+		lines = append(lines, b.String())
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,156 @@
+package analysis_test
+
+import (
+	"bytes"
+	"time"
+
+	fsm "github.com/johngrange/gofsm"
+	"github.com/johngrange/gofsm/analysis"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type meterData struct {
+	paid bool
+}
+
+func newMeter(clock *fsm.FakeClock, data *meterData) (fsm.ImmediateFSM, error) {
+	smb := fsm.NewFSMBuilder().SetClock(clock).SetData(data)
+	idle := smb.NewState("idle")
+	accepting := smb.NewState("acceptingPayment")
+	printing := smb.NewState("printingTicket")
+	smb.GetInitialState().AddTransition(idle)
+	idle.AddTransition(accepting).SetEventTrigger("evInsertCoin")
+	accepting.AddTransition(accepting).SetEventTrigger("evInsertCoin")
+	accepting.AddTransition(printing).SetEventTrigger("evPrintTicket").SetGuard(
+		func(fsmData, eventData interface{}) bool { return fsmData.(*meterData).paid }, "paid")
+	accepting.AddTransition(idle).SetTimedTrigger(time.Minute)
+	printing.AddTransition(idle)
+	return smb.BuildImmediateFSM()
+}
+
+var _ = Describe("Test sequence generation", func() {
+	var g *analysis.Graph
+
+	BeforeEach(func() {
+		sm, err := newMeter(fsm.NewFakeClock(time.Now()), &meterData{})
+		Expect(err).NotTo(HaveOccurred())
+		g = analysis.FromMachine(sm)
+	})
+
+	It("should cover every state", func() {
+		sequences, uncovered := g.GenerateSequences(analysis.CoverStates)
+		Expect(uncovered).To(BeEmpty())
+		Expect(sequences).To(HaveLen(1))
+		Expect(sequences[0].Events()).To(Equal([]string{"evInsertCoin", "evPrintTicket"}))
+	})
+	It("should cover every transition and annotate guards and timers", func() {
+		sequences, uncovered := g.GenerateSequences(analysis.CoverTransitions)
+		Expect(uncovered).To(BeEmpty())
+		Expect(sequences).To(HaveLen(1))
+		Expect(sequences[0].Annotated()).To(Equal([]string{
+			"automatic: initial --> idle",
+			"dispatch evInsertCoin: idle --> acceptingPayment",
+			"dispatch evInsertCoin: acceptingPayment --> acceptingPayment",
+			"dispatch evPrintTicket: acceptingPayment --> printingTicket (requires [paid])",
+			"automatic: printingTicket --> idle",
+			"dispatch evInsertCoin: idle --> acceptingPayment",
+			"wait 1m0s: acceptingPayment --> idle",
+		}))
+	})
+	It("should cover every pair of transitions", func() {
+		sequences, uncovered := g.GenerateSequences(analysis.CoverTransitionPairs)
+		Expect(uncovered).To(BeEmpty())
+		pairs := map[[2]string]bool{}
+		for _, seq := range sequences {
+			for idx := 1; idx < len(seq.Steps); idx++ {
+				pairs[[2]string{seq.Steps[idx-1].String(), seq.Steps[idx].String()}] = true
+			}
+		}
+		// three transitions leave acceptingPayment, and two arrive there
+		Expect(pairs).To(HaveKey([2]string{
+			"acceptingPayment --> acceptingPayment : evInsertCoin",
+			"acceptingPayment --> acceptingPayment : evInsertCoin",
+		}))
+		Expect(pairs).To(HaveKey([2]string{
+			"acceptingPayment --> acceptingPayment : evInsertCoin",
+			"acceptingPayment --> idle : after 1m0s",
+		}))
+		Expect(pairs).To(HaveKey([2]string{
+			"idle --> acceptingPayment : evInsertCoin",
+			"acceptingPayment --> printingTicket : evPrintTicket [paid]",
+		}))
+	})
+	It("should report items that cannot be covered", func() {
+		smb := fsm.NewFSMBuilder()
+		on := smb.NewState("on")
+		orphan := smb.NewState("orphan")
+		smb.GetInitialState().AddTransition(on)
+		orphan.AddTransition(on).SetEventTrigger("evAdopt")
+		on.AddTransition(on).SetEventTrigger("evPing")
+		sm, err := smb.BuildImmediateFSM()
+		Expect(err).NotTo(HaveOccurred())
+		g = analysis.FromMachine(sm)
+
+		_, uncovered := g.GenerateSequences(analysis.CoverStates)
+		Expect(uncovered).To(Equal([]string{"orphan"}))
+		_, uncovered = g.GenerateSequences(analysis.CoverTransitions)
+		Expect(uncovered).To(Equal([]string{"orphan --> on : evAdopt"}))
+		_, uncovered = g.GenerateSequences(analysis.CoverTransitionPairs)
+		Expect(uncovered).To(Equal([]string{"orphan --> on : evAdopt ; on --> on : evPing"}))
+	})
+
+	It("should write a ginkgo skeleton", func() {
+		sequences, _ := g.GenerateSequences(analysis.CoverTransitions)
+		buf := bytes.Buffer{}
+		Expect(analysis.WriteGinkgoSkeleton(&buf, "meter_test", "Meter", "newTestMeter", sequences)).To(Succeed())
+		src := buf.String()
+		Expect(src).To(HavePrefix("package meter_test\n"))
+		Expect(src).To(ContainSubstring(`var _ = Describe("Meter generated sequences", func() {`))
+		Expect(src).To(ContainSubstring("sm = newTestMeter(clock)"))
+		Expect(src).To(ContainSubstring(`It("sequence 0", func() {`))
+		Expect(src).To(ContainSubstring("\t\t// initial --> idle\n\t\t// taken by Start\n"))
+		Expect(src).To(ContainSubstring(
+			"\t\t// acceptingPayment --> printingTicket : evPrintTicket [paid]\n" +
+				"\t\t// TODO: make guard true\n" +
+				"\t\tsm.Dispatch(fsm.NewEvent(\"evPrintTicket\", nil))\n" +
+				"\t\t// printingTicket --> idle\n" +
+				"\t\tExpect(sm.CurrentState().Name()).To(Equal(\"idle\"))\n"))
+		Expect(src).To(ContainSubstring("\t\tclock.Advance(60000000000 + 1) // just past 1m0s\n\t\tsm.Tick()\n"))
+	})
+
+	When("running sequences against the machine", func() {
+		var sequences []analysis.Sequence
+
+		BeforeEach(func() {
+			sequences, _ = g.GenerateSequences(analysis.CoverTransitions)
+		})
+
+		It("should report transitions blocked by guards", func() {
+			result, err := analysis.RunSequences(func(clock *fsm.FakeClock) (fsm.ImmediateFSM, error) {
+				return newMeter(clock, &meterData{})
+			}, sequences)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Taken).To(HaveLen(3))
+			Expect(result.Blocked).To(HaveLen(1))
+			Expect(result.Blocked[0].Step).To(Equal(3))
+			Expect(result.Blocked[0].State).To(Equal("acceptingPayment"))
+			Expect(result.String()).To(Equal("3 steps taken, 1 blocked\n" +
+				"sequence 0 step 3: acceptingPayment --> printingTicket : evPrintTicket [paid]: blocked by guard"))
+		})
+		It("should take every step when guards pass", func() {
+			result, err := analysis.RunSequences(func(clock *fsm.FakeClock) (fsm.ImmediateFSM, error) {
+				return newMeter(clock, &meterData{paid: true})
+			}, sequences)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Blocked).To(BeEmpty())
+			Expect(result.Taken).To(Equal([]analysis.Edge(sequences[0].Steps)))
+		})
+		It("should return errors from building the machine", func() {
+			_, err := analysis.RunSequences(func(clock *fsm.FakeClock) (fsm.ImmediateFSM, error) {
+				return fsm.NewFSMBuilder().SetValidation(fsm.ValidateStrict).BuildImmediateFSM()
+			}, sequences)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,227 @@
+package fsm
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// TransitionCounter is implemented by tracers that count how often each transition of a definition
+// is taken, such as TrafficTracer and CoverageTracer.
+type TransitionCounter interface {
+	TransitionCount(t Transition) uint64
+}
+
+// TrafficTracer counts the entries to each state and the transitions taken, and measures the time
+// spent in each state by clock, which should be the machine's own.  Like CoverageTracer, it may
+// be added to any number of machines built from the same definition.
+type TrafficTracer struct {
+	mx      sync.Mutex
+	clock   Clock
+	entries map[string]uint64
+	timeIn  map[string]time.Duration
+	since   map[State]time.Time // entry time of the states currently occupied, by each machine
+	taken   map[string]uint64   // by source and index
+}
+
+// NewTrafficTracer returns a tracer timing states with clock, or the real clock if clock is nil.
+func NewTrafficTracer(clock Clock) *TrafficTracer {
+	if clock == nil {
+		clock = RealClock()
+	}
+	return &TrafficTracer{
+		clock:   clock,
+		entries: make(map[string]uint64),
+		timeIn:  make(map[string]time.Duration),
+		since:   make(map[State]time.Time),
+		taken:   make(map[string]uint64),
+	}
+}
+
+func (t *TrafficTracer) OnEntry(state State, fsmData interface{}) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	t.entries[state.Name()]++
+	t.since[state] = t.clock.Now()
+}
+func (t *TrafficTracer) OnExit(state State, fsmData interface{}) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	if since, ok := t.since[state]; ok {
+		t.timeIn[state.Name()] += t.clock.Now().Sub(since)
+		delete(t.since, state)
+	}
+}
+func (t *TrafficTracer) OnTransition(ev Event, sourceState, targetState State, fsmData interface{}) {
+}
+func (t *TrafficTracer) OnRejectedEvent(ev Event, state State, fsmData interface{}) {}
+
+func (t *TrafficTracer) OnTransitionTaken(ev Event, transition Transition, fsmData interface{}) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	t.taken[transitionKey(transition.Source().Name(), transitionIndex(transition))]++
+}
+func (t *TrafficTracer) OnTransitionBlocked(ev Event, transition Transition, fsmData interface{}) {}
+
+// Entries returns the number of times state was entered.
+func (t *TrafficTracer) Entries(state string) uint64 {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	return t.entries[state]
+}
+
+// TimeIn returns the total time spent in state by all the machines traced, including the time so
+// far of those occupying it.
+func (t *TrafficTracer) TimeIn(state string) time.Duration {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	d := t.timeIn[state]
+	for occupied, since := range t.since {
+		if occupied.Name() == state {
+			d += t.clock.Now().Sub(since)
+		}
+	}
+	return d
+}
+
+// TransitionCount returns the number of times transition was taken.
+func (t *TrafficTracer) TransitionCount(transition Transition) uint64 {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	return t.taken[transitionKey(transition.Source().Name(), transitionIndex(transition))]
+}
+
+// TransitionCount returns the number of times transition was taken.
+func (c *CoverageTracer) TransitionCount(transition Transition) uint64 {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if idx, ok := c.transitions[transitionKey(transition.Source().Name(), transitionIndex(transition))]; ok {
+		return c.report.Transitions[idx].Taken
+	}
+	return 0
+}
+
+// Annotations are what is known about a running machine, to be drawn over its definition:
+// the current state, how often states were entered and transitions taken, and the time spent in
+// each state.  RenderPlantUML, RenderDOT and RenderMermaid draw them when given annotation
+// options, and other renderers built on Visitor can call NewAnnotations with the same options.
+type Annotations struct {
+	highlightCurrent bool
+	current          string
+	entries          func(state string) uint64
+	timeIn           func(state string) time.Duration
+	transitions      TransitionCounter
+	maxTaken         uint64
+}
+
+// AnnotationOption chooses what is annotated on a rendered machine.
+type AnnotationOption func(*Annotations)
+
+// AnnotateCurrentState highlights the state the machine is in.
+func AnnotateCurrentState() AnnotationOption {
+	return func(a *Annotations) {
+		a.highlightCurrent = true
+	}
+}
+
+// AnnotateStateCounts notes how often each state was entered, as counted by counter.  counter
+// is read without locking, so the machine should not be running while it is rendered.
+func AnnotateStateCounts(counter *StateCounter) AnnotationOption {
+	return func(a *Annotations) {
+		a.entries = func(state string) uint64 { return counter.StateCounts[state] }
+	}
+}
+
+// AnnotateTransitionCounts labels transitions with how often they were taken, and colours and
+// thickens them by how often relative to the busiest.
+func AnnotateTransitionCounts(counter TransitionCounter) AnnotationOption {
+	return func(a *Annotations) {
+		a.transitions = counter
+	}
+}
+
+// AnnotateTraffic notes state entries and time in state, and transition counts, from traffic.
+func AnnotateTraffic(traffic *TrafficTracer) AnnotationOption {
+	return func(a *Annotations) {
+		a.entries = traffic.Entries
+		a.timeIn = traffic.TimeIn
+		a.transitions = traffic
+	}
+}
+
+// NewAnnotations reads the annotations chosen by opts from stateMachine and its tracers.
+func NewAnnotations(stateMachine FSM, opts ...AnnotationOption) *Annotations {
+	a := &Annotations{}
+	for _, opt := range opts {
+		opt(a)
+	}
+	if a.highlightCurrent {
+		a.current = stateMachine.CurrentState().Name()
+	}
+	if a.transitions != nil {
+		visitor := definitionVisitor{}
+		stateMachine.Visit(&visitor)
+		for _, t := range visitor.transitions {
+			if n := a.transitions.TransitionCount(t); n > a.maxTaken {
+				a.maxTaken = n
+			}
+		}
+	}
+	return a
+}
+
+// IsCurrent reports whether state is the current state and is to be highlighted.
+func (a *Annotations) IsCurrent(state State) bool {
+	return a.highlightCurrent && state.Name() == a.current
+}
+
+// Notes returns the counts and times annotated on state, such as "entries: 3".
+func (a *Annotations) Notes(state State) []string {
+	if isPseudoState(state.Name()) {
+		return nil
+	}
+	notes := []string{}
+	if a.entries != nil {
+		notes = append(notes, fmt.Sprintf("entries: %d", a.entries(state.Name())))
+	}
+	if a.timeIn != nil {
+		notes = append(notes, "time: "+a.timeIn(state.Name()).String())
+	}
+	return notes
+}
+
+// Taken returns the number of times t was taken, and false if transitions are not annotated.
+func (a *Annotations) Taken(t Transition) (uint64, bool) {
+	if a.transitions == nil {
+		return 0, false
+	}
+	return a.transitions.TransitionCount(t), true
+}
+
+// Heat returns how often t was taken relative to the busiest transition, from 0 to 1.
+func (a *Annotations) Heat(t Transition) float64 {
+	taken, ok := a.Taken(t)
+	if !ok || a.maxTaken == 0 {
+		return 0
+	}
+	return float64(taken) / float64(a.maxTaken)
+}
+
+// heatColour shades transitions from blue, for the least taken, to red for the busiest.
+// Transitions never taken are grey.
+func (a *Annotations) heatColour(t Transition) string {
+	if taken, _ := a.Taken(t); taken == 0 {
+		return "#a0a0a0"
+	}
+	heat := a.Heat(t)
+	mix := func(from, to int) int {
+		return int(math.Round(float64(from) + heat*float64(to-from)))
+	}
+	return fmt.Sprintf("#%02x%02x%02x", mix(0x45, 0xd7), mix(0x75, 0x30), mix(0xb4, 0x27))
+}
+
+// heatWidth is the line width of t, from 1 for the least taken to 4 for the busiest.
+func (a *Annotations) heatWidth(t Transition) int {
+	return 1 + int(math.Round(3*a.Heat(t)))
+}
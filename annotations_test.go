@@ -0,0 +1,196 @@
+package fsm_test
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	fsm "github.com/johngrange/gofsm"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// headlineVisitor is a renderer outside the package, drawing one line per state and transition
+type headlineVisitor struct {
+	annotations *fsm.Annotations
+	lines       []string
+}
+
+func (h *headlineVisitor) VisitState(state fsm.State) {
+	line := state.Name()
+	if h.annotations.IsCurrent(state) {
+		line += " *"
+	}
+	line += fmt.Sprintf(" %v", h.annotations.Notes(state))
+	h.lines = append(h.lines, line)
+}
+func (h *headlineVisitor) VisitTransition(t fsm.Transition) {
+	taken, _ := h.annotations.Taken(t)
+	h.lines = append(h.lines, fmt.Sprintf("%s -> %s %d %.2f", t.Source().Name(), t.Target().Name(), taken, h.annotations.Heat(t)))
+}
+
+var _ = Describe("Runtime Annotations", func() {
+	var (
+		stateMachine fsm.ImmediateFSM
+		clock        *fsm.FakeClock
+		traffic      *fsm.TrafficTracer
+		counter      *fsm.StateCounter
+		coverage     *fsm.CoverageTracer
+		buf          *bytes.Buffer
+		err          error
+	)
+
+	BeforeEach(func() {
+		clock = fsm.NewFakeClock(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+		stateMachineBuilder := fsm.NewFSMBuilder().SetClock(clock)
+		idle := stateMachineBuilder.NewState("idle")
+		running := stateMachineBuilder.NewState("running", "busy")
+		stateMachineBuilder.GetInitialState().AddTransition(idle)
+		idle.AddTransition(running).SetEventTrigger("Start")
+		running.AddTransition(idle).SetEventTrigger("Stop")
+		running.AddTransition(idle).SetTimedTrigger(time.Minute)
+
+		stateMachine, err = stateMachineBuilder.BuildImmediateFSM()
+		Expect(err).NotTo(HaveOccurred())
+		traffic = fsm.NewTrafficTracer(clock)
+		counter = fsm.NewStateCounter()
+		coverage = fsm.NewCoverageTracer(stateMachine)
+		stateMachine.AddTracer(traffic)
+		stateMachine.AddTracer(counter)
+		stateMachine.AddTracer(coverage)
+		buf = &bytes.Buffer{}
+
+		stateMachine.Start()
+		clock.Advance(10 * time.Second)
+		stateMachine.Dispatch(fsm.NewEvent("Start", nil))
+		clock.Advance(20 * time.Second)
+		stateMachine.Dispatch(fsm.NewEvent("Stop", nil))
+		clock.Advance(5 * time.Second)
+		stateMachine.Dispatch(fsm.NewEvent("Start", nil))
+		clock.Advance(15 * time.Second)
+	})
+
+	When("tracing traffic", func() {
+		It("should count entries and transitions, and time states by the machine's clock", func() {
+			Expect(traffic.Entries("idle")).To(BeNumerically("==", 2))
+			Expect(traffic.Entries("running")).To(BeNumerically("==", 2))
+			Expect(traffic.TimeIn("idle")).To(Equal(15 * time.Second))
+			// running is still occupied, so its time includes the stay so far
+			Expect(traffic.TimeIn("running")).To(Equal(35 * time.Second))
+			clock.Advance(5 * time.Second)
+			Expect(traffic.TimeIn("running")).To(Equal(40 * time.Second))
+		})
+		It("should time states of several machines separately", func() {
+			smb := fsm.NewFSMBuilder().SetClock(clock)
+			idle := smb.NewState("idle")
+			running := smb.NewState("running", "busy")
+			smb.GetInitialState().AddTransition(idle)
+			idle.AddTransition(running).SetEventTrigger("Start")
+			running.AddTransition(idle).SetEventTrigger("Stop")
+			other, err := smb.BuildImmediateFSM()
+			Expect(err).NotTo(HaveOccurred())
+			other.AddTracer(traffic)
+			other.Start()
+			other.Dispatch(fsm.NewEvent("Start", nil))
+			clock.Advance(10 * time.Second)
+
+			// both machines are running, and stopping the first does not end the second's stay
+			Expect(traffic.TimeIn("running")).To(Equal(45*time.Second + 10*time.Second))
+			stateMachine.Dispatch(fsm.NewEvent("Stop", nil))
+			clock.Advance(5 * time.Second)
+			Expect(traffic.TimeIn("running")).To(Equal(45*time.Second + 15*time.Second))
+			Expect(traffic.TimeIn("idle")).To(Equal(20 * time.Second))
+		})
+	})
+
+	When("rendering plantuml", func() {
+		It("should draw the current state, hot transitions and time in state", func() {
+			Expect(fsm.RenderPlantUML(buf, stateMachine, fsm.AnnotateCurrentState(), fsm.AnnotateTraffic(traffic))).To(Succeed())
+			fmt.Fprintf(GinkgoWriter, "%s\n", buf.String())
+			Expect(buf.String()).To(Equal(`@startuml
+[*] -[#8e536e,thickness=3]-> idle : (1)
+idle : entries: 2
+idle : time: 15s
+idle -[#d73027,thickness=4]-> running : Start (2)
+state running #gold
+running : busy
+running : entries: 2
+running : time: 35s
+running -[#8e536e,thickness=3]-> idle : Stop (1)
+running -[#a0a0a0,thickness=1]-> idle : (0)
+@enduml
+`))
+		})
+
+		It("should take entries from a StateCounter and transitions from a CoverageTracer", func() {
+			Expect(fsm.RenderPlantUML(buf, stateMachine, fsm.AnnotateStateCounts(counter), fsm.AnnotateTransitionCounts(coverage))).To(Succeed())
+			Expect(buf.String()).To(ContainSubstring("running : entries: 2\n"))
+			Expect(buf.String()).To(ContainSubstring("idle -[#d73027,thickness=4]-> running : Start (2)\n"))
+			Expect(buf.String()).NotTo(ContainSubstring("time:"))
+			Expect(buf.String()).NotTo(ContainSubstring("#gold"))
+		})
+
+		It("should draw the static structure without options", func() {
+			Expect(fsm.RenderPlantUML(buf, stateMachine)).To(Succeed())
+			Expect(buf.String()).To(ContainSubstring("running --> idle : Stop\n"))
+		})
+	})
+
+	When("rendering dot", func() {
+		It("should colour and thicken edges and note states", func() {
+			Expect(fsm.RenderDOT(buf, stateMachine, fsm.DOTAnnotate(fsm.AnnotateCurrentState(), fsm.AnnotateTraffic(traffic)))).To(Succeed())
+			fmt.Fprintf(GinkgoWriter, "%s\n", buf.String())
+			Expect(buf.String()).To(Equal(`digraph fsm {
+  node [shape=box, style=rounded];
+  "initial" [shape=point, width=0.2];
+  "idle" [label="idle\nentries: 2\ntime: 15s"];
+  "running" [label="running\nbusy\nentries: 2\ntime: 35s", style="rounded,filled,bold", fillcolor=gold];
+  "initial" -> "idle" [label="(1)", color="#8e536e", penwidth=3];
+  "idle" -> "running" [label="Start (2)", color="#d73027", penwidth=4];
+  "running" -> "idle" [label="Stop (1)", color="#8e536e", penwidth=3];
+  "running" -> "idle" [label="after 1m0s (0)", color="#a0a0a0", penwidth=1];
+}
+`))
+		})
+	})
+
+	When("rendering mermaid", func() {
+		It("should label transitions with counts and class the current state", func() {
+			Expect(fsm.RenderMermaid(buf, stateMachine, fsm.MermaidAnnotate(fsm.AnnotateCurrentState(), fsm.AnnotateTraffic(traffic)))).To(Succeed())
+			fmt.Fprintf(GinkgoWriter, "%s\n", buf.String())
+			Expect(buf.String()).To(Equal(`stateDiagram-v2
+    note right of idle
+        entries: 2
+        time: 15s
+    end note
+    note right of running
+        busy
+        entries: 2
+        time: 35s
+    end note
+    [*] --> idle : (1)
+    idle --> running : Start (2)
+    running --> idle : Stop (1)
+    running --> idle : after(1m0s) (0)
+    classDef current fill:gold,font-weight:bold
+    class running current
+`))
+		})
+	})
+
+	When("rendering with another visitor", func() {
+		It("should give it the same annotations", func() {
+			visitor := &headlineVisitor{annotations: fsm.NewAnnotations(stateMachine, fsm.AnnotateCurrentState(), fsm.AnnotateTraffic(traffic))}
+			stateMachine.Visit(visitor)
+			Expect(visitor.lines).To(Equal([]string{
+				"initial []",
+				"initial -> idle 1 0.50",
+				"idle [entries: 2 time: 15s]",
+				"idle -> running 2 1.00",
+				"running * [entries: 2 time: 35s]",
+				"running -> idle 1 0.50",
+				"running -> idle 0 0.00",
+			}))
+		})
+	})
+})
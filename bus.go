@@ -0,0 +1,356 @@
+package fsm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	ErrUnknownMachine    = errors.New("no machine registered with that name")
+	ErrMachineRegistered = errors.New("a machine is already registered with that name")
+)
+
+type MessageKind string
+
+const (
+	MessageSend      MessageKind = "send"
+	MessagePublish   MessageKind = "publish"
+	MessageBroadcast MessageKind = "broadcast"
+)
+
+// Message is the delivery of an event from one machine to another.  Published and broadcast
+// events produce one message per receiver, sharing a Seq.
+type Message struct {
+	Seq   uint64
+	Kind  MessageKind
+	From  string
+	To    string
+	Topic string // the prefix the receiver subscribed to, for published messages
+	Event Event
+}
+
+// BusTracer is told about every message delivered by a Bus, before the receiver is given the event.
+type BusTracer interface {
+	OnSend(msg Message)
+}
+
+// Receiver is anything that can be registered on a Bus, usually an FSM.  It is dispatched to
+// from the bus's own go routines, so it must be safe to dispatch to concurrently: a threaded
+// machine or a Runtime instance rather than an immediate machine.
+type Receiver interface {
+	Dispatch(Event)
+}
+
+// errorReporter is implemented by the machines, so that the bus can report failures to send an
+// event to the sender's ErrorTracers.  It is called from the sender's actions, on the sender's
+// go routine.
+type errorReporter interface {
+	reportError(err error)
+}
+
+func (f *immediateFSMImpl) reportError(err error) {
+	f.traceError(err, f.currentState, f.fsmData)
+}
+
+// mailbox queues the events delivered to one machine, and dispatches them in order from a
+// go routine that runs while any are pending.  It is unbounded so that machines sending to each
+// other from their actions never block each other.
+type mailbox struct {
+	mx       sync.Mutex
+	receiver Receiver
+	pending  []Event
+	draining bool
+}
+
+func (m *mailbox) post(ev Event) {
+	m.mx.Lock()
+	m.pending = append(m.pending, ev)
+	if m.draining {
+		m.mx.Unlock()
+		return
+	}
+	m.draining = true
+	m.mx.Unlock()
+	go m.drain()
+}
+
+func (m *mailbox) drain() {
+	for {
+		m.mx.Lock()
+		if len(m.pending) == 0 {
+			m.draining = false
+			m.mx.Unlock()
+			return
+		}
+		ev := m.pending[0]
+		m.pending = m.pending[1:]
+		m.mx.Unlock()
+		m.receiver.Dispatch(ev)
+	}
+}
+
+type subscription struct {
+	machine string
+	prefix  string
+}
+
+// Bus routes events between machines registered under names.  Events can be sent to a named
+// machine, published to the machines subscribed to a prefix of the event name, or broadcast to
+// every other machine.
+//
+// Delivery posts the event to the receiver's mailbox and returns without waiting for the receiver
+// to take it, so machines can send to each other freely.  As each machine sends from a single go
+// routine, events sent from one machine to another arrive in the order they were sent.  A failure
+// to send is returned, and reported to the sender's ErrorTracers if the sender is a machine
+// registered on the bus.
+type Bus struct {
+	mx            sync.RWMutex
+	machines      map[string]*mailbox
+	subscriptions []subscription
+	tracers       []BusTracer
+	nextSeq       uint64
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		machines:      make(map[string]*mailbox),
+		subscriptions: []subscription{},
+		tracers:       []BusTracer{},
+	}
+}
+
+func (b *Bus) Register(name string, machine Receiver) error {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	if _, ok := b.machines[name]; ok {
+		return fmt.Errorf("registering %s: %w", name, ErrMachineRegistered)
+	}
+	b.machines[name] = &mailbox{receiver: machine}
+	return nil
+}
+
+// Unregister removes a machine and its subscriptions from the bus.
+func (b *Bus) Unregister(name string) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	delete(b.machines, name)
+	kept := b.subscriptions[:0]
+	for _, s := range b.subscriptions {
+		if s.machine != name {
+			kept = append(kept, s)
+		}
+	}
+	b.subscriptions = kept
+}
+
+// Subscribe delivers published events whose names start with prefix to the named machine.
+// An empty prefix subscribes to every published event.
+func (b *Bus) Subscribe(name, prefix string) error {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	if _, ok := b.machines[name]; !ok {
+		return fmt.Errorf("subscribing %s: %w", name, ErrUnknownMachine)
+	}
+	for _, s := range b.subscriptions {
+		if s.machine == name && s.prefix == prefix {
+			return nil
+		}
+	}
+	b.subscriptions = append(b.subscriptions, subscription{machine: name, prefix: prefix})
+	return nil
+}
+
+func (b *Bus) Unsubscribe(name, prefix string) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	for idx, s := range b.subscriptions {
+		if s.machine == name && s.prefix == prefix {
+			b.subscriptions = append(b.subscriptions[:idx], b.subscriptions[idx+1:]...)
+			return
+		}
+	}
+}
+
+func (b *Bus) AddTracer(t BusTracer) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	b.tracers = append(b.tracers, t)
+}
+
+// Endpoint returns the sending side of the named machine, for use in its actions and effects.
+// The machine does not need to be registered until the endpoint is used.
+func (b *Bus) Endpoint(name string) Endpoint {
+	return Endpoint{bus: b, name: name}
+}
+
+// Send delivers an event to the named machine.
+func (b *Bus) Send(from, to string, ev Event) error {
+	b.mx.RLock()
+	machine, ok := b.machines[to]
+	sender := b.machines[from]
+	tracers := b.tracers
+	b.mx.RUnlock()
+	if !ok {
+		err := fmt.Errorf("sending %s to %s: %w", ev.Name(), to, ErrUnknownMachine)
+		if sender != nil {
+			if reporter, ok := sender.receiver.(errorReporter); ok {
+				reporter.reportError(err)
+			}
+		}
+		return err
+	}
+	b.deliver(tracers, Message{Kind: MessageSend, From: from, To: to, Event: ev}, machine)
+	return nil
+}
+
+// Publish delivers an event to every machine subscribed to a prefix of its name, other than
+// the sender.  A machine with several matching subscriptions receives the event once.
+func (b *Bus) Publish(from string, ev Event) {
+	b.mx.RLock()
+	receivers := []string{}
+	topics := make(map[string]string)
+	for _, s := range b.subscriptions {
+		if s.machine == from || !strings.HasPrefix(ev.Name(), s.prefix) {
+			continue
+		}
+		if _, ok := topics[s.machine]; !ok {
+			receivers = append(receivers, s.machine)
+			topics[s.machine] = s.prefix
+		}
+	}
+	machines := b.lookup(receivers)
+	tracers := b.tracers
+	b.mx.RUnlock()
+
+	msg := Message{Kind: MessagePublish, From: from, Event: ev, Seq: b.seq()}
+	for idx, name := range receivers {
+		msg.To = name
+		msg.Topic = topics[name]
+		b.deliver(tracers, msg, machines[idx])
+	}
+}
+
+// Broadcast delivers an event to every machine other than the sender, in name order.
+func (b *Bus) Broadcast(from string, ev Event) {
+	b.mx.RLock()
+	receivers := make([]string, 0, len(b.machines))
+	for name := range b.machines {
+		if name != from {
+			receivers = append(receivers, name)
+		}
+	}
+	sort.Strings(receivers)
+	machines := b.lookup(receivers)
+	tracers := b.tracers
+	b.mx.RUnlock()
+
+	msg := Message{Kind: MessageBroadcast, From: from, Event: ev, Seq: b.seq()}
+	for idx, name := range receivers {
+		msg.To = name
+		b.deliver(tracers, msg, machines[idx])
+	}
+}
+
+// lookup must be called with b.mx held
+func (b *Bus) lookup(names []string) []*mailbox {
+	machines := make([]*mailbox, len(names))
+	for idx, name := range names {
+		machines[idx] = b.machines[name]
+	}
+	return machines
+}
+
+func (b *Bus) seq() uint64 {
+	return atomic.AddUint64(&b.nextSeq, 1)
+}
+
+func (b *Bus) deliver(tracers []BusTracer, msg Message, machine *mailbox) {
+	if msg.Seq == 0 {
+		msg.Seq = b.seq()
+	}
+	for _, t := range tracers {
+		t.OnSend(msg)
+	}
+	machine.post(msg.Event)
+}
+
+// Endpoint sends events on a Bus on behalf of one machine.
+type Endpoint struct {
+	bus  *Bus
+	name string
+}
+
+func (e Endpoint) Name() string {
+	return e.name
+}
+
+func (e Endpoint) Send(to string, ev Event) error {
+	return e.bus.Send(e.name, to, ev)
+}
+
+func (e Endpoint) Publish(ev Event) {
+	e.bus.Publish(e.name, ev)
+}
+
+func (e Endpoint) Broadcast(ev Event) {
+	e.bus.Broadcast(e.name, ev)
+}
+
+// MessageRecorder is a BusTracer that keeps every message, so that the flow of events between
+// machines can be inspected or rendered as a sequence diagram.
+type MessageRecorder struct {
+	mx       sync.Mutex
+	messages []Message
+}
+
+func NewMessageRecorder() *MessageRecorder {
+	return &MessageRecorder{
+		messages: []Message{},
+	}
+}
+
+func (r *MessageRecorder) OnSend(msg Message) {
+	r.mx.Lock()
+	r.messages = append(r.messages, msg)
+	r.mx.Unlock()
+}
+
+// Messages returns the recorded messages in the order they were sent.
+func (r *MessageRecorder) Messages() []Message {
+	r.mx.Lock()
+	messages := append([]Message{}, r.messages...)
+	r.mx.Unlock()
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Seq < messages[j].Seq
+	})
+	return messages
+}
+
+// RenderPlantUMLSequence writes the recorded messages as a PlantUML sequence diagram.
+func (r *MessageRecorder) RenderPlantUMLSequence(w io.Writer) error {
+	_, err := fmt.Fprintln(w, "@startuml")
+	if err != nil {
+		return err
+	}
+	for _, msg := range r.Messages() {
+		arrow := "->"
+		if msg.Kind != MessageSend {
+			arrow = "->>"
+		}
+		label := msg.Event.Name()
+		if msg.Topic != "" {
+			label += fmt.Sprintf(" [%s*]", msg.Topic)
+		}
+		_, err = fmt.Fprintf(w, "%q %s %q : %s\n", msg.From, arrow, msg.To, label)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintln(w, "@enduml")
+	return err
+}
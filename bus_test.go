@@ -0,0 +1,234 @@
+package fsm_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	fsm "github.com/johngrange/gofsm"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type eventCollector struct {
+	mx     sync.Mutex
+	events []string
+}
+
+func (c *eventCollector) Dispatch(ev fsm.Event) {
+	c.mx.Lock()
+	c.events = append(c.events, ev.Name())
+	c.mx.Unlock()
+}
+
+func (c *eventCollector) received() []string {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return append([]string{}, c.events...)
+}
+
+var _ = Describe("Bus", func() {
+	var (
+		bus      *fsm.Bus
+		recorder *fsm.MessageRecorder
+	)
+
+	BeforeEach(func() {
+		bus = fsm.NewBus()
+		recorder = fsm.NewMessageRecorder()
+		bus.AddTracer(recorder)
+	})
+
+	It("should route events between named machines", func() {
+		door := bus.Endpoint("door")
+		doorBuilder := fsm.NewFSMBuilder()
+		closed := doorBuilder.NewState("closed")
+		open := doorBuilder.NewState("open")
+		doorBuilder.GetInitialState().AddTransition(closed)
+		closed.AddTransition(open).SetEventTrigger("evOpen").SetEffect(
+			func(ev fsm.Event, fsmData interface{}, dispatcher fsm.Dispatcher) {
+				Expect(door.Send("controller", fsm.NewEvent("evDoorOpened", nil))).To(Succeed())
+			})
+
+		controller := bus.Endpoint("controller")
+		controllerBuilder := fsm.NewFSMBuilder()
+		idle := controllerBuilder.NewState("idle")
+		waiting := controllerBuilder.NewState("waitingForDoor")
+		running := controllerBuilder.NewState("running")
+		controllerBuilder.GetInitialState().AddTransition(idle)
+		idle.AddTransition(waiting).SetEventTrigger("evRequest").SetEffect(
+			func(ev fsm.Event, fsmData interface{}, dispatcher fsm.Dispatcher) {
+				Expect(controller.Send("door", fsm.NewEvent("evOpen", nil))).To(Succeed())
+			})
+		waiting.AddTransition(running).SetEventTrigger("evDoorOpened").SetEffect(
+			func(ev fsm.Event, fsmData interface{}, dispatcher fsm.Dispatcher) {
+				controller.Publish(fsm.NewEvent("motor.start", nil))
+			})
+
+		doorSM, err := doorBuilder.BuildThreadedFSM()
+		Expect(err).NotTo(HaveOccurred())
+		controllerSM, err := controllerBuilder.BuildThreadedFSM()
+		Expect(err).NotTo(HaveOccurred())
+		motor := &eventCollector{}
+		Expect(bus.Register("door", doorSM)).To(Succeed())
+		Expect(bus.Register("controller", controllerSM)).To(Succeed())
+		Expect(bus.Register("motor", motor)).To(Succeed())
+		Expect(bus.Subscribe("motor", "motor.")).To(Succeed())
+		doorSM.Start()
+		defer doorSM.Stop()
+		controllerSM.Start()
+		defer controllerSM.Stop()
+
+		controllerSM.Dispatch(fsm.NewEvent("evRequest", nil))
+		Eventually(func() string { return controllerSM.CurrentState().Name() }).Should(Equal("running"))
+		Eventually(func() string { return doorSM.CurrentState().Name() }).Should(Equal("open"))
+		Eventually(motor.received).Should(Equal([]string{"motor.start"}))
+
+		messages := recorder.Messages()
+		Expect(messages).To(HaveLen(3))
+		flow := []string{}
+		for _, msg := range messages {
+			flow = append(flow, fmt.Sprintf("%s %s->%s %s", msg.Kind, msg.From, msg.To, msg.Event.Name()))
+		}
+		Expect(flow).To(Equal([]string{
+			"send controller->door evOpen",
+			"send door->controller evDoorOpened",
+			"publish controller->motor motor.start",
+		}))
+		Expect(messages[2].Topic).To(Equal("motor."))
+
+		var diagram bytes.Buffer
+		Expect(recorder.RenderPlantUMLSequence(&diagram)).To(Succeed())
+		Expect(diagram.String()).To(Equal(`@startuml
+"controller" -> "door" : evOpen
+"door" -> "controller" : evDoorOpened
+"controller" ->> "motor" : motor.start [motor.*]
+@enduml
+`))
+	})
+	It("should reject unknown and duplicate machines", func() {
+		Expect(bus.Register("door", &eventCollector{})).To(Succeed())
+		err := bus.Register("door", &eventCollector{})
+		Expect(errors.Is(err, fsm.ErrMachineRegistered)).To(BeTrue())
+		err = bus.Send("door", "motor", fsm.NewEvent("evStart", nil))
+		Expect(errors.Is(err, fsm.ErrUnknownMachine)).To(BeTrue())
+		Expect(errors.Is(bus.Subscribe("motor", "ev"), fsm.ErrUnknownMachine)).To(BeTrue())
+		Expect(recorder.Messages()).To(BeEmpty())
+	})
+	It("should publish by event name prefix to subscribers other than the sender", func() {
+		a, b, c := &eventCollector{}, &eventCollector{}, &eventCollector{}
+		Expect(bus.Register("a", a)).To(Succeed())
+		Expect(bus.Register("b", b)).To(Succeed())
+		Expect(bus.Register("c", c)).To(Succeed())
+		Expect(bus.Subscribe("a", "alarm.")).To(Succeed())
+		Expect(bus.Subscribe("b", "alarm.")).To(Succeed())
+		Expect(bus.Subscribe("b", "alarm.fire")).To(Succeed())
+		Expect(bus.Subscribe("c", "status.")).To(Succeed())
+
+		bus.Endpoint("a").Publish(fsm.NewEvent("alarm.fire", nil))
+		bus.Endpoint("c").Publish(fsm.NewEvent("alarm.flood", nil))
+		Eventually(a.received).Should(Equal([]string{"alarm.flood"}))
+		Eventually(b.received).Should(Equal([]string{"alarm.fire", "alarm.flood"}))
+		Expect(c.received()).To(BeEmpty())
+
+		bus.Unsubscribe("b", "alarm.")
+		bus.Unsubscribe("b", "alarm.fire")
+		bus.Endpoint("c").Publish(fsm.NewEvent("alarm.fire", nil))
+		Consistently(b.received).Should(HaveLen(2))
+	})
+	It("should broadcast to every other machine", func() {
+		a, b, c := &eventCollector{}, &eventCollector{}, &eventCollector{}
+		Expect(bus.Register("a", a)).To(Succeed())
+		Expect(bus.Register("b", b)).To(Succeed())
+		Expect(bus.Register("c", c)).To(Succeed())
+		bus.Unregister("c")
+
+		bus.Endpoint("a").Broadcast(fsm.NewEvent("evShutdown", nil))
+		Eventually(b.received).Should(Equal([]string{"evShutdown"}))
+		Expect(a.received()).To(BeEmpty())
+		Expect(c.received()).To(BeEmpty())
+		messages := recorder.Messages()
+		Expect(messages).To(HaveLen(1))
+		Expect(messages[0].Kind).To(Equal(fsm.MessageBroadcast))
+	})
+	It("should deliver events between a pair of machines in order", func() {
+		const count = 200
+		sb := fsm.NewFSMBuilder()
+		sb.GetInitialState().OnEntry(func(state fsm.State, fsmData interface{}, dispatcher fsm.Dispatcher) {
+			sender := bus.Endpoint("sender")
+			for i := 0; i < count; i++ {
+				Expect(sender.Send("receiver", fsm.NewEvent(fmt.Sprint(i), nil))).To(Succeed())
+			}
+		})
+		sender, err := sb.BuildThreadedFSM()
+		Expect(err).NotTo(HaveOccurred())
+		receiver := &eventCollector{}
+		Expect(bus.Register("sender", sender)).To(Succeed())
+		Expect(bus.Register("receiver", receiver)).To(Succeed())
+		sender.Start()
+		defer sender.Stop()
+
+		expected := []string{}
+		for i := 0; i < count; i++ {
+			expected = append(expected, fmt.Sprint(i))
+		}
+		Eventually(receiver.received).Should(Equal(expected))
+	})
+	It("should not block threaded machines sending more events to each other than they can queue", func() {
+		const count = 200
+		build := func(name, peer string, received *int32) fsm.FSM {
+			endpoint := bus.Endpoint(name)
+			smb := fsm.NewFSMBuilder()
+			ready := smb.NewState("ready")
+			smb.GetInitialState().AddTransition(ready).SetEffect(
+				func(ev fsm.Event, fsmData interface{}, dispatcher fsm.Dispatcher) {
+					for i := 0; i < count; i++ {
+						Expect(endpoint.Send(peer, fsm.NewEvent("evMessage", nil))).To(Succeed())
+					}
+				})
+			ready.AddTransition(ready).SetEventTrigger("evMessage").SetEffect(
+				func(ev fsm.Event, fsmData interface{}, dispatcher fsm.Dispatcher) {
+					atomic.AddInt32(received, 1)
+				})
+			sm, err := smb.BuildThreadedFSM()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(bus.Register(name, sm)).To(Succeed())
+			return sm
+		}
+		var pingReceived, pongReceived int32
+		ping := build("ping", "pong", &pingReceived)
+		pong := build("pong", "ping", &pongReceived)
+		ping.Start()
+		defer ping.Stop()
+		pong.Start()
+		defer pong.Stop()
+
+		Eventually(func() int32 { return atomic.LoadInt32(&pingReceived) }).Should(BeNumerically("==", count))
+		Eventually(func() int32 { return atomic.LoadInt32(&pongReceived) }).Should(BeNumerically("==", count))
+	})
+	It("should report sends to unknown machines to the sender's error tracers", func() {
+		door := bus.Endpoint("door")
+		smb := fsm.NewFSMBuilder()
+		closed := smb.NewState("closed")
+		smb.GetInitialState().AddTransition(closed)
+		closed.AddTransition(closed).SetEventTrigger("evOpen").SetEffect(
+			func(ev fsm.Event, fsmData interface{}, dispatcher fsm.Dispatcher) {
+				err := door.Send("controller", fsm.NewEvent("evDoorOpened", nil))
+				Expect(errors.Is(err, fsm.ErrUnknownMachine)).To(BeTrue())
+			})
+		sm, err := smb.BuildImmediateFSM()
+		Expect(err).NotTo(HaveOccurred())
+		errs := &errorRecorder{StateCounter: *fsm.NewStateCounter()}
+		sm.AddTracer(errs)
+		Expect(bus.Register("door", sm)).To(Succeed())
+		sm.Start()
+
+		sm.Dispatch(fsm.NewEvent("evOpen", nil))
+		Expect(errs.errs).To(HaveLen(1))
+		Expect(errors.Is(errs.errs[0], fsm.ErrUnknownMachine)).To(BeTrue())
+		Expect(errs.errs[0].Error()).To(Equal("sending evDoorOpened to controller: no machine registered with that name"))
+		Expect(recorder.Messages()).To(BeEmpty())
+	})
+})
@@ -0,0 +1,50 @@
+package fsm
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock provides the time used to evaluate timed transitions.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// RealClock returns the default Clock, which uses time.Now.
+func RealClock() Clock {
+	return realClock{}
+}
+
+// FakeClock is a Clock that only moves when told to, for tests and replay.
+type FakeClock struct {
+	mx  sync.RWMutex
+	now time.Time
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mx.RLock()
+	defer c.mx.RUnlock()
+	return c.now
+}
+
+func (c *FakeClock) Set(now time.Time) {
+	c.mx.Lock()
+	c.now = now
+	c.mx.Unlock()
+}
+
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mx.Lock()
+	c.now = c.now.Add(d)
+	c.mx.Unlock()
+}
@@ -0,0 +1,362 @@
+package fsm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const coverageVersion = 1
+
+var ErrCoverageMismatch = errors.New("coverage reports are for different machine definitions")
+
+// StateCoverage counts the entries to a state.
+type StateCoverage struct {
+	Name    string `json:"name"`
+	Entries uint64 `json:"entries"`
+}
+
+// TransitionCoverage counts the outcomes of a transition.  For guarded transitions, Taken counts
+// the times the guard was true and Blocked the times it was false when the trigger was satisfied.
+// Eventless and timed transitions are re-evaluated while their source state is occupied, so they
+// count as Blocked at most once per entry to the state.
+type TransitionCoverage struct {
+	Source  string        `json:"source"`
+	Index   int           `json:"index"` // index of the transition in the source state
+	Target  string        `json:"target"`
+	Trigger TriggerType   `json:"trigger"`
+	Event   string        `json:"event,omitempty"`
+	Timer   time.Duration `json:"timer,omitempty"` // nanoseconds
+	Guard   string        `json:"guard,omitempty"`
+	Guarded bool          `json:"guarded,omitempty"`
+	Taken   uint64        `json:"taken"`
+	Blocked uint64        `json:"blocked"`
+}
+
+func (t TransitionCoverage) String() string {
+	s := fmt.Sprintf("%s --> %s", t.Source, t.Target)
+	switch t.Trigger {
+	case EventTrigger:
+		s += " : " + t.Event
+	case TimerTrigger:
+		s += " : after " + t.Timer.String()
+	}
+	if t.Guarded {
+		s += " [" + t.guardLabel() + "]"
+	}
+	return s
+}
+
+func (t TransitionCoverage) guardLabel() string {
+	if t.Guard == "" {
+		return "guard"
+	}
+	return t.Guard
+}
+
+// GuardCovered returns true if the transition is unguarded, or its guard has been seen both true
+// and false.
+func (t TransitionCoverage) GuardCovered() bool {
+	return !t.Guarded || (t.Taken > 0 && t.Blocked > 0)
+}
+
+func (t TransitionCoverage) sameTransition(other TransitionCoverage) bool {
+	return t.Source == other.Source && t.Index == other.Index && t.Target == other.Target &&
+		t.Trigger == other.Trigger && t.Event == other.Event && t.Timer == other.Timer
+}
+
+// CoverageReport is the coverage of a machine definition, in a form that can be saved and merged
+// with the reports of other runs.
+type CoverageReport struct {
+	Version     int                  `json:"version"`
+	States      []StateCoverage      `json:"states"`
+	Transitions []TransitionCoverage `json:"transitions"`
+}
+
+// CoverageTracer records which states and transitions of a machine definition are exercised.  It
+// may be added to any number of machines built from the same definition.  Activity in states or
+// transitions that are not part of the definition is ignored.
+type CoverageTracer struct {
+	mx          sync.Mutex
+	report      CoverageReport
+	states      map[string]int // index into report.States by name
+	transitions map[string]int // index into report.Transitions by source and index
+}
+
+type coverageVisitor struct {
+	c *CoverageTracer
+}
+
+func (v coverageVisitor) VisitState(state State) {
+	v.c.states[state.Name()] = len(v.c.report.States)
+	v.c.report.States = append(v.c.report.States, StateCoverage{Name: state.Name()})
+}
+
+func (v coverageVisitor) VisitTransition(t Transition) {
+	tc := TransitionCoverage{
+		Source:  t.Source().Name(),
+		Index:   transitionIndex(t),
+		Target:  t.Target().Name(),
+		Trigger: t.TriggerType(),
+		Event:   t.EventName(),
+		Timer:   t.TimerDuration(),
+		Guard:   strings.Join(t.GuardLabels(), " "),
+		Guarded: t.IsGuarded(),
+	}
+	v.c.transitions[transitionKey(tc.Source, tc.Index)] = len(v.c.report.Transitions)
+	v.c.report.Transitions = append(v.c.report.Transitions, tc)
+}
+
+// NewCoverageTracer returns a tracer bound to the definition of machine.
+func NewCoverageTracer(machine Visitable) *CoverageTracer {
+	c := &CoverageTracer{
+		report: CoverageReport{
+			Version:     coverageVersion,
+			States:      []StateCoverage{},
+			Transitions: []TransitionCoverage{},
+		},
+		states:      make(map[string]int),
+		transitions: make(map[string]int),
+	}
+	machine.Visit(coverageVisitor{c: c})
+	return c
+}
+
+func transitionIndex(t Transition) int {
+	for idx, candidate := range t.Source().Transitions() {
+		if candidate == t {
+			return idx
+		}
+	}
+	return -1
+}
+
+func transitionKey(source string, index int) string {
+	return fmt.Sprintf("%s#%d", source, index)
+}
+
+func (c *CoverageTracer) OnEntry(state State, fsmData interface{}) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if idx, ok := c.states[state.Name()]; ok {
+		c.report.States[idx].Entries++
+	}
+}
+func (c *CoverageTracer) OnExit(state State, fsmData interface{}) {}
+func (c *CoverageTracer) OnTransition(ev Event, sourceState, targetState State, fsmData interface{}) {
+}
+func (c *CoverageTracer) OnRejectedEvent(ev Event, state State, fsmData interface{}) {}
+
+func (c *CoverageTracer) OnTransitionTaken(ev Event, transition Transition, fsmData interface{}) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if idx, ok := c.transitions[transitionKey(transition.Source().Name(), transitionIndex(transition))]; ok {
+		c.report.Transitions[idx].Taken++
+	}
+}
+
+func (c *CoverageTracer) OnTransitionBlocked(ev Event, transition Transition, fsmData interface{}) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if idx, ok := c.transitions[transitionKey(transition.Source().Name(), transitionIndex(transition))]; ok {
+		c.report.Transitions[idx].Blocked++
+	}
+}
+
+// Report returns a copy of the coverage recorded so far.
+func (c *CoverageTracer) Report() CoverageReport {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	r := c.report
+	r.States = append([]StateCoverage{}, c.report.States...)
+	r.Transitions = append([]TransitionCoverage{}, c.report.Transitions...)
+	return r
+}
+
+// MergeInto adds the coverage recorded so far to the report saved in a file, creating the file if
+// it does not exist.  Processes merging into the same file must not do so at the same time.
+func (c *CoverageTracer) MergeInto(path string) error {
+	merged := c.Report()
+	f, err := os.Open(path)
+	if err == nil {
+		saved, readErr := ReadCoverageReport(f)
+		f.Close()
+		if readErr != nil {
+			return readErr
+		}
+		if merged, err = saved.Merge(merged); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if err := merged.WriteJSON(tmp); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Merge returns the sum of two reports of the same machine definition.
+func (r CoverageReport) Merge(other CoverageReport) (CoverageReport, error) {
+	if len(r.States) != len(other.States) || len(r.Transitions) != len(other.Transitions) {
+		return CoverageReport{}, ErrCoverageMismatch
+	}
+	merged := CoverageReport{
+		Version:     coverageVersion,
+		States:      make([]StateCoverage, len(r.States)),
+		Transitions: make([]TransitionCoverage, len(r.Transitions)),
+	}
+	for idx, s := range r.States {
+		if s.Name != other.States[idx].Name {
+			return CoverageReport{}, ErrCoverageMismatch
+		}
+		s.Entries += other.States[idx].Entries
+		merged.States[idx] = s
+	}
+	for idx, t := range r.Transitions {
+		if !t.sameTransition(other.Transitions[idx]) {
+			return CoverageReport{}, ErrCoverageMismatch
+		}
+		t.Taken += other.Transitions[idx].Taken
+		t.Blocked += other.Transitions[idx].Blocked
+		merged.Transitions[idx] = t
+	}
+	return merged, nil
+}
+
+func (r CoverageReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func ReadCoverageReport(rd io.Reader) (CoverageReport, error) {
+	r := CoverageReport{}
+	if err := json.NewDecoder(rd).Decode(&r); err != nil {
+		return CoverageReport{}, err
+	}
+	if r.Version != coverageVersion {
+		return CoverageReport{}, fmt.Errorf("unsupported coverage report version %d", r.Version)
+	}
+	return r, nil
+}
+
+// UncoveredStates returns the names of the states that were never entered.
+func (r CoverageReport) UncoveredStates() []string {
+	names := []string{}
+	for _, s := range r.States {
+		if s.Entries == 0 {
+			names = append(names, s.Name)
+		}
+	}
+	return names
+}
+
+// UncoveredTransitions returns the transitions that were never taken.
+func (r CoverageReport) UncoveredTransitions() []TransitionCoverage {
+	uncovered := []TransitionCoverage{}
+	for _, t := range r.Transitions {
+		if t.Taken == 0 {
+			uncovered = append(uncovered, t)
+		}
+	}
+	return uncovered
+}
+
+// WriteText writes a summary of the coverage, listing what was not covered.
+func (r CoverageReport) WriteText(w io.Writer) error {
+	uncoveredStates := r.UncoveredStates()
+	uncoveredTransitions := r.UncoveredTransitions()
+	lines := []string{
+		fmt.Sprintf("states: %d of %d covered", len(r.States)-len(uncoveredStates), len(r.States)),
+		fmt.Sprintf("transitions: %d of %d covered", len(r.Transitions)-len(uncoveredTransitions), len(r.Transitions)),
+		"uncovered states:",
+	}
+	for _, name := range uncoveredStates {
+		lines = append(lines, "  "+name)
+	}
+	lines = append(lines, "uncovered transitions:")
+	for _, t := range uncoveredTransitions {
+		lines = append(lines, "  "+t.String())
+	}
+	lines = append(lines, "guards not seen both true and false:")
+	for _, t := range r.Transitions {
+		switch {
+		case t.GuardCovered():
+		case t.Taken == 0:
+			lines = append(lines, "  "+t.String()+": never true")
+		default:
+			lines = append(lines, "  "+t.String()+": never false")
+		}
+	}
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RenderCoveragePlantUML draws the machine with each transition labelled with the times it was
+// taken.  Uncovered states and transitions are shown in red, and guarded transitions whose guard
+// was not seen both true and false are dashed.
+func RenderCoveragePlantUML(w io.Writer, r CoverageReport) error {
+	lines := []string{"@startuml"}
+	for _, s := range r.States {
+		if s.Entries == 0 && s.Name != InitialStateName && s.Name != FinalStateName {
+			lines = append(lines, fmt.Sprintf("state %s #pink", s.Name))
+		}
+	}
+	for _, t := range r.Transitions {
+		source, target := t.Source, t.Target
+		if source == InitialStateName {
+			source = InitialFinalStateSymbol
+		}
+		if target == FinalStateName {
+			target = InitialFinalStateSymbol
+		}
+		arrow := "-->"
+		switch {
+		case t.Taken == 0:
+			arrow = "-[#red,bold]->"
+		case !t.GuardCovered():
+			arrow = "-[#orange,dashed]->"
+		}
+		label := ""
+		switch t.Trigger {
+		case EventTrigger:
+			label = t.Event + " "
+		case TimerTrigger:
+			label = "after " + t.Timer.String() + " "
+		}
+		if t.Guarded {
+			label += fmt.Sprintf("[%s] ", t.guardLabel())
+		}
+		label += fmt.Sprintf("(%d)", t.Taken)
+		lines = append(lines, fmt.Sprintf("%s %s %s : %s", source, arrow, target, label))
+	}
+	lines = append(lines, "@enduml")
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package fsm_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"time"
+
+	fsm "github.com/johngrange/gofsm"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Coverage tracer", func() {
+	type fsmData struct {
+		paid bool
+	}
+
+	var (
+		clock      *fsm.FakeClock
+		newMachine func(data *fsmData) fsm.ImmediateFSM
+	)
+
+	BeforeEach(func() {
+		clock = fsm.NewFakeClock(time.Now())
+		newMachine = func(data *fsmData) fsm.ImmediateFSM {
+			smb := fsm.NewFSMBuilder().SetClock(clock).SetData(data)
+			idle := smb.NewState("idle")
+			accepting := smb.NewState("accepting")
+			printing := smb.NewState("printing")
+			smb.GetInitialState().AddTransition(idle)
+			idle.AddTransition(accepting).SetEventTrigger("evCoin")
+			accepting.AddTransition(printing).SetEventTrigger("evPrint").SetGuard(
+				func(data, eventData interface{}) bool { return data.(*fsmData).paid }, "paid")
+			accepting.AddTransition(idle).SetTimedTrigger(time.Minute)
+			printing.AddTransition(idle)
+			sm, err := smb.BuildImmediateFSM()
+			Expect(err).NotTo(HaveOccurred())
+			return sm
+		}
+	})
+
+	It("should record states entered and transition outcomes", func() {
+		data := &fsmData{}
+		sm := newMachine(data)
+		coverage := fsm.NewCoverageTracer(sm)
+		sm.AddTracer(coverage)
+		sm.Start()
+		sm.Dispatch(fsm.NewEvent("evCoin", nil))
+		sm.Dispatch(fsm.NewEvent("evPrint", nil)) // guard false
+		clock.Advance(2 * time.Minute)
+		sm.Tick()
+
+		r := coverage.Report()
+		Expect(r.States).To(Equal([]fsm.StateCoverage{
+			{Name: "initial", Entries: 1},
+			{Name: "idle", Entries: 2},
+			{Name: "accepting", Entries: 1},
+			{Name: "printing", Entries: 0},
+		}))
+		Expect(r.Transitions).To(HaveLen(5))
+		Expect(r.Transitions[2]).To(Equal(fsm.TransitionCoverage{
+			Source: "accepting", Index: 0, Target: "printing", Trigger: fsm.EventTrigger,
+			Event: "evPrint", Guard: "paid", Guarded: true, Taken: 0, Blocked: 1,
+		}))
+		Expect(r.Transitions[3].Trigger).To(Equal(fsm.TimerTrigger))
+		Expect(r.Transitions[3].Taken).To(BeEquivalentTo(1))
+		Expect(r.UncoveredStates()).To(Equal([]string{"printing"}))
+		Expect(r.UncoveredTransitions()).To(HaveLen(2))
+
+		buf := bytes.Buffer{}
+		Expect(r.WriteText(&buf)).To(Succeed())
+		Expect(buf.String()).To(Equal(`states: 3 of 4 covered
+transitions: 3 of 5 covered
+uncovered states:
+  printing
+uncovered transitions:
+  accepting --> printing : evPrint [paid]
+  printing --> idle
+guards not seen both true and false:
+  accepting --> printing : evPrint [paid]: never true
+`))
+	})
+
+	It("should record guards of eventless transitions that block", func() {
+		smb := fsm.NewFSMBuilder()
+		waiting := smb.NewState("waiting")
+		ready := false
+		smb.GetInitialState().AddTransition(waiting)
+		waiting.AddTransition(smb.AddFinalState()).SetGuard(
+			func(fsmData, eventData interface{}) bool { return ready })
+		sm, err := smb.BuildImmediateFSM()
+		Expect(err).NotTo(HaveOccurred())
+		coverage := fsm.NewCoverageTracer(sm)
+		sm.AddTracer(coverage)
+		sm.Start()
+		for i := 0; i < 5; i++ {
+			sm.Tick()
+		}
+		ready = true
+		sm.Tick()
+		r := coverage.Report()
+		Expect(r.Transitions[1].Blocked).To(BeEquivalentTo(1))
+		Expect(r.Transitions[1].Taken).To(BeEquivalentTo(1))
+		Expect(r.Transitions[1].GuardCovered()).To(BeTrue())
+		Expect(r.UncoveredStates()).To(BeEmpty())
+	})
+
+	It("should count blocked eventless transitions once per entry to their state", func() {
+		smb := fsm.NewFSMBuilder()
+		waiting := smb.NewState("waiting")
+		idle := smb.NewState("idle")
+		ready := false
+		smb.GetInitialState().AddTransition(waiting)
+		waiting.AddTransition(idle).SetGuard(
+			func(fsmData, eventData interface{}) bool { return ready })
+		waiting.AddTransition(idle).SetEventTrigger("evSkip")
+		idle.AddTransition(waiting).SetEventTrigger("evWait")
+		sm, err := smb.BuildThreadedFSM()
+		Expect(err).NotTo(HaveOccurred())
+		coverage := fsm.NewCoverageTracer(sm)
+		sm.AddTracer(coverage)
+		sm.Start()
+		defer sm.Stop()
+		blocked := func() uint64 { return coverage.Report().Transitions[1].Blocked }
+		// the threaded machine re-evaluates the guard every poll
+		Consistently(blocked, "50ms").Should(BeEquivalentTo(1))
+		sm.Dispatch(fsm.NewEvent("evSkip", nil))
+		sm.Dispatch(fsm.NewEvent("evWait", nil))
+		Eventually(blocked).Should(BeEquivalentTo(2))
+		Consistently(blocked, "50ms").Should(BeEquivalentTo(2))
+	})
+
+	When("merging runs", func() {
+		var first, second *fsm.CoverageTracer
+
+		BeforeEach(func() {
+			sm := newMachine(&fsmData{})
+			first = fsm.NewCoverageTracer(sm)
+			sm.AddTracer(first)
+			sm.Start()
+			sm.Dispatch(fsm.NewEvent("evCoin", nil))
+			sm.Dispatch(fsm.NewEvent("evPrint", nil))
+
+			// a different machine with the same definition
+			sm = newMachine(&fsmData{paid: true})
+			second = fsm.NewCoverageTracer(sm)
+			sm.AddTracer(second)
+			sm.Start()
+			sm.Dispatch(fsm.NewEvent("evCoin", nil))
+			sm.Dispatch(fsm.NewEvent("evPrint", nil))
+		})
+
+		It("should add the counts", func() {
+			r, err := first.Report().Merge(second.Report())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(r.States[1]).To(Equal(fsm.StateCoverage{Name: "idle", Entries: 3}))
+			Expect(r.Transitions[2].Taken).To(BeEquivalentTo(1))
+			Expect(r.Transitions[2].Blocked).To(BeEquivalentTo(1))
+			Expect(r.Transitions[2].GuardCovered()).To(BeTrue())
+			Expect(r.UncoveredTransitions()).To(HaveLen(1))
+		})
+		It("should refuse to merge reports of different definitions", func() {
+			smb := fsm.NewFSMBuilder()
+			smb.GetInitialState().AddTransition(smb.NewState("other"))
+			sm, err := smb.BuildImmediateFSM()
+			Expect(err).NotTo(HaveOccurred())
+			_, err = first.Report().Merge(fsm.NewCoverageTracer(sm).Report())
+			Expect(err).To(MatchError(fsm.ErrCoverageMismatch))
+		})
+		It("should merge through a file", func() {
+			path := filepath.Join(GinkgoT().TempDir(), "coverage.json")
+			Expect(first.MergeInto(path)).To(Succeed())
+			Expect(second.MergeInto(path)).To(Succeed())
+			f, err := os.Open(path)
+			Expect(err).NotTo(HaveOccurred())
+			defer f.Close()
+			r, err := fsm.ReadCoverageReport(f)
+			Expect(err).NotTo(HaveOccurred())
+			expected, _ := first.Report().Merge(second.Report())
+			Expect(r).To(Equal(expected))
+		})
+	})
+
+	It("should render uncovered transitions in PlantUML", func() {
+		sm := newMachine(&fsmData{})
+		coverage := fsm.NewCoverageTracer(sm)
+		sm.AddTracer(coverage)
+		sm.Start()
+		sm.Dispatch(fsm.NewEvent("evCoin", nil))
+		sm.Dispatch(fsm.NewEvent("evPrint", nil))
+		buf := bytes.Buffer{}
+		Expect(fsm.RenderCoveragePlantUML(&buf, coverage.Report())).To(Succeed())
+		Expect(buf.String()).To(Equal(`@startuml
+state printing #pink
+[*] --> idle : (1)
+idle --> accepting : evCoin (1)
+accepting -[#red,bold]-> printing : evPrint [paid] (0)
+accepting -[#red,bold]-> idle : after 1m0s (0)
+printing -[#red,bold]-> idle : (0)
+@enduml
+`))
+	})
+})
@@ -0,0 +1,451 @@
+package fsm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// machineDefinition is the schema of the JSON and YAML definition formats.  Guards, actions and
+// effects are referred to by the names they are registered under, which are also their labels.
+type machineDefinition struct {
+	Initial string            `json:"initial,omitempty" yaml:"initial,omitempty"` // the state the initial transition enters
+	States  []stateDefinition `json:"states" yaml:"states"`
+}
+
+type stateDefinition struct {
+	Name        string                 `json:"name" yaml:"name"`
+	Labels      []string               `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Entry       []string               `json:"entry,omitempty" yaml:"entry,omitempty"`
+	Exit        []string               `json:"exit,omitempty" yaml:"exit,omitempty"`
+	Transitions []transitionDefinition `json:"transitions,omitempty" yaml:"transitions,omitempty"`
+}
+
+type transitionDefinition struct {
+	Target  string   `json:"target" yaml:"target"`
+	Event   string   `json:"event,omitempty" yaml:"event,omitempty"`
+	After   string   `json:"after,omitempty" yaml:"after,omitempty"` // a duration such as 5m or 1m30s
+	Guard   string   `json:"guard,omitempty" yaml:"guard,omitempty"`
+	Guards  []string `json:"guards,omitempty" yaml:"guards,omitempty"` // several guards, all of which must be true
+	Effects []string `json:"effects,omitempty" yaml:"effects,omitempty"`
+	Labels  []string `json:"labels,omitempty" yaml:"labels,omitempty"`
+}
+
+// DefinitionError is a problem with a machine definition, at a JSON path such as
+// $.states[1].entry[0].
+type DefinitionError struct {
+	Path    string
+	Message string
+}
+
+func (e *DefinitionError) Error() string {
+	return fmt.Sprintf("definition %s: %s", e.Path, e.Message)
+}
+
+// LoadDefinition reads a machine definition in JSON, and returns a builder for it, binding
+// guards, actions and effects by name from the registry:
+//
+//	{
+//	  "initial": "idle",
+//	  "states": [
+//	    {"name": "idle", "transitions": [{"target": "busy", "event": "evStart", "guard": "ready"}]},
+//	    {"name": "busy", "labels": ["working"], "entry": ["startMotor"], "exit": ["stopMotor"],
+//	     "transitions": [{"target": "idle", "after": "5m", "effects": ["logTimeout"]}]}
+//	  ]
+//	}
+//
+// Without "initial", the initial transition enters the first state, unless a state named
+// "initial" is defined, giving the initial state's transitions in full.  A transition guarded by
+// several guards lists their names in "guards", and is taken only if all of them are true.  A
+// state named "FinalState" is the machine's final state.  Invariants are not part of the format.
+// Errors in the definition are returned as a *DefinitionError.
+func LoadDefinition(r io.Reader, registry *Registry) (StateMachineBuilder, error) {
+	var doc interface{}
+	dec := json.NewDecoder(r)
+	if err := dec.Decode(&doc); err != nil {
+		return nil, &DefinitionError{Path: "$", Message: err.Error()}
+	}
+	return loadDefinition(doc, registry)
+}
+
+// LoadDefinitionYAML reads a machine definition in YAML, with the same schema as LoadDefinition.
+func LoadDefinitionYAML(r io.Reader, registry *Registry) (StateMachineBuilder, error) {
+	var doc interface{}
+	if err := yaml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, &DefinitionError{Path: "$", Message: err.Error()}
+	}
+	return loadDefinition(doc, registry)
+}
+
+// SaveDefinition writes the machine's definition in JSON, as LoadDefinition reads it.  Labels
+// of guards, actions and effects are written as their names, and a guard with several labels as
+// the list of them in "guards".  A guard without labels is written as "guard", so such machines
+// only load again if a guard is registered under that name.
+func SaveDefinition(w io.Writer, stateMachine FSM) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(newMachineDefinition(stateMachine))
+}
+
+// SaveDefinitionYAML writes the machine's definition in YAML, as LoadDefinitionYAML reads it.
+func SaveDefinitionYAML(w io.Writer, stateMachine FSM) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(newMachineDefinition(stateMachine)); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+func newMachineDefinition(stateMachine FSM) machineDefinition {
+	visitor := definitionVisitor{}
+	stateMachine.Visit(&visitor)
+	transitions := make(map[string][]Transition)
+	for _, t := range visitor.transitions {
+		transitions[t.Source().Name()] = append(transitions[t.Source().Name()], t)
+	}
+	// a single plain initial transition is written as "initial", otherwise the initial state is
+	// written like any other
+	initial := transitions[InitialStateName]
+	plainInitial := len(initial) == 1 && !initial[0].IsGuarded() && len(initial[0].EffectLabels()) == 0 &&
+		len(transitionLabelList(initial[0])) == 0
+
+	def := machineDefinition{States: []stateDefinition{}}
+	if plainInitial {
+		def.Initial = initial[0].Target().Name()
+	}
+	for _, state := range visitor.states {
+		if state.Name() == InitialStateName && (plainInitial || len(initial) == 0) {
+			continue
+		}
+		s := stateDefinition{
+			Name:   state.Name(),
+			Labels: state.StateLabels(),
+			Entry:  state.EntryLabels(),
+			Exit:   state.ExitLabels(),
+		}
+		for _, t := range transitions[state.Name()] {
+			td := transitionDefinition{
+				Target:  t.Target().Name(),
+				Effects: t.EffectLabels(),
+				Labels:  transitionLabelList(t),
+			}
+			switch t.TriggerType() {
+			case EventTrigger:
+				td.Event = t.EventName()
+			case TimerTrigger:
+				td.After = t.TimerDuration().String()
+			}
+			switch labels := t.GuardLabels(); {
+			case len(labels) > 1:
+				td.Guards = labels
+			case len(labels) == 1:
+				td.Guard = labels[0]
+			case t.IsGuarded():
+				td.Guard = "guard"
+			}
+			s.Transitions = append(s.Transitions, td)
+		}
+		def.States = append(def.States, s)
+	}
+	return def
+}
+
+func transitionLabelList(t Transition) []string {
+	if l, ok := t.(interface{ Labels() []string }); ok {
+		return l.Labels()
+	}
+	return nil
+}
+
+// definitionLoader checks a decoded JSON or YAML document against the schema as it builds the
+// machine, so that errors can be reported with their path.
+type definitionLoader struct {
+	registry *Registry
+	smb      StateMachineBuilder
+	states   map[string]StateBuilder
+}
+
+func loadDefinition(doc interface{}, registry *Registry) (StateMachineBuilder, error) {
+	l := &definitionLoader{registry: registry, smb: NewFSMBuilder(), states: make(map[string]StateBuilder)}
+	root, err := definitionObject(doc, "$", "initial", "states")
+	if err != nil {
+		return nil, err
+	}
+	states, err := definitionList(root, "states", "$", true)
+	if err != nil {
+		return nil, err
+	}
+
+	// declare the states first, so that transitions can refer to states defined after them
+	objects := make([]map[string]interface{}, len(states))
+	first := ""
+	for idx, s := range states {
+		path := fmt.Sprintf("$.states[%d]", idx)
+		obj, err := definitionObject(s, path, "name", "labels", "entry", "exit", "transitions")
+		if err != nil {
+			return nil, err
+		}
+		objects[idx] = obj
+		name, err := definitionString(obj, "name", path, true)
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := l.states[name]; dup {
+			return nil, &DefinitionError{Path: path + ".name", Message: fmt.Sprintf("state %q is defined more than once", name)}
+		}
+		labels, err := definitionStrings(obj, "labels", path)
+		if err != nil {
+			return nil, err
+		}
+		switch name {
+		case InitialStateName, FinalStateName:
+			if len(labels) > 0 {
+				return nil, &DefinitionError{Path: path + ".labels", Message: fmt.Sprintf("state %q cannot have labels", name)}
+			}
+			if name == InitialStateName {
+				l.states[name] = l.smb.GetInitialState()
+			} else {
+				l.states[name] = l.smb.AddFinalState()
+			}
+		default:
+			l.states[name] = l.smb.NewState(name, labels...)
+			if first == "" {
+				first = name
+			}
+		}
+	}
+
+	_, explicit := l.states[InitialStateName]
+	initial, err := definitionString(root, "initial", "$", false)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case initial != "" && explicit:
+		return nil, &DefinitionError{Path: "$.initial", Message: fmt.Sprintf("given as well as a state named %q", InitialStateName)}
+	case initial != "":
+		target, ok := l.states[initial]
+		if !ok {
+			return nil, &DefinitionError{Path: "$.initial", Message: fmt.Sprintf("state %q is not defined", initial)}
+		}
+		l.smb.GetInitialState().AddTransition(target)
+	case !explicit && first != "":
+		l.smb.GetInitialState().AddTransition(l.states[first])
+	}
+
+	for idx, obj := range objects {
+		if err := l.defineState(obj, fmt.Sprintf("$.states[%d]", idx)); err != nil {
+			return nil, err
+		}
+	}
+	return l.smb, nil
+}
+
+func (l *definitionLoader) defineState(obj map[string]interface{}, path string) error {
+	name, _ := definitionString(obj, "name", path, true)
+	sb := l.states[name]
+	for _, key := range []string{"entry", "exit"} {
+		names, err := definitionStrings(obj, key, path)
+		if err != nil {
+			return err
+		}
+		if len(names) == 0 {
+			continue
+		}
+		actions := make([]Action, len(names))
+		for idx, n := range names {
+			action, ok := l.registry.actions[n]
+			if !ok {
+				return &DefinitionError{Path: fmt.Sprintf("%s.%s[%d]", path, key, idx), Message: fmt.Sprintf("action %q is not registered", n)}
+			}
+			actions[idx] = action
+		}
+		if key == "entry" {
+			sb.OnEntry(sequenceActions(actions), names...)
+		} else {
+			sb.OnExit(sequenceActions(actions), names...)
+		}
+	}
+
+	transitions, err := definitionList(obj, "transitions", path, false)
+	if err != nil {
+		return err
+	}
+	if len(transitions) > 0 && name == FinalStateName {
+		return &DefinitionError{Path: path + ".transitions", Message: "the final state cannot have transitions"}
+	}
+	for idx, t := range transitions {
+		if err := l.defineTransition(sb, t, fmt.Sprintf("%s.transitions[%d]", path, idx)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *definitionLoader) defineTransition(sb StateBuilder, t interface{}, path string) error {
+	obj, err := definitionObject(t, path, "target", "event", "after", "guard", "guards", "effects", "labels")
+	if err != nil {
+		return err
+	}
+	target, err := definitionString(obj, "target", path, true)
+	if err != nil {
+		return err
+	}
+	targetBuilder, ok := l.states[target]
+	if !ok {
+		return &DefinitionError{Path: path + ".target", Message: fmt.Sprintf("state %q is not defined", target)}
+	}
+	labels, err := definitionStrings(obj, "labels", path)
+	if err != nil {
+		return err
+	}
+	tb := sb.AddTransition(targetBuilder, labels...)
+
+	event, err := definitionString(obj, "event", path, false)
+	if err != nil {
+		return err
+	}
+	after, err := definitionString(obj, "after", path, false)
+	if err != nil {
+		return err
+	}
+	switch {
+	case event != "" && after != "":
+		return &DefinitionError{Path: path, Message: `a transition cannot have both "event" and "after"`}
+	case event != "":
+		tb.SetEventTrigger(event)
+	case after != "":
+		d, err := time.ParseDuration(after)
+		if err != nil || d <= 0 {
+			return &DefinitionError{Path: path + ".after", Message: fmt.Sprintf("invalid duration %q, expected a duration such as 5m or 1m30s", after)}
+		}
+		tb.SetTimedTrigger(d)
+	}
+
+	guard, err := definitionString(obj, "guard", path, false)
+	if err != nil {
+		return err
+	}
+	if guard != "" {
+		g, ok := l.registry.guards[guard]
+		if !ok {
+			return &DefinitionError{Path: path + ".guard", Message: fmt.Sprintf("guard %q is not registered", guard)}
+		}
+		tb.SetGuard(g, guard)
+	}
+
+	names, err := definitionStrings(obj, "guards", path)
+	if err != nil {
+		return err
+	}
+	if len(names) > 0 {
+		if guard != "" {
+			return &DefinitionError{Path: path, Message: `a transition cannot have both "guard" and "guards"`}
+		}
+		guards := make([]TransitionGuard, len(names))
+		for idx, n := range names {
+			g, ok := l.registry.guards[n]
+			if !ok {
+				return &DefinitionError{Path: fmt.Sprintf("%s.guards[%d]", path, idx), Message: fmt.Sprintf("guard %q is not registered", n)}
+			}
+			guards[idx] = g
+		}
+		tb.SetGuard(allGuards(names, guards), names...)
+	}
+
+	names, err = definitionStrings(obj, "effects", path)
+	if err != nil {
+		return err
+	}
+	if len(names) > 0 {
+		effects := make([]TransitionEffect, len(names))
+		for idx, n := range names {
+			effect, ok := l.registry.effects[n]
+			if !ok {
+				return &DefinitionError{Path: fmt.Sprintf("%s.effects[%d]", path, idx), Message: fmt.Sprintf("effect %q is not registered", n)}
+			}
+			effects[idx] = effect
+		}
+		tb.SetEffect(sequenceEffects(effects), names...)
+	}
+	return nil
+}
+
+// definitionObject returns v as an object, checking that it has only the fields allowed.
+func definitionObject(v interface{}, path string, allowed ...string) (map[string]interface{}, error) {
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, &DefinitionError{Path: path, Message: "expected an object"}
+	}
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		known := false
+		for _, a := range allowed {
+			if key == a {
+				known = true
+			}
+		}
+		if !known {
+			return nil, &DefinitionError{Path: path + "." + key, Message: fmt.Sprintf("unknown field %q", key)}
+		}
+	}
+	return obj, nil
+}
+
+func definitionString(obj map[string]interface{}, key, path string, required bool) (string, error) {
+	v, ok := obj[key]
+	if !ok || v == nil {
+		if required {
+			return "", &DefinitionError{Path: path + "." + key, Message: "missing"}
+		}
+		return "", nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", &DefinitionError{Path: path + "." + key, Message: fmt.Sprintf("expected a string, got %v", v)}
+	}
+	if s == "" && required {
+		return "", &DefinitionError{Path: path + "." + key, Message: "empty"}
+	}
+	return s, nil
+}
+
+func definitionList(obj map[string]interface{}, key, path string, required bool) ([]interface{}, error) {
+	v, ok := obj[key]
+	if !ok || v == nil {
+		if required {
+			return nil, &DefinitionError{Path: path + "." + key, Message: "missing"}
+		}
+		return nil, nil
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, &DefinitionError{Path: path + "." + key, Message: "expected a list"}
+	}
+	return list, nil
+}
+
+func definitionStrings(obj map[string]interface{}, key, path string) ([]string, error) {
+	list, err := definitionList(obj, key, path, false)
+	if err != nil {
+		return nil, err
+	}
+	strs := []string{}
+	for idx, v := range list {
+		s, ok := v.(string)
+		if !ok || s == "" {
+			return nil, &DefinitionError{Path: fmt.Sprintf("%s.%s[%d]", path, key, idx), Message: fmt.Sprintf("expected a name, got %v", v)}
+		}
+		strs = append(strs, s)
+	}
+	return strs, nil
+}
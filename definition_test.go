@@ -0,0 +1,327 @@
+package fsm_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"time"
+
+	fsm "github.com/johngrange/gofsm"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Machine Definitions", func() {
+	type meterData struct {
+		credit  uint
+		tickets uint
+		entries []string
+	}
+
+	var registry *fsm.Registry
+
+	BeforeEach(func() {
+		registry = fsm.NewRegistry().
+			Guard("hasCredit", func(fsmData, eventData interface{}) bool { return fsmData.(*meterData).credit >= 100 }).
+			Effect("addCoin", func(ev fsm.Event, fsmData interface{}, dispatcher fsm.Dispatcher) {
+				fsmData.(*meterData).credit += ev.Data().(uint)
+			}).
+			Effect("printTicket", func(ev fsm.Event, fsmData interface{}, dispatcher fsm.Dispatcher) {
+				fsmData.(*meterData).tickets++
+			}).
+			Action("clearCredit", func(state fsm.State, fsmData interface{}, dispatcher fsm.Dispatcher) {
+				fsmData.(*meterData).credit = 0
+			}).
+			Action("log", func(state fsm.State, fsmData interface{}, dispatcher fsm.Dispatcher) {
+				data := fsmData.(*meterData)
+				data.entries = append(data.entries, state.Name())
+			})
+	})
+
+	const meterJSON = `{
+  "initial": "idle",
+  "states": [
+    {
+      "name": "idle",
+      "labels": ["waiting for coins"],
+      "entry": ["log"],
+      "transitions": [
+        {"target": "accepting", "event": "evInsertCoin", "effects": ["addCoin"]}
+      ]
+    },
+    {
+      "name": "accepting",
+      "entry": ["log"],
+      "exit": ["clearCredit"],
+      "transitions": [
+        {"target": "accepting", "event": "evInsertCoin", "effects": ["addCoin"]},
+        {"target": "idle", "event": "evPrint", "guard": "hasCredit", "effects": ["printTicket"]},
+        {"target": "idle", "after": "30s", "labels": ["timeout"]}
+      ]
+    }
+  ]
+}
+`
+
+	const meterYAML = `initial: idle
+states:
+  - name: idle
+    labels:
+      - waiting for coins
+    entry:
+      - log
+    transitions:
+      - target: accepting
+        event: evInsertCoin
+        effects:
+          - addCoin
+  - name: accepting
+    entry:
+      - log
+    exit:
+      - clearCredit
+    transitions:
+      - target: accepting
+        event: evInsertCoin
+        effects:
+          - addCoin
+      - target: idle
+        event: evPrint
+        guard: hasCredit
+        effects:
+          - printTicket
+      - target: idle
+        after: 30s
+        labels:
+          - timeout
+`
+
+	run := func(smb fsm.StateMachineBuilder) *meterData {
+		data := &meterData{}
+		clock := fsm.NewFakeClock(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+		sm, err := smb.SetData(data).SetClock(clock).BuildImmediateFSM()
+		Expect(err).NotTo(HaveOccurred())
+
+		sm.Start()
+		Expect(sm.CurrentState().StateLabels()).To(Equal([]string{"waiting for coins"}))
+		sm.Dispatch(fsm.NewEvent("evInsertCoin", uint(50)))
+		sm.Dispatch(fsm.NewEvent("evPrint", nil))
+		Expect(sm.CurrentState().Name()).To(Equal("accepting"))
+		sm.Dispatch(fsm.NewEvent("evInsertCoin", uint(50)))
+		sm.Dispatch(fsm.NewEvent("evPrint", nil))
+		Expect(sm.CurrentState().Name()).To(Equal("idle"))
+		Expect(data.tickets).To(BeNumerically("==", 1))
+
+		sm.Dispatch(fsm.NewEvent("evInsertCoin", uint(50)))
+		clock.Advance(30*time.Second + 1)
+		sm.Tick()
+		Expect(sm.CurrentState().Name()).To(Equal("idle"))
+		Expect(data.credit).To(BeNumerically("==", 0))
+		return data
+	}
+
+	When("loading definitions", func() {
+		It("should build a machine from JSON with the behaviour registered", func() {
+			smb, err := fsm.LoadDefinition(strings.NewReader(meterJSON), registry)
+			Expect(err).NotTo(HaveOccurred())
+			data := run(smb)
+			Expect(data.entries).To(Equal([]string{"idle", "accepting", "idle", "accepting", "idle"}))
+		})
+
+		It("should build the same machine from YAML", func() {
+			smb, err := fsm.LoadDefinitionYAML(strings.NewReader(meterYAML), registry)
+			Expect(err).NotTo(HaveOccurred())
+			run(smb)
+
+			fromJSON, err := fsm.LoadDefinition(strings.NewReader(meterJSON), registry)
+			Expect(err).NotTo(HaveOccurred())
+			a, err := fromJSON.BuildImmediateFSM()
+			Expect(err).NotTo(HaveOccurred())
+			b, err := smb.BuildImmediateFSM()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fsm.Diff(a, b)).To(BeEmpty())
+		})
+
+		It("should take the initial transition from a state named initial, or enter the first state", func() {
+			smb, err := fsm.LoadDefinition(strings.NewReader(`{"states": [
+  {"name": "off"},
+  {"name": "initial", "transitions": [{"target": "on", "effects": ["printTicket"]}]},
+  {"name": "on", "transitions": [{"target": "FinalState", "event": "evStop"}]},
+  {"name": "FinalState", "entry": ["log"]}
+]}`), registry)
+			Expect(err).NotTo(HaveOccurred())
+			data := &meterData{}
+			sm, err := smb.SetData(data).BuildImmediateFSM()
+			Expect(err).NotTo(HaveOccurred())
+			sm.Start()
+			Expect(sm.CurrentState().Name()).To(Equal("on"))
+			Expect(data.tickets).To(BeNumerically("==", 1))
+			sm.Dispatch(fsm.NewEvent("evStop", nil))
+			Expect(sm.CurrentState().Name()).To(Equal(fsm.FinalStateName))
+			Expect(data.entries).To(Equal([]string{fsm.FinalStateName}))
+
+			smb, err = fsm.LoadDefinitionYAML(strings.NewReader("states: [{name: off}, {name: on}]"), registry)
+			Expect(err).NotTo(HaveOccurred())
+			sm, err = smb.BuildImmediateFSM()
+			Expect(err).NotTo(HaveOccurred())
+			sm.Start()
+			Expect(sm.CurrentState().Name()).To(Equal("off"))
+		})
+	})
+
+	When("saving definitions", func() {
+		It("should write JSON that loads back into the same machine", func() {
+			smb, err := fsm.LoadDefinition(strings.NewReader(meterJSON), registry)
+			Expect(err).NotTo(HaveOccurred())
+			original, err := smb.BuildImmediateFSM()
+			Expect(err).NotTo(HaveOccurred())
+
+			buf := &bytes.Buffer{}
+			Expect(fsm.SaveDefinition(buf, original)).To(Succeed())
+			Expect(buf.String()).To(MatchJSON(meterJSON))
+
+			smb, err = fsm.LoadDefinition(bytes.NewReader(buf.Bytes()), registry)
+			Expect(err).NotTo(HaveOccurred())
+			loaded, err := smb.BuildImmediateFSM()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fsm.Diff(original, loaded)).To(BeEmpty())
+		})
+
+		It("should round trip guards with several labels as a list", func() {
+			inService := true
+			registry.Guard("inService", func(fsmData, eventData interface{}) bool { return inService })
+			smb := fsm.NewFSMBuilder()
+			on := smb.NewState("on")
+			printing := smb.NewState("printing")
+			smb.GetInitialState().AddTransition(on)
+			on.AddTransition(printing).SetEventTrigger("evPrint").
+				SetGuard(func(fsmData, eventData interface{}) bool { return true }, "hasCredit", "inService")
+			original, err := smb.BuildImmediateFSM()
+			Expect(err).NotTo(HaveOccurred())
+			buf := &bytes.Buffer{}
+			Expect(fsm.SaveDefinition(buf, original)).To(Succeed())
+			Expect(buf.String()).To(MatchJSON(`{"initial": "on", "states": [
+  {"name": "on", "transitions": [{"target": "printing", "event": "evPrint", "guards": ["hasCredit", "inService"]}]},
+  {"name": "printing"}
+]}`))
+
+			smb, err = fsm.LoadDefinition(bytes.NewReader(buf.Bytes()), registry)
+			Expect(err).NotTo(HaveOccurred())
+			data := &meterData{credit: 100}
+			loaded, err := smb.SetData(data).BuildImmediateFSM()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fsm.Diff(original, loaded)).To(BeEmpty())
+
+			loaded.Start()
+			inService = false
+			loaded.Dispatch(fsm.NewEvent("evPrint", nil))
+			Expect(loaded.CurrentState().Name()).To(Equal("on"))
+			inService = true
+			loaded.Dispatch(fsm.NewEvent("evPrint", nil))
+			Expect(loaded.CurrentState().Name()).To(Equal("printing"))
+		})
+
+		It("should only load guards without labels if registered as \"guard\"", func() {
+			smb := fsm.NewFSMBuilder()
+			on := smb.NewState("on")
+			smb.GetInitialState().AddTransition(on)
+			on.AddTransition(on).SetEventTrigger("evReset").
+				SetGuard(func(fsmData, eventData interface{}) bool { return true })
+			sm, err := smb.BuildImmediateFSM()
+			Expect(err).NotTo(HaveOccurred())
+			buf := &bytes.Buffer{}
+			Expect(fsm.SaveDefinition(buf, sm)).To(Succeed())
+
+			_, err = fsm.LoadDefinition(bytes.NewReader(buf.Bytes()), registry)
+			var defErr *fsm.DefinitionError
+			Expect(errors.As(err, &defErr)).To(BeTrue(), "error %v", err)
+			Expect(defErr.Path).To(Equal("$.states[0].transitions[0].guard"))
+			Expect(defErr.Message).To(Equal(`guard "guard" is not registered`))
+
+			registry.Guard("guard", func(fsmData, eventData interface{}) bool { return true })
+			_, err = fsm.LoadDefinition(bytes.NewReader(buf.Bytes()), registry)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should write YAML", func() {
+			smb, err := fsm.LoadDefinition(strings.NewReader(meterJSON), registry)
+			Expect(err).NotTo(HaveOccurred())
+			original, err := smb.BuildImmediateFSM()
+			Expect(err).NotTo(HaveOccurred())
+
+			buf := &bytes.Buffer{}
+			Expect(fsm.SaveDefinitionYAML(buf, original)).To(Succeed())
+			Expect(buf.String()).To(Equal(meterYAML))
+		})
+
+		It("should write machines built in code, with their initial state in full when it has an effect", func() {
+			smb := fsm.NewFSMBuilder()
+			on := smb.NewState("on")
+			smb.GetInitialState().AddTransition(on).
+				SetEffect(func(ev fsm.Event, fsmData interface{}, dispatcher fsm.Dispatcher) {}, "printTicket")
+			on.AddTransition(on).SetTimedTrigger(90*time.Second).
+				SetGuard(func(fsmData, eventData interface{}) bool { return true }, "hasCredit")
+			on.AddTransition(smb.AddFinalState()).SetEventTrigger("evStop")
+			sm, err := smb.BuildImmediateFSM()
+			Expect(err).NotTo(HaveOccurred())
+
+			buf := &bytes.Buffer{}
+			Expect(fsm.SaveDefinition(buf, sm)).To(Succeed())
+			Expect(buf.String()).To(MatchJSON(`{"states": [
+  {"name": "initial", "transitions": [{"target": "on", "effects": ["printTicket"]}]},
+  {"name": "on", "transitions": [
+    {"target": "on", "after": "1m30s", "guard": "hasCredit"},
+    {"target": "FinalState", "event": "evStop"}
+  ]},
+  {"name": "FinalState"}
+]}`))
+		})
+	})
+
+	DescribeTable("reporting errors in JSON definitions with their path",
+		func(definition, path, message string) {
+			_, err := fsm.LoadDefinition(strings.NewReader(definition), registry)
+			var defErr *fsm.DefinitionError
+			Expect(errors.As(err, &defErr)).To(BeTrue(), "error %v", err)
+			Expect(defErr.Path).To(Equal(path))
+			Expect(defErr.Message).To(ContainSubstring(message))
+		},
+		Entry("syntax errors", `{"states": [}`, "$", "invalid character"),
+		Entry("missing states", `{"initial": "idle"}`, "$.states", "missing"),
+		Entry("unknown fields", `{"states": [{"name": "a", "transitons": []}]}`, "$.states[0].transitons", `unknown field "transitons"`),
+		Entry("wrong types", `{"states": [{"name": 3}]}`, "$.states[0].name", "expected a string"),
+		Entry("duplicate states", `{"states": [{"name": "a"}, {"name": "a"}]}`, "$.states[1].name", `state "a" is defined more than once`),
+		Entry("unknown initial states", `{"initial": "b", "states": [{"name": "a"}]}`, "$.initial", `state "b" is not defined`),
+		Entry("unknown targets", `{"states": [{"name": "a", "transitions": [{"target": "b"}]}]}`,
+			"$.states[0].transitions[0].target", `state "b" is not defined`),
+		Entry("unregistered actions", `{"states": [{"name": "a"}, {"name": "b", "exit": ["log", "beep"]}]}`,
+			"$.states[1].exit[1]", `action "beep" is not registered`),
+		Entry("unregistered guards", `{"states": [{"name": "a", "transitions": [{"target": "a", "event": "go", "guard": "isReady"}]}]}`,
+			"$.states[0].transitions[0].guard", `guard "isReady" is not registered`),
+		Entry("unregistered guards in a list", `{"states": [{"name": "a", "transitions": [{"target": "a", "event": "go", "guards": ["hasCredit", "isReady"]}]}]}`,
+			"$.states[0].transitions[0].guards[1]", `guard "isReady" is not registered`),
+		Entry("a guard and a list of guards together", `{"states": [{"name": "a", "transitions": [{"target": "a", "event": "go", "guard": "hasCredit", "guards": ["hasCredit"]}]}]}`,
+			"$.states[0].transitions[0]", `both "guard" and "guards"`),
+		Entry("unregistered effects", `{"states": [{"name": "a", "transitions": [{"target": "a", "effects": ["log"]}]}]}`,
+			"$.states[0].transitions[0].effects[0]", `effect "log" is not registered`),
+		Entry("invalid durations", `{"states": [{"name": "a", "transitions": [{"target": "a", "after": "soon"}]}]}`,
+			"$.states[0].transitions[0].after", `invalid duration "soon"`),
+		Entry("event and timer triggers together", `{"states": [{"name": "a", "transitions": [{"target": "a", "event": "go", "after": "1s"}]}]}`,
+			"$.states[0].transitions[0]", `both "event" and "after"`),
+		Entry("transitions from the final state", `{"states": [{"name": "FinalState", "transitions": [{"target": "FinalState"}]}]}`,
+			"$.states[0].transitions", "the final state cannot have transitions"),
+	)
+
+	It("should report errors in YAML definitions with their path", func() {
+		_, err := fsm.LoadDefinitionYAML(strings.NewReader(`states:
+  - name: idle
+    transitions:
+      - target: idle
+        after: 30
+`), registry)
+		var defErr *fsm.DefinitionError
+		Expect(errors.As(err, &defErr)).To(BeTrue(), "error %v", err)
+		Expect(defErr.Path).To(Equal("$.states[0].transitions[0].after"))
+		Expect(err).To(MatchError("definition $.states[0].transitions[0].after: expected a string, got 30"))
+	})
+})
@@ -0,0 +1,92 @@
+package fsm
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// LogLevel values match those of log/slog, so they convert directly.
+type LogLevel int
+
+const (
+	LogDebug LogLevel = -4
+	LogInfo  LogLevel = 0
+	LogWarn  LogLevel = 4
+	LogError LogLevel = 8
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogDebug:
+		return "DEBUG"
+	case LogInfo:
+		return "INFO"
+	case LogWarn:
+		return "WARN"
+	case LogError:
+		return "ERROR"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+const (
+	LogKeyState       = "state"
+	LogKeyTargetState = "targetState"
+	LogKeyEvent       = "event"
+	LogKeyTransition  = "transition"
+	LogKeyTimer       = "timer"
+	LogKeyQueueLength = "queueLength"
+)
+
+type LogField struct {
+	Key   string
+	Value interface{}
+}
+
+// DiagnosticLogger receives internal diagnostics from a state machine.  Enabled is checked
+// before fields are built, so disabled levels cost very little.
+type DiagnosticLogger interface {
+	Enabled(level LogLevel) bool
+	Log(level LogLevel, msg string, fields ...LogField)
+}
+
+type noopLogger struct{}
+
+func (noopLogger) Enabled(level LogLevel) bool                        { return false }
+func (noopLogger) Log(level LogLevel, msg string, fields ...LogField) {}
+
+// NoopLogger returns the default DiagnosticLogger, which discards everything.
+func NoopLogger() DiagnosticLogger {
+	return noopLogger{}
+}
+
+func StateField(s State) LogField {
+	return LogField{Key: LogKeyState, Value: s.Name()}
+}
+
+func TargetStateField(s State) LogField {
+	return LogField{Key: LogKeyTargetState, Value: s.Name()}
+}
+
+func EventField(ev Event) LogField {
+	if ev == nil {
+		return LogField{Key: LogKeyEvent, Value: ""}
+	}
+	return LogField{Key: LogKeyEvent, Value: ev.Name()}
+}
+
+// TransitionField identifies a transition by its source, target and index among the source's
+// transitions, such as "off --> on #0", followed by its labels if it has any.
+func TransitionField(t Transition) LogField {
+	value := fmt.Sprintf("%s --> %s #%d", t.Source().Name(), t.Target().Name(), transitionIndex(t))
+	if labels := transitionLabelList(t); len(labels) > 0 {
+		value += " (" + strings.Join(labels, ", ") + ")"
+	}
+	return LogField{Key: LogKeyTransition, Value: value}
+}
+
+func TimerField(d time.Duration) LogField {
+	return LogField{Key: LogKeyTimer, Value: d}
+}
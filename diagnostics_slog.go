@@ -0,0 +1,29 @@
+//go:build go1.21
+
+package fsm
+
+import (
+	"context"
+	"log/slog"
+)
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlogLogger adapts a *slog.Logger to a DiagnosticLogger.
+func NewSlogLogger(logger *slog.Logger) DiagnosticLogger {
+	return &slogLogger{logger: logger}
+}
+
+func (l *slogLogger) Enabled(level LogLevel) bool {
+	return l.logger.Enabled(context.Background(), slog.Level(level))
+}
+
+func (l *slogLogger) Log(level LogLevel, msg string, fields ...LogField) {
+	attrs := make([]slog.Attr, 0, len(fields))
+	for _, f := range fields {
+		attrs = append(attrs, slog.Any(f.Key, f.Value))
+	}
+	l.logger.LogAttrs(context.Background(), slog.Level(level), msg, attrs...)
+}
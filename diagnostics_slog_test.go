@@ -0,0 +1,38 @@
+//go:build go1.21
+
+package fsm_test
+
+import (
+	"bytes"
+	"log/slog"
+
+	fsm "github.com/johngrange/gofsm"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("slog diagnostic logger", func() {
+	It("should write fields as slog attributes", func() {
+		buf := bytes.Buffer{}
+		handler := slog.NewTextHandler(&buf, &slog.HandlerOptions{
+			Level: slog.LevelDebug,
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if a.Key == slog.TimeKey {
+					return slog.Attr{}
+				}
+				return a
+			},
+		})
+		smb := fsm.NewFSMBuilder().SetDiagnosticLogger(fsm.NewSlogLogger(slog.New(handler)))
+		smb.GetInitialState().AddTransition(smb.NewState("off"))
+		sm, err := smb.BuildImmediateFSM()
+		Expect(err).NotTo(HaveOccurred())
+		sm.Start()
+		Expect(buf.String()).To(Equal("level=DEBUG msg=transitioning state=initial targetState=off event=\"\" transition=\"initial --> off #0\"\n"))
+	})
+	It("should respect the handler's level", func() {
+		logger := fsm.NewSlogLogger(slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil)))
+		Expect(logger.Enabled(fsm.LogDebug)).To(BeFalse())
+		Expect(logger.Enabled(fsm.LogInfo)).To(BeTrue())
+	})
+})
@@ -0,0 +1,82 @@
+package fsm_test
+
+import (
+	fsm "github.com/johngrange/gofsm"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type logRecord struct {
+	level  fsm.LogLevel
+	msg    string
+	fields map[string]interface{}
+}
+
+type recordingLogger struct {
+	minLevel fsm.LogLevel
+	records  []logRecord
+}
+
+func (r *recordingLogger) Enabled(level fsm.LogLevel) bool {
+	return level >= r.minLevel
+}
+
+func (r *recordingLogger) Log(level fsm.LogLevel, msg string, fields ...fsm.LogField) {
+	rec := logRecord{level: level, msg: msg, fields: map[string]interface{}{}}
+	for _, f := range fields {
+		rec.fields[f.Key] = f.Value
+	}
+	r.records = append(r.records, rec)
+}
+
+var _ = Describe("Diagnostic logging", func() {
+	var smb fsm.StateMachineBuilder
+
+	BeforeEach(func() {
+		smb = fsm.NewFSMBuilder()
+		off := smb.NewState("off")
+		on := smb.NewState("on")
+		smb.GetInitialState().AddTransition(off)
+		off.AddTransition(on, "switch on").SetEventTrigger("TurnOn")
+	})
+
+	It("should log transitions with structured fields", func() {
+		logger := &recordingLogger{minLevel: fsm.LogDebug}
+		smb.SetDiagnosticLogger(logger)
+		sm, err := smb.BuildImmediateFSM()
+		Expect(err).NotTo(HaveOccurred())
+		sm.Start()
+		sm.Dispatch(fsm.NewEvent("TurnOn", nil))
+
+		var transitions []logRecord
+		for _, r := range logger.records {
+			if r.msg == "transitioning" {
+				transitions = append(transitions, r)
+			}
+		}
+		Expect(transitions).To(HaveLen(2))
+		Expect(transitions[1].level).To(Equal(fsm.LogDebug))
+		Expect(transitions[1].fields).To(Equal(map[string]interface{}{
+			fsm.LogKeyState:       "off",
+			fsm.LogKeyTargetState: "on",
+			fsm.LogKeyEvent:       "TurnOn",
+			fsm.LogKeyTransition:  "off --> on #0 (switch on)",
+		}))
+	})
+	It("should not log below the logger's level", func() {
+		logger := &recordingLogger{minLevel: fsm.LogInfo}
+		smb.SetDiagnosticLogger(logger)
+		sm, err := smb.BuildImmediateFSM()
+		Expect(err).NotTo(HaveOccurred())
+		sm.Start()
+		sm.Dispatch(fsm.NewEvent("TurnOn", nil))
+		Expect(logger.records).To(BeEmpty())
+	})
+	It("should default to the no-op logger", func() {
+		Expect(fsm.NoopLogger().Enabled(fsm.LogError)).To(BeFalse())
+		sm, err := smb.BuildImmediateFSM()
+		Expect(err).NotTo(HaveOccurred())
+		sm.Start()
+		Expect(sm.CurrentState().Name()).To(Equal("off"))
+	})
+})
@@ -0,0 +1,345 @@
+package fsm
+
+import (
+	"fmt"
+	"io"
+)
+
+type ChangeKind int
+
+const (
+	ChangeAdded ChangeKind = iota
+	ChangeRemoved
+	ChangeModified
+)
+
+// Change is a difference between two machine definitions, found by Diff.
+type Change struct {
+	Kind       ChangeKind
+	State      string // the state changed, or the source of the transition changed
+	Transition string // the transition changed, empty for state changes
+	Aspect     string // what was modified: labels, entry, exit, invariants, trigger, guard, effect or timer
+	Before     string
+	After      string
+}
+
+func (c Change) String() string {
+	subject := fmt.Sprintf("state %s", c.State)
+	if c.Transition != "" {
+		subject = "transition " + c.Transition
+	}
+	switch c.Kind {
+	case ChangeAdded:
+		return subject + " added"
+	case ChangeRemoved:
+		return subject + " removed"
+	}
+	switch {
+	case c.Before == "":
+		return fmt.Sprintf("%s gained %s %s", subject, c.Aspect, c.After)
+	case c.After == "":
+		return fmt.Sprintf("%s lost %s %s", subject, c.Aspect, c.Before)
+	default:
+		return fmt.Sprintf("%s changed %s from %s to %s", subject, c.Aspect, c.Before, c.After)
+	}
+}
+
+type diffState struct {
+	name                            string
+	labels, entry, exit, invariants string
+	transitions                     []Transition
+}
+
+type diffVisitor struct {
+	states []*diffState
+	byName map[string]*diffState
+}
+
+func (v *diffVisitor) VisitState(state State) {
+	s := &diffState{
+		name:       state.Name(),
+		labels:     bracketLabels(state.StateLabels()),
+		entry:      bracketLabels(state.EntryLabels()),
+		exit:       bracketLabels(state.ExitLabels()),
+		invariants: bracketLabels(state.InvariantLabels()),
+	}
+	v.states = append(v.states, s)
+	v.byName[s.name] = s
+}
+
+func (v *diffVisitor) VisitTransition(t Transition) {
+	if s, ok := v.byName[t.Source().Name()]; ok {
+		s.transitions = append(s.transitions, t)
+	}
+}
+
+func visitForDiff(machine Visitable) *diffVisitor {
+	v := &diffVisitor{byName: make(map[string]*diffState)}
+	machine.Visit(v)
+	return v
+}
+
+func bracketLabels(labels []string) string {
+	s := ""
+	for _, l := range labels {
+		s += "[" + l + "]"
+	}
+	return s
+}
+
+// diffTransitionKey identifies a transition for matching: its target and trigger, but not the
+// duration of a timer, so that changed timers are reported as modifications.
+func diffTransitionKey(t Transition) string {
+	switch t.TriggerType() {
+	case EventTrigger:
+		return t.Target().Name() + " : " + t.EventName()
+	case TimerTrigger:
+		return t.Target().Name() + " : after"
+	default:
+		return t.Target().Name()
+	}
+}
+
+func describeTransition(t Transition) string {
+	s := fmt.Sprintf("%s --> %s", t.Source().Name(), t.Target().Name())
+	switch t.TriggerType() {
+	case EventTrigger:
+		s += " : " + t.EventName()
+	case TimerTrigger:
+		s += " : after " + t.TimerDuration().String()
+	}
+	return s
+}
+
+func guardDescription(t Transition) string {
+	if !t.IsGuarded() {
+		return ""
+	}
+	if len(t.GuardLabels()) == 0 {
+		return "[guard]"
+	}
+	return bracketLabels(t.GuardLabels())
+}
+
+func transitionLabels(t Transition) string {
+	return bracketLabels(transitionLabelList(t))
+}
+
+// Diff compares two machine definitions.  States are matched by name, and transitions by source,
+// target and trigger, in order where a state has several alike.  State changes are listed before
+// transition changes.
+func Diff(a, b FSM) []Change {
+	return diffDefinitions(visitForDiff(a), visitForDiff(b))
+}
+
+func diffDefinitions(before, after *diffVisitor) []Change {
+	changes := []Change{}
+	for _, s := range before.states {
+		if _, ok := after.byName[s.name]; !ok {
+			changes = append(changes, Change{Kind: ChangeRemoved, State: s.name})
+		}
+	}
+	for _, s := range after.states {
+		old, ok := before.byName[s.name]
+		if !ok {
+			changes = append(changes, Change{Kind: ChangeAdded, State: s.name})
+			continue
+		}
+		for _, aspect := range []struct{ name, before, after string }{
+			{"labels", old.labels, s.labels},
+			{"entry", old.entry, s.entry},
+			{"exit", old.exit, s.exit},
+			{"invariants", old.invariants, s.invariants},
+		} {
+			if aspect.before != aspect.after {
+				changes = append(changes, Change{Kind: ChangeModified, State: s.name, Aspect: aspect.name, Before: aspect.before, After: aspect.after})
+			}
+		}
+	}
+
+	for _, s := range before.states {
+		var afterTransitions []Transition
+		if match, ok := after.byName[s.name]; ok {
+			afterTransitions = match.transitions
+		}
+		changes = append(changes, diffTransitions(s.name, s.transitions, afterTransitions)...)
+	}
+	for _, s := range after.states {
+		if _, ok := before.byName[s.name]; !ok {
+			changes = append(changes, diffTransitions(s.name, nil, s.transitions)...)
+		}
+	}
+	return changes
+}
+
+// matchTransitions pairs transitions with the same target and trigger, in order.  It returns the
+// index in before matched with each transition of after, or -1.
+func matchTransitions(before, after []Transition) []int {
+	matches := make([]int, len(after))
+	taken := make([]bool, len(before))
+	for idx, t := range after {
+		matches[idx] = -1
+		for oldIdx, old := range before {
+			if !taken[oldIdx] && diffTransitionKey(old) == diffTransitionKey(t) {
+				taken[oldIdx] = true
+				matches[idx] = oldIdx
+				break
+			}
+		}
+	}
+	return matches
+}
+
+func diffTransitions(state string, before, after []Transition) []Change {
+	changes := []Change{}
+	partner := make([]int, len(before)) // index in after matched with each transition of before
+	for oldIdx := range partner {
+		partner[oldIdx] = -1
+	}
+	matches := matchTransitions(before, after)
+	for idx, oldIdx := range matches {
+		if oldIdx >= 0 {
+			partner[oldIdx] = idx
+		}
+	}
+	for oldIdx, old := range before {
+		if partner[oldIdx] < 0 {
+			changes = append(changes, Change{Kind: ChangeRemoved, State: state, Transition: describeTransition(old)})
+		} else {
+			changes = append(changes, transitionModifications(state, old, after[partner[oldIdx]])...)
+		}
+	}
+	for idx, t := range after {
+		if matches[idx] < 0 {
+			changes = append(changes, Change{Kind: ChangeAdded, State: state, Transition: describeTransition(t)})
+		}
+	}
+	return changes
+}
+
+func transitionModifications(state string, old, t Transition) []Change {
+	changes := []Change{}
+	oldTimer, newTimer := "", ""
+	if old.TriggerType() == TimerTrigger {
+		oldTimer, newTimer = old.TimerDuration().String(), t.TimerDuration().String()
+	}
+	for _, aspect := range []struct{ name, before, after string }{
+		{"labels", transitionLabels(old), transitionLabels(t)},
+		{"trigger", bracketLabels(old.TriggerLabels()), bracketLabels(t.TriggerLabels())},
+		{"timer", oldTimer, newTimer},
+		{"guard", guardDescription(old), guardDescription(t)},
+		{"effect", bracketLabels(old.EffectLabels()), bracketLabels(t.EffectLabels())},
+	} {
+		if aspect.before != aspect.after {
+			changes = append(changes, Change{
+				Kind:       ChangeModified,
+				State:      state,
+				Transition: describeTransition(old),
+				Aspect:     aspect.name,
+				Before:     aspect.before,
+				After:      aspect.after,
+			})
+		}
+	}
+	return changes
+}
+
+// WriteDiffText writes one change per line, marked + for added, - for removed and ~ for
+// modified.
+func WriteDiffText(w io.Writer, changes []Change) error {
+	for _, c := range changes {
+		mark := "~"
+		switch c.Kind {
+		case ChangeAdded:
+			mark = "+"
+		case ChangeRemoved:
+			mark = "-"
+		}
+		if _, err := fmt.Fprintf(w, "%s %s\n", mark, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RenderDiffPlantUML draws both machines overlaid, with elements only in b in green, elements
+// only in a in red, and modified transitions in orange, labelled as in b.
+func RenderDiffPlantUML(w io.Writer, a, b FSM) error {
+	before, after := visitForDiff(a), visitForDiff(b)
+	lines := []string{"@startuml"}
+	for _, s := range before.states {
+		if _, ok := after.byName[s.name]; !ok && s.name != InitialStateName && s.name != FinalStateName {
+			lines = append(lines, fmt.Sprintf("state %s #pink", s.name))
+		}
+	}
+	for _, s := range after.states {
+		if _, ok := before.byName[s.name]; !ok && s.name != InitialStateName && s.name != FinalStateName {
+			lines = append(lines, fmt.Sprintf("state %s #lightgreen", s.name))
+		}
+	}
+
+	// draw transitions from b, coloured by how they differ from a, then those removed from a
+	for _, s := range after.states {
+		var beforeTransitions []Transition
+		if match, ok := before.byName[s.name]; ok {
+			beforeTransitions = match.transitions
+		}
+		for idx, oldIdx := range matchTransitions(beforeTransitions, s.transitions) {
+			colour := ""
+			switch {
+			case oldIdx < 0:
+				colour = "#green"
+			case len(transitionModifications(s.name, beforeTransitions[oldIdx], s.transitions[idx])) > 0:
+				colour = "#orange"
+			}
+			lines = append(lines, plantUMLDiffArrow(s.transitions[idx], colour))
+		}
+	}
+	for _, s := range before.states {
+		var afterTransitions []Transition
+		if match, ok := after.byName[s.name]; ok {
+			afterTransitions = match.transitions
+		}
+		matched := make([]bool, len(s.transitions))
+		for _, oldIdx := range matchTransitions(s.transitions, afterTransitions) {
+			if oldIdx >= 0 {
+				matched[oldIdx] = true
+			}
+		}
+		for idx, t := range s.transitions {
+			if !matched[idx] {
+				lines = append(lines, plantUMLDiffArrow(t, "#red"))
+			}
+		}
+	}
+	lines = append(lines, "@enduml")
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func plantUMLDiffArrow(t Transition, colour string) string {
+	source, target := t.Source().Name(), t.Target().Name()
+	if source == InitialStateName {
+		source = InitialFinalStateSymbol
+	}
+	if target == FinalStateName {
+		target = InitialFinalStateSymbol
+	}
+	arrow := "-->"
+	if colour != "" {
+		arrow = "-[" + colour + ",bold]->"
+	}
+	guards := t.GuardLabels()
+	if t.IsGuarded() && len(guards) == 0 {
+		guards = []string{"guard"} // so that changes to unlabelled guards show
+	}
+	label := formatTransitionLabel(t, guards, afterLabel)
+	if label == "" {
+		return fmt.Sprintf("%s %s %s", source, arrow, target)
+	}
+	return fmt.Sprintf("%s %s %s : %s", source, arrow, target, label)
+}
@@ -0,0 +1,119 @@
+package fsm_test
+
+import (
+	"bytes"
+	"time"
+
+	fsm "github.com/johngrange/gofsm"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Diffing machine definitions", func() {
+	var (
+		before, after fsm.FSM
+		hasCredit     = func(fsmData, eventData interface{}) bool { return true }
+		noEffect      = func(ev fsm.Event, fsmData interface{}, dispatcher fsm.Dispatcher) {}
+	)
+
+	BeforeEach(func() {
+		smb := fsm.NewFSMBuilder()
+		idle := smb.NewState("idle")
+		accepting := smb.NewState("accepting")
+		printing := smb.NewState("printing", "prints a ticket")
+		smb.GetInitialState().AddTransition(idle)
+		idle.AddTransition(accepting).SetEventTrigger("evCoin")
+		accepting.AddTransition(printing).SetEventTrigger("evPrint")
+		accepting.AddTransition(idle).SetTimedTrigger(time.Minute)
+		printing.AddTransition(idle).SetEffect(noEffect, "eject")
+		var err error
+		before, err = smb.BuildImmediateFSM()
+		Expect(err).NotTo(HaveOccurred())
+
+		smb = fsm.NewFSMBuilder()
+		idle = smb.NewState("idle")
+		accepting = smb.NewState("accepting")
+		printing = smb.NewState("printing", "prints a receipt")
+		refunding := smb.NewState("refunding")
+		smb.GetInitialState().AddTransition(idle)
+		idle.AddTransition(accepting).SetEventTrigger("evCoin")
+		accepting.AddTransition(printing).SetEventTrigger("evPrint").SetGuard(hasCredit, "hasCredit")
+		accepting.AddTransition(refunding).SetTimedTrigger(2 * time.Minute)
+		refunding.AddTransition(idle)
+		printing.AddTransition(idle)
+		after, err = smb.BuildImmediateFSM()
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should find no changes between identical definitions", func() {
+		Expect(fsm.Diff(before, before)).To(BeEmpty())
+	})
+	It("should report added, removed and modified elements", func() {
+		changes := fsm.Diff(before, after)
+		descriptions := []string{}
+		for _, c := range changes {
+			descriptions = append(descriptions, c.String())
+		}
+		Expect(descriptions).To(Equal([]string{
+			"state printing changed labels from [prints a ticket] to [prints a receipt]",
+			"state refunding added",
+			"transition accepting --> printing : evPrint gained guard [hasCredit]",
+			"transition accepting --> idle : after 1m0s removed",
+			"transition accepting --> refunding : after 2m0s added",
+			"transition printing --> idle lost effect [eject]",
+			"transition refunding --> idle added",
+		}))
+		Expect(changes[2]).To(Equal(fsm.Change{
+			Kind:       fsm.ChangeModified,
+			State:      "accepting",
+			Transition: "accepting --> printing : evPrint",
+			Aspect:     "guard",
+			After:      "[hasCredit]",
+		}))
+	})
+	It("should report changed timers as modifications", func() {
+		smb := fsm.NewFSMBuilder()
+		idle := smb.NewState("idle")
+		smb.GetInitialState().AddTransition(idle)
+		idle.AddTransition(idle).SetTimedTrigger(time.Second)
+		a, err := smb.BuildImmediateFSM()
+		Expect(err).NotTo(HaveOccurred())
+		smb = fsm.NewFSMBuilder()
+		idle = smb.NewState("idle")
+		smb.GetInitialState().AddTransition(idle)
+		idle.AddTransition(idle).SetTimedTrigger(time.Minute)
+		b, err := smb.BuildImmediateFSM()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fsm.Diff(a, b)).To(Equal([]fsm.Change{{
+			Kind:       fsm.ChangeModified,
+			State:      "idle",
+			Transition: "idle --> idle : after 1s",
+			Aspect:     "timer",
+			Before:     "1s",
+			After:      "1m0s",
+		}}))
+	})
+	It("should write the changes as text", func() {
+		buf := bytes.Buffer{}
+		Expect(fsm.WriteDiffText(&buf, fsm.Diff(before, after)[1:4])).To(Succeed())
+		Expect(buf.String()).To(Equal(`+ state refunding added
+~ transition accepting --> printing : evPrint gained guard [hasCredit]
+- transition accepting --> idle : after 1m0s removed
+`))
+	})
+	It("should render the changes in PlantUML", func() {
+		buf := bytes.Buffer{}
+		Expect(fsm.RenderDiffPlantUML(&buf, before, after)).To(Succeed())
+		Expect(buf.String()).To(Equal(`@startuml
+state refunding #lightgreen
+[*] --> idle
+idle --> accepting : evCoin
+accepting -[#orange,bold]-> printing : evPrint [hasCredit]
+accepting -[#green,bold]-> refunding : after 2m0s
+printing -[#orange,bold]-> idle
+refunding -[#green,bold]-> idle
+accepting -[#red,bold]-> idle : after 1m0s
+@enduml
+`))
+	})
+})
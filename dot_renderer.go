@@ -0,0 +1,177 @@
+package fsm
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+type dotOptions struct {
+	rankDir        string
+	clusterByLabel bool
+	annotations    []AnnotationOption
+}
+
+// DOTOption changes how RenderDOT draws a machine.
+type DOTOption func(*dotOptions)
+
+// DOTRankDir sets the direction of the graph: TB (the Graphviz default), LR, BT or RL.
+func DOTRankDir(dir string) DOTOption {
+	return func(o *dotOptions) {
+		o.rankDir = dir
+	}
+}
+
+// DOTClusterByLabel draws states sharing their first state label inside a cluster named after
+// the label.
+func DOTClusterByLabel() DOTOption {
+	return func(o *dotOptions) {
+		o.clusterByLabel = true
+	}
+}
+
+// DOTHighlightCurrent fills in the machine's current state.
+func DOTHighlightCurrent() DOTOption {
+	return func(o *dotOptions) {
+		o.annotations = append(o.annotations, AnnotateCurrentState())
+	}
+}
+
+// DOTAnnotate draws the running machine as chosen by annotation options, with transitions
+// coloured and thickened by how often they were taken.
+func DOTAnnotate(opts ...AnnotationOption) DOTOption {
+	return func(o *dotOptions) {
+		o.annotations = append(o.annotations, opts...)
+	}
+}
+
+// RenderDOT writes a Graphviz DOT graph of the machine, which can be drawn with
+//
+//	dot -Tpng machine.dot -o machine.png
+func RenderDOT(w io.Writer, stateMachine FSM, opts ...DOTOption) error {
+	options := dotOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	switch options.rankDir {
+	case "", "TB", "LR", "BT", "RL":
+	default:
+		return fmt.Errorf("invalid rank direction %q", options.rankDir)
+	}
+	visitor := definitionVisitor{}
+	stateMachine.Visit(&visitor)
+	annotations := NewAnnotations(stateMachine, options.annotations...)
+
+	lines := []string{"digraph fsm {"}
+	if options.rankDir != "" {
+		lines = append(lines, "  rankdir="+options.rankDir+";")
+	}
+	lines = append(lines, "  node [shape=box, style=rounded];")
+
+	clusters := []string{}
+	members := make(map[string][]State)
+	for _, state := range visitor.states {
+		cluster := ""
+		if options.clusterByLabel && len(state.StateLabels()) > 0 && !isPseudoState(state.Name()) {
+			cluster = state.StateLabels()[0]
+		}
+		if _, ok := members[cluster]; !ok && cluster != "" {
+			clusters = append(clusters, cluster)
+		}
+		members[cluster] = append(members[cluster], state)
+	}
+	for _, state := range members[""] {
+		lines = append(lines, "  "+dotNode(state, annotations))
+	}
+	for idx, cluster := range clusters {
+		lines = append(lines,
+			fmt.Sprintf("  subgraph cluster_%d {", idx),
+			"    label="+dotQuote(cluster)+";")
+		for _, state := range members[cluster] {
+			lines = append(lines, "    "+dotNode(state, annotations))
+		}
+		lines = append(lines, "  }")
+	}
+
+	for _, t := range visitor.transitions {
+		edge := fmt.Sprintf("  %s -> %s", dotQuote(t.Source().Name()), dotQuote(t.Target().Name()))
+		label := transitionLabel(t, afterLabel)
+		attrs := []string{}
+		if taken, ok := annotations.Taken(t); ok {
+			label = strings.TrimSpace(fmt.Sprintf("%s (%d)", label, taken))
+			attrs = append(attrs,
+				"color="+dotQuote(annotations.heatColour(t)),
+				fmt.Sprintf("penwidth=%d", annotations.heatWidth(t)))
+		}
+		if label != "" {
+			attrs = append([]string{"label=" + dotQuote(label)}, attrs...)
+		}
+		if len(attrs) > 0 {
+			edge += " [" + strings.Join(attrs, ", ") + "]"
+		}
+		lines = append(lines, edge+";")
+	}
+	lines = append(lines, "}")
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type definitionVisitor struct {
+	states      []State
+	transitions []Transition
+}
+
+func (d *definitionVisitor) VisitState(state State) {
+	d.states = append(d.states, state)
+}
+func (d *definitionVisitor) VisitTransition(t Transition) {
+	d.transitions = append(d.transitions, t)
+}
+
+func isPseudoState(name string) bool {
+	return name == InitialStateName || name == FinalStateName
+}
+
+func dotNode(state State, annotations *Annotations) string {
+	attrs := []string{}
+	switch state.Name() {
+	case InitialStateName:
+		attrs = append(attrs, "shape=point", "width=0.2")
+	case FinalStateName:
+		attrs = append(attrs, "shape=doublecircle", `label=""`, "width=0.2")
+	default:
+		lines := []string{state.Name()}
+		lines = append(lines, state.StateLabels()...)
+		for _, l := range state.EntryLabels() {
+			lines = append(lines, "entry/"+l)
+		}
+		for _, l := range state.ExitLabels() {
+			lines = append(lines, "exit/"+l)
+		}
+		lines = append(lines, annotations.Notes(state)...)
+		if len(lines) > 1 {
+			attrs = append(attrs, "label="+dotQuote(strings.Join(lines, "\n")))
+		}
+	}
+	if annotations.IsCurrent(state) {
+		attrs = append(attrs, `style="rounded,filled,bold"`, "fillcolor=gold")
+	}
+	node := dotQuote(state.Name())
+	if len(attrs) > 0 {
+		node += " [" + strings.Join(attrs, ", ") + "]"
+	}
+	return node + ";"
+}
+
+// dotQuote returns s as a DOT string, with newlines as line breaks.
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
@@ -0,0 +1,130 @@
+package fsm_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	fsm "github.com/johngrange/gofsm"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DOT Rendering", func() {
+	type fsmData struct {
+	}
+
+	var (
+		stateMachine fsm.ImmediateFSM
+		buf          *bytes.Buffer
+		err          error
+	)
+
+	BeforeEach(func() {
+		stateMachineBuilder := fsm.NewFSMBuilder().SetData(&fsmData{})
+		init := stateMachineBuilder.GetInitialState()
+
+		startingState := fsm.NewStateBuilder("starting")
+		startingState.OnEntry(func(state fsm.State, fsmData interface{}, dispatcher fsm.Dispatcher) {}, "initialise system")
+
+		onState := fsm.NewStateBuilder("on", "powered")
+		offState := fsm.NewStateBuilder("off")
+		dimState := fsm.NewStateBuilder("dim", "powered")
+
+		init.AddTransition(startingState)
+		startingState.AddTransition(offState)
+
+		offState.AddTransition(onState).SetEventTrigger("TurnOn").SetGuard(func(fsmData, eventData interface{}) bool { return true }, "power==active")
+		onState.AddTransition(offState).SetEventTrigger("TurnOff").SetEffect(func(ev fsm.Event, fsmData interface{}, dispatcher fsm.Dispatcher) {}, "perform effect")
+		onState.AddTransition(dimState).SetTimedTrigger(5 * time.Minute)
+		dimState.AddTransition(onState).SetEventTrigger("Touch")
+
+		onState.AddTransition(stateMachineBuilder.AddFinalState()).SetEventTrigger("FatalError").SetEffect(func(ev fsm.Event, fsmData interface{}, dispatcher fsm.Dispatcher) {}, "panic!")
+		onState.OnExit(func(state fsm.State, fsmData interface{}, dispatcher fsm.Dispatcher) {}, "turn out lights")
+
+		stateMachineBuilder.
+			AddState(startingState).
+			AddState(onState).
+			AddState(offState).
+			AddState(dimState)
+
+		stateMachine, err = stateMachineBuilder.BuildImmediateFSM()
+		Expect(err).NotTo(HaveOccurred())
+		buf = &bytes.Buffer{}
+	})
+
+	render := func(golden string, opts ...fsm.DOTOption) string {
+		err = fsm.RenderDOT(buf, stateMachine, opts...)
+		Expect(err).NotTo(HaveOccurred())
+		fmt.Fprintf(GinkgoWriter, "%s\n", buf.String())
+		err = os.MkdirAll(testOutputDir, 0755)
+		Expect(err).NotTo(HaveOccurred())
+		err = os.WriteFile(path.Join(testOutputDir, golden), buf.Bytes(), 0600)
+		Expect(err).NotTo(HaveOccurred())
+		return buf.String()
+	}
+
+	When("rendering dot", func() {
+		It("should get the output right!", func() {
+			expectedDOT :=
+				`digraph fsm {
+  node [shape=box, style=rounded];
+  "initial" [shape=point, width=0.2];
+  "starting" [label="starting\nentry/initialise system"];
+  "on" [label="on\npowered\nexit/turn out lights"];
+  "off";
+  "dim" [label="dim\npowered"];
+  "FinalState" [shape=doublecircle, label="", width=0.2];
+  "initial" -> "starting";
+  "starting" -> "off";
+  "on" -> "off" [label="TurnOff/perform effect"];
+  "on" -> "dim" [label="after 5m0s"];
+  "on" -> "FinalState" [label="FatalError/panic!"];
+  "off" -> "on" [label="TurnOn [power==active]"];
+  "dim" -> "on" [label="Touch"];
+}
+`
+			Expect(render("testone.dot")).To(Equal(expectedDOT))
+		})
+	})
+
+	When("rendering dot with options", func() {
+		It("should set the rank direction, cluster by label and highlight the current state", func() {
+			stateMachine.Start()
+			stateMachine.Dispatch(fsm.NewEvent("TurnOn", nil))
+			Expect(stateMachine.CurrentState().Name()).To(Equal("on"))
+
+			expectedDOT :=
+				`digraph fsm {
+  rankdir=LR;
+  node [shape=box, style=rounded];
+  "initial" [shape=point, width=0.2];
+  "starting" [label="starting\nentry/initialise system"];
+  "off";
+  "FinalState" [shape=doublecircle, label="", width=0.2];
+  subgraph cluster_0 {
+    label="powered";
+    "on" [label="on\npowered\nexit/turn out lights", style="rounded,filled,bold", fillcolor=gold];
+    "dim" [label="dim\npowered"];
+  }
+  "initial" -> "starting";
+  "starting" -> "off";
+  "on" -> "off" [label="TurnOff/perform effect"];
+  "on" -> "dim" [label="after 5m0s"];
+  "on" -> "FinalState" [label="FatalError/panic!"];
+  "off" -> "on" [label="TurnOn [power==active]"];
+  "dim" -> "on" [label="Touch"];
+}
+`
+			Expect(render("testtwo.dot", fsm.DOTRankDir("LR"), fsm.DOTClusterByLabel(), fsm.DOTHighlightCurrent())).To(Equal(expectedDOT))
+		})
+
+		It("should reject an unknown rank direction", func() {
+			err = fsm.RenderDOT(buf, stateMachine, fsm.DOTRankDir("sideways"))
+			Expect(err).To(HaveOccurred())
+			Expect(buf.Len()).To(Equal(0))
+		})
+	})
+})
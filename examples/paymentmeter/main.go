@@ -2,37 +2,60 @@ package main
 
 import (
 	"bytes"
+	"flag"
 	"fmt"
 	"os"
 
 	fsm "github.com/johngrange/gofsm"
 )
 
-func main() {
-	// Set up state machine
-	type currentCoinPayment struct {
-		numCoins  uint
-		coinValue uint
-	}
-	type paymentMeter struct {
-		paymentsCollected uint
-		ticketsIssued     uint
-		ticketCost        uint
-		currentPayment    currentCoinPayment
-	}
+type currentCoinPayment struct {
+	numCoins  uint
+	coinValue uint
+}
+type paymentMeter struct {
+	paymentsCollected uint
+	ticketsIssued     uint
+	ticketCost        uint
+	currentPayment    currentCoinPayment
+}
 
-	var (
-		paymentMeterSM                                                             fsm.ImmediateFSM
-		idleStateBuilder, acceptingPaymentStateBuilder, printingTicketStateBuilder fsm.StateBuilder
-	)
+// behaviour of the meter, used by both the Go and SCXML definitions
 
-	// car park payment meter model
+func addCoin(ev fsm.Event, fsmData interface{}, dispatcher fsm.Dispatcher) {
+	stateData := &(fsmData).(*paymentMeter).currentPayment
+	fmt.Printf("stateData: %+v\n", stateData)
+	coinAmount := ev.Data().(uint)
+	stateData.coinValue += coinAmount
+	stateData.numCoins++
+	fmt.Printf("stateData: %+v\n", stateData)
+} // parameter is coin value: uint
+
+func paidEnough(fsmData, eventData interface{}) bool {
+	meterData := (fsmData).(*paymentMeter)
+
+	return meterData.currentPayment.coinValue >= meterData.ticketCost
+}
+
+func printTicket(ev fsm.Event, fsmData interface{}, dispatcher fsm.Dispatcher) {
+	meter := (fsmData).(*paymentMeter)
+	fmt.Printf("Printing ticket for %dp\n", meter.currentPayment.coinValue)
+	meter.paymentsCollected += meter.currentPayment.coinValue
+	meter.ticketsIssued++
+}
+
+func clearPayment(state fsm.State, fsmData interface{}, dispatcher fsm.Dispatcher) {
+	fmt.Printf("onExit\n")
+	meterData := (fsmData).(*paymentMeter)
+	meterData.currentPayment.coinValue = 0
+	meterData.currentPayment.numCoins = 0
+}
+
+// car park payment meter model
+func newMeterBuilder() fsm.StateMachineBuilder {
+	var idleStateBuilder, acceptingPaymentStateBuilder, printingTicketStateBuilder fsm.StateBuilder
 
-	paymentMeterData := &paymentMeter{
-		ticketCost: 300,
-	}
 	stateMachineBuilder := fsm.NewFSMBuilder()
-	stateMachineBuilder.SetData(paymentMeterData)
 
 	idleStateBuilder = fsm.NewStateBuilder("idle")
 	stateMachineBuilder.GetInitialState().AddTransition(idleStateBuilder)
@@ -41,56 +64,60 @@ func main() {
 
 	printingTicketStateBuilder = fsm.NewStateBuilder("printingTicket")
 
-	idleStateBuilder.AddTransition(acceptingPaymentStateBuilder).SetEventTrigger("evInsertCoin").SetEffect(
-		func(ev fsm.Event, fsmData interface{}, dispatcher fsm.Dispatcher) {
-			stateData := &(fsmData).(*paymentMeter).currentPayment
-			fmt.Printf("stateData: %+v\n", stateData)
-			coinAmount := ev.Data().(uint)
-			stateData.coinValue += coinAmount
-			stateData.numCoins++
-			fmt.Printf("stateData: %+v\n", stateData)
-		}, "coinValue += ev.coinAmount", "numCoins++", // add labels to effect in plant uml output
-	) // parameter is coin value: uint
-
-	acceptingPaymentStateBuilder.AddTransition(acceptingPaymentStateBuilder).SetEventTrigger("evInsertCoin").SetEffect(
-		func(ev fsm.Event, fsmData interface{}, dispatcher fsm.Dispatcher) {
-			stateData := &(fsmData).(*paymentMeter).currentPayment
-			fmt.Printf("stateData: %+v\n", stateData)
-
-			coinAmount := ev.Data().(uint)
-			stateData.coinValue += coinAmount
-			stateData.numCoins++
-
-			fmt.Printf("stateData: %+v\n", stateData)
-		}, "coinValue += ev.coinAmount", "numCoins++", // add labels to effect in plant uml output
-	) // parameter is coin value: uint
+	idleStateBuilder.AddTransition(acceptingPaymentStateBuilder).SetEventTrigger("evInsertCoin").
+		SetEffect(addCoin, "coinValue += ev.coinAmount", "numCoins++") // add labels to effect in plant uml output
+
+	acceptingPaymentStateBuilder.AddTransition(acceptingPaymentStateBuilder).SetEventTrigger("evInsertCoin").
+		SetEffect(addCoin, "coinValue += ev.coinAmount", "numCoins++")
 
 	acceptingPaymentStateBuilder.AddTransition(printingTicketStateBuilder).SetEventTrigger("evPrintTicket").
-		SetGuard(func(fsmData, eventData interface{}) bool {
-			meterData := (fsmData).(*paymentMeter)
-
-			return meterData.currentPayment.coinValue >= meterData.ticketCost
-		}, "currentPayment.coinValue >= ticketCost").
-		SetEffect(func(ev fsm.Event, fsmData interface{}, dispatcher fsm.Dispatcher) {
-			meter := (fsmData).(*paymentMeter)
-			fmt.Printf("Printing ticket for %dp\n", meter.currentPayment.coinValue)
-			meter.paymentsCollected += meter.currentPayment.coinValue
-			meter.ticketsIssued++
-		}, "paymentsCollected += currentPayment.coinValue", "ticketsIssued++")
-
-	acceptingPaymentStateBuilder.OnExit(func(state fsm.State, fsmData interface{}, dispatcher fsm.Dispatcher) {
-		fmt.Printf("onExit\n")
-		meterData := (fsmData).(*paymentMeter)
-		meterData.currentPayment.coinValue = 0
-		meterData.currentPayment.numCoins = 0
-	})
+		SetGuard(paidEnough, "currentPayment.coinValue >= ticketCost").
+		SetEffect(printTicket, "paymentsCollected += currentPayment.coinValue", "ticketsIssued++")
+
+	acceptingPaymentStateBuilder.OnExit(clearPayment)
 
 	printingTicketStateBuilder.AddTransition(idleStateBuilder)
 
-	stateMachineBuilder.
+	return stateMachineBuilder.
 		AddState(idleStateBuilder).
 		AddState(acceptingPaymentStateBuilder).
 		AddState(printingTicketStateBuilder)
+}
+
+// the same model, read from an SCXML file with its behaviour bound by name
+func importMeterBuilder(path string) (fsm.StateMachineBuilder, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	registry := fsm.NewRegistry().
+		Effect("addCoin", addCoin).
+		Guard("paidEnough", paidEnough).
+		Effect("printTicket", printTicket).
+		Action("clearPayment", clearPayment)
+	return fsm.ImportSCXML(f, registry)
+}
+
+func main() {
+	scxmlPath := flag.String("scxml", "", "read the machine definition from an SCXML file, such as paymentmeter.scxml")
+	flag.Parse()
+
+	var paymentMeterSM fsm.ImmediateFSM
+
+	paymentMeterData := &paymentMeter{
+		ticketCost: 300,
+	}
+	stateMachineBuilder := newMeterBuilder()
+	if *scxmlPath != "" {
+		var err error
+		stateMachineBuilder, err = importMeterBuilder(*scxmlPath)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+	stateMachineBuilder.SetData(paymentMeterData)
 
 	// replace with stateMachineBuilder.BuildThreadedFSM() for a state
 	// machine that will run event management in separate go routine
@@ -1,6 +1,9 @@
 package fsm
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 type fsmBuilder struct {
 	initialState       StateBuilder // always populated
@@ -8,6 +11,18 @@ type fsmBuilder struct {
 	stateBuilders      []StateBuilder
 	fsmData            interface{}
 	tracers            []Tracer
+	logger             DiagnosticLogger
+	microstepLimit     int
+	errorState         StateBuilder // may be nil
+	clock              Clock
+	dataCodec          DataCodec
+	payloadCodec       PayloadCodec
+	store              Store // may be nil
+	instanceID         string
+	storeVersion       uint64
+	evaluateOnUpdate   bool
+	strictness         Strictness
+	suppressed         map[IssueCode]bool
 	finalisedImmediate ImmediateFSM
 	finalisedThreaded  FSM
 }
@@ -16,10 +31,16 @@ func NewFSMBuilder() StateMachineBuilder {
 	initialState := NewStateBuilder(InitialStateName)
 	return &fsmBuilder{
 
-		initialState:  initialState,
-		stateBuilders: []StateBuilder{},
-		fsmData:       nil,
-		tracers:       make([]Tracer, 0),
+		initialState:   initialState,
+		stateBuilders:  []StateBuilder{},
+		fsmData:        nil,
+		tracers:        make([]Tracer, 0),
+		logger:         NoopLogger(),
+		microstepLimit: DefaultMicrostepLimit,
+		clock:          RealClock(),
+		dataCodec:      NewJSONDataCodec(),
+		payloadCodec:   NewJSONPayloadCodec(),
+		suppressed:     make(map[IssueCode]bool),
 	}
 }
 
@@ -56,6 +77,9 @@ func (b *fsmBuilder) BuildImmediateFSM() (ImmediateFSM, error) {
 }
 
 func (b *fsmBuilder) newImmediateFSMImpl() (*immediateFSMImpl, error) {
+	if failures := b.validationFailures(); len(failures) > 0 {
+		return nil, &ValidationError{Issues: failures}
+	}
 	initialState, err := b.initialState.build()
 	if err != nil {
 		return nil, err
@@ -78,9 +102,19 @@ func (b *fsmBuilder) newImmediateFSMImpl() (*immediateFSMImpl, error) {
 		eventQueue:          make(chan Event, eventQueueLength),
 		houseKeepStateExit:  func() {}, // do nothing for immediate fsm
 		houseKeepStateEntry: func() {}, // do nothing for immediate fsm
+		houseKeepScheduled:  func(time.Time) {},
+		logger:              b.logger,
+		microstepLimit:      b.microstepLimit,
+		clock:               b.clock,
+		dataCodec:           b.dataCodec,
+		payloadCodec:        b.payloadCodec,
+		store:               b.store,
+		instanceID:          b.instanceID,
+		storeVersion:        b.storeVersion,
+		evaluateOnUpdate:    b.evaluateOnUpdate,
 	}
 	var state State
-	for _, stateBuilder := range b.stateBuilders {
+	for _, stateBuilder := range b.machineStateBuilders() {
 		state, err = stateBuilder.build()
 		if err != nil {
 			return nil, err
@@ -103,13 +137,23 @@ func (b *fsmBuilder) newImmediateFSMImpl() (*immediateFSMImpl, error) {
 			return nil, err
 		}
 	}
-	for _, stateBuilder := range b.stateBuilders {
+	for _, stateBuilder := range b.machineStateBuilders() {
 		err := stateBuilder.buildTransitions()
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	if b.errorState != nil {
+		fsm.errorState, err = b.errorState.build()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cycle := findEventlessCycle(fsm.states); cycle != nil {
+		return nil, &LivelockError{Path: stateNames(cycle)}
+	}
+
 	fsm.dispatcher = fsm
 	return fsm, nil
 }
@@ -129,6 +173,43 @@ func (b *fsmBuilder) BuildThreadedFSM() (FSM, error) {
 	return b.finalisedThreaded, nil
 }
 
+func (b *fsmBuilder) RestoreImmediateFSM(s Snapshot) (ImmediateFSM, error) {
+	if b.finalisedThreaded != nil || b.finalisedImmediate != nil {
+		return nil, errors.New("builder already finalised")
+	}
+	imm, err := b.newImmediateFSMImpl()
+	if err != nil {
+		return nil, err
+	}
+	queued, err := imm.restore(s)
+	if err != nil {
+		return nil, err
+	}
+	b.finalisedImmediate = imm
+	for _, ev := range queued {
+		imm.Dispatch(ev)
+	}
+	return imm, nil
+}
+
+func (b *fsmBuilder) RestoreThreadedFSM(s Snapshot) (FSM, error) {
+	if b.finalisedThreaded != nil || b.finalisedImmediate != nil {
+		return nil, errors.New("builder already finalised")
+	}
+	imm, err := b.newImmediateFSMImpl()
+	if err != nil {
+		return nil, err
+	}
+	threaded := newThreadedFSM(imm).(*threadedFsmImpl)
+	queued, err := imm.restore(s)
+	if err != nil {
+		return nil, err
+	}
+	threaded.resume(queued)
+	b.finalisedThreaded = threaded
+	return threaded, nil
+}
+
 func (b *fsmBuilder) AddState(sb StateBuilder) StateMachineBuilder {
 	b.stateBuilders = append(b.stateBuilders, sb)
 	return b
@@ -139,6 +220,75 @@ func (b *fsmBuilder) NewState(name string, labels ...string) StateBuilder {
 	return sb
 }
 
+func (b *fsmBuilder) SetDiagnosticLogger(l DiagnosticLogger) StateMachineBuilder {
+	if l == nil {
+		l = NoopLogger()
+	}
+	b.logger = l
+	return b
+}
+
+// SetMicrostepLimit sets the livelock detection limit, a limit of 0 or less disables detection.
+func (b *fsmBuilder) SetMicrostepLimit(limit int) StateMachineBuilder {
+	b.microstepLimit = limit
+	return b
+}
+
+func (b *fsmBuilder) SetEvaluateOnDataUpdate(enabled bool) StateMachineBuilder {
+	b.evaluateOnUpdate = enabled
+	return b
+}
+
+func (b *fsmBuilder) SetClock(c Clock) StateMachineBuilder {
+	if c == nil {
+		c = RealClock()
+	}
+	b.clock = c
+	return b
+}
+
+func (b *fsmBuilder) SetDataCodec(c DataCodec) StateMachineBuilder {
+	if c == nil {
+		c = NewJSONDataCodec()
+	}
+	b.dataCodec = c
+	return b
+}
+
+func (b *fsmBuilder) SetPayloadCodec(c PayloadCodec) StateMachineBuilder {
+	if c == nil {
+		c = NewJSONPayloadCodec()
+	}
+	b.payloadCodec = c
+	return b
+}
+
+func (b *fsmBuilder) SetCheckpointStore(store Store, instanceID string) StateMachineBuilder {
+	b.store = store
+	b.instanceID = instanceID
+	return b
+}
+
+func (b *fsmBuilder) SetErrorState(sb StateBuilder) StateMachineBuilder {
+	b.errorState = sb
+	return b
+}
+
+// machineStateBuilders returns the states added to the machine, followed by the error state if it
+// was not added.
+func (b *fsmBuilder) machineStateBuilders() []StateBuilder {
+	builders := append([]StateBuilder{}, b.stateBuilders...)
+	if b.errorState == nil || b.errorState == b.initialState || b.errorState == b.finalState {
+		return builders
+	}
+	for _, sb := range builders {
+		if sb == b.errorState {
+			return builders
+		}
+	}
+	return append(builders, b.errorState)
+}
+
 func (b *fsmBuilder) AddTracer(t Tracer) StateMachineBuilder {
 	b.tracers = append(b.tracers, t)
 	return b
@@ -1,10 +1,9 @@
 package fsm
 
 import (
-	"fmt"
+	"context"
+	"sync"
 	"time"
-
-	"github.com/onsi/ginkgo/v2"
 )
 
 type immediateFSMImpl struct {
@@ -12,7 +11,7 @@ type immediateFSMImpl struct {
 	initialState         State // always populated
 	finalState           State // may be nil
 	states               []State
-	currentState         State
+	currentState         State // written under scheduleMX, see setCurrentState
 	fsmData              interface{}
 	tracers              []Tracer
 	eventProcesingActive bool
@@ -20,6 +19,24 @@ type immediateFSMImpl struct {
 	dispatcher           Dispatcher
 	houseKeepStateExit   func()
 	houseKeepStateEntry  func()
+	logger               DiagnosticLogger
+	microstepLimit       int
+	errorState           State // may be nil
+	clock                Clock
+	dataCodec            DataCodec
+	payloadCodec         PayloadCodec
+	store                Store // may be nil
+	instanceID           string
+	storeVersion         uint64
+	lastCheckpoint       *Snapshot // last snapshot saved to the store, nil if none
+	transitionedInStep   bool
+	enteredInStep        bool                // invariants were checked on entry during the current step
+	blockedInState       map[Transition]bool // eventless transitions reported blocked since the current state was entered
+	houseKeepScheduled   func(due time.Time)
+	evaluateOnUpdate     bool
+	scheduleMX           sync.Mutex
+	scheduled            []scheduledEvent // guarded by scheduleMX
+	nextTimerID          TimerID          // guarded by scheduleMX
 }
 
 func (f *immediateFSMImpl) AddTracer(t Tracer) {
@@ -34,34 +51,84 @@ func (f *immediateFSMImpl) traceTransition(ev Event, source, target State) {
 
 func (f *immediateFSMImpl) Start() {
 	f.running = true
+	f.blockedInState = make(map[Transition]bool)
+	f.traceStart()
 	f.traceOnEntry(f.currentState, f.fsmData)
 	f.currentState.doEntry(f)
+	f.checkEntryInvariants()
+	f.transitionedInStep = true // always checkpoint the started machine
 	f.runToWaitCondition()
+	f.checkpoint()
+	f.checkStepInvariants()
 }
 func (f *immediateFSMImpl) Stop() {
 	f.running = false // stop accepting events on queue
+	f.cancelAllScheduled()
 }
 
 func (f *immediateFSMImpl) Tick() {
 	if f.running {
-		f.runToWaitCondition()
+		f.evaluate()
 	}
 }
+
+// evaluate runs a step with no event, taking any eventless or timed transitions that are due
+func (f *immediateFSMImpl) evaluate() {
+	f.dispatchDue()
+	f.traceEvaluateStep()
+	f.runToWaitCondition()
+	f.checkpoint()
+	f.checkStepInvariants()
+}
 func (f *immediateFSMImpl) runToWaitCondition() {
 	// keep evaluating no event transitions until we can't exit the current state
-	for {
+	steps := 0
+	var path []State // only tracked close to the microstep limit, to report the cycle
+	for f.running {
 		transitioned := false
 		for _, transition := range f.currentState.Transitions() {
-			if transition.shouldTransitionNoEv(f.fsmData) {
+			now := f.clock.Now()
+			if transition.shouldTransitionNoEv(f.fsmData, now) {
 				f.doTransition(nil, transition)
 				transitioned = true
 				break
 			}
+			if transition.IsGuarded() && transition.due(now) && !f.blockedInState[transition] {
+				// re-evaluated on every Tick or poll, so only reported once per visit to the state
+				f.blockedInState[transition] = true
+				f.traceTransitionBlocked(nil, transition)
+			}
 		}
 		if !transitioned {
 			return
 		}
+		if f.microstepLimit <= 0 {
+			continue
+		}
+		steps++
+		if steps >= f.microstepLimit-len(f.states) {
+			path = append(path, f.currentState)
+		}
+		if steps >= f.microstepLimit {
+			f.livelocked(&LivelockError{Path: cycleInPath(path), Limit: f.microstepLimit})
+			return
+		}
+	}
+}
+
+// livelocked reports the error to tracers, then moves to the error state if there is one, or halts.
+func (f *immediateFSMImpl) livelocked(err *LivelockError) {
+	if f.logger.Enabled(LogError) {
+		f.logger.Log(LogError, err.Error(), StateField(f.currentState))
+	}
+	f.traceError(err, f.currentState, f.fsmData)
+	if f.errorState == nil {
+		f.running = false
+		return
 	}
+	f.transitionedInStep = true
+	f.traceTransition(nil, f.currentState, f.errorState)
+	f.changeState(f.errorState)
 }
 func (f *immediateFSMImpl) doTransition(ev Event, transition Transition) {
 	// UML spec 14.2.3.4.5, 14.2.3.4.6
@@ -72,26 +139,38 @@ func (f *immediateFSMImpl) doTransition(ev Event, transition Transition) {
 	// if local transition, do not call exit or entry actions
 	oldState := f.currentState
 	nextState := transition.Target()
-	fmt.Fprintf(ginkgo.GinkgoWriter, "transitioning from %s to %s\n", oldState.Name(), nextState.Name())
+	if f.logger.Enabled(LogDebug) {
+		f.logger.Log(LogDebug, "transitioning", StateField(oldState), TargetStateField(nextState), EventField(ev), TransitionField(transition))
+	}
 
+	f.transitionedInStep = true
 	transition.doAction(ev, f)
 	f.traceTransition(ev, f.currentState, transition.Target())
+	f.traceTransitionTaken(ev, transition)
 
 	if !transition.IsLocal() {
-		oldState.doExit(f)
-		f.houseKeepStateExit()
-		f.traceOnExit(oldState, f.fsmData)
-		f.currentState = nextState
-		nextState.doEntry(f)
-		f.traceOnEntry(nextState, f.fsmData)
-		// start transition timers if transitions need them
-		timeNow := time.Now()
-		for _, transition := range f.currentState.Transitions() {
-			transition.startTimer(timeNow)
-		}
-		f.houseKeepStateEntry()
+		f.changeState(nextState)
 	}
 }
+
+func (f *immediateFSMImpl) changeState(nextState State) {
+	oldState := f.currentState
+	oldState.doExit(f)
+	f.cancelScheduledOnExit(oldState)
+	f.houseKeepStateExit()
+	f.traceOnExit(oldState, f.fsmData)
+	f.setCurrentState(nextState)
+	f.blockedInState = make(map[Transition]bool)
+	nextState.doEntry(f)
+	f.traceOnEntry(nextState, f.fsmData)
+	f.checkEntryInvariants()
+	// start transition timers if transitions need them
+	timeNow := f.clock.Now()
+	for _, transition := range f.currentState.Transitions() {
+		transition.startTimer(timeNow)
+	}
+	f.houseKeepStateEntry()
+}
 func (f *immediateFSMImpl) CurrentState() State {
 	return f.currentState
 }
@@ -113,7 +192,9 @@ func (f *immediateFSMImpl) processImmediateEventQueue() {
 		f.eventProcesingActive = false
 	}()
 	for len(f.eventQueue) > 0 {
-		fmt.Fprintf(ginkgo.GinkgoWriter, "evq %d\n", len(f.eventQueue))
+		if f.logger.Enabled(LogDebug) {
+			f.logger.Log(LogDebug, "processing event queue", LogField{Key: LogKeyQueueLength, Value: len(f.eventQueue)})
+		}
 		ev := <-f.eventQueue
 		f.processEvent(ev)
 	}
@@ -135,15 +216,115 @@ func (f *immediateFSMImpl) traceRejectedEvent(ev Event, state State, fsmData int
 	}
 }
 
+func (f *immediateFSMImpl) traceGuardFailed(ev Event, transition Transition) {
+	for _, t := range f.tracers {
+		if gt, ok := t.(GuardTracer); ok {
+			gt.OnGuardFailed(ev, transition, transition.failedGuard(), f.fsmData)
+		}
+	}
+}
+
+func (f *immediateFSMImpl) traceTransitionTaken(ev Event, transition Transition) {
+	for _, t := range f.tracers {
+		if tt, ok := t.(TransitionTracer); ok {
+			tt.OnTransitionTaken(ev, transition, f.fsmData)
+		}
+	}
+}
+
+func (f *immediateFSMImpl) traceTransitionBlocked(ev Event, transition Transition) {
+	for _, t := range f.tracers {
+		if tt, ok := t.(TransitionTracer); ok {
+			tt.OnTransitionBlocked(ev, transition, f.fsmData)
+		}
+	}
+}
+
+func (f *immediateFSMImpl) traceError(err error, state State, fsmData interface{}) {
+	for _, t := range f.tracers {
+		if et, ok := t.(ErrorTracer); ok {
+			et.OnError(err, state, fsmData)
+		}
+	}
+}
+
+func (f *immediateFSMImpl) traceStart() {
+	for _, t := range f.tracers {
+		if st, ok := t.(StepTracer); ok {
+			st.OnStart(f.clock.Now())
+		}
+	}
+}
+
+func (f *immediateFSMImpl) traceEventStep(ev Event) {
+	for _, t := range f.tracers {
+		if st, ok := t.(StepTracer); ok {
+			st.OnEventStep(ev, f.clock.Now())
+		}
+	}
+}
+
+func (f *immediateFSMImpl) traceEvaluateStep() {
+	for _, t := range f.tracers {
+		if st, ok := t.(StepTracer); ok {
+			st.OnEvaluateStep(f.clock.Now())
+		}
+	}
+}
+
 func (f *immediateFSMImpl) processEvent(ev Event) {
+	f.traceEventStep(ev)
+	// guard failures only explain the event if no other transition accepts it
+	guardFailed := []Transition{}
 	for _, transition := range f.currentState.Transitions() {
 		if transition.shouldTransitionEv(ev, f.fsmData) {
 			f.doTransition(ev, transition)
 			f.runToWaitCondition()
+			f.checkpoint()
+			f.checkStepInvariants()
 			return
 		}
+		if transition.TriggerType() == EventTrigger && transition.EventName() == ev.Name() && transition.acceptsPayload(ev.Data()) {
+			guardFailed = append(guardFailed, transition)
+			f.traceTransitionBlocked(ev, transition)
+		}
+	}
+	for _, transition := range guardFailed {
+		f.traceGuardFailed(ev, transition)
 	}
 	f.traceRejectedEvent(ev, f.currentState, f.fsmData)
+	f.checkpoint()
+	f.checkStepInvariants()
+}
+
+// checkEntryInvariants checks the invariants of a state as it is entered, so that states left again
+// by eventless transitions in the same step are checked too.
+func (f *immediateFSMImpl) checkEntryInvariants() {
+	f.enteredInStep = true
+	f.checkInvariants()
+}
+
+// checkStepInvariants checks the invariants at the end of a run-to-completion step.  A step that
+// entered the current state has already checked them, so they are not reported twice.
+func (f *immediateFSMImpl) checkStepInvariants() {
+	if !f.enteredInStep {
+		f.checkInvariants()
+	}
+	f.enteredInStep = false
+}
+
+// checkInvariants reports the invariants of the current state that do not hold.
+func (f *immediateFSMImpl) checkInvariants() {
+	for _, label := range f.currentState.violatedInvariants(f.fsmData) {
+		if f.logger.Enabled(LogError) {
+			f.logger.Log(LogError, "invariant violated: "+label, StateField(f.currentState))
+		}
+		for _, t := range f.tracers {
+			if it, ok := t.(InvariantTracer); ok {
+				it.OnInvariantViolated(f.currentState, label, f.fsmData)
+			}
+		}
+	}
 }
 
 func (f *immediateFSMImpl) Visit(v Visitor) {
@@ -159,6 +340,83 @@ func (f *immediateFSMImpl) GetData() interface{} {
 	return f.fsmData
 }
 
+// WithData runs fn with the machine's data, then evaluates the machine if SetEvaluateOnDataUpdate
+// was set, or checkpoints it otherwise.
+func (f *immediateFSMImpl) WithData(fn func(data interface{})) {
+	fn(f.fsmData)
+	f.dataUpdated()
+}
+
+// dataUpdated evaluates or checkpoints the machine after WithData.
+func (f *immediateFSMImpl) dataUpdated() {
+	switch {
+	case !f.running:
+	case f.evaluateOnUpdate:
+		f.evaluate()
+	default:
+		f.checkpoint()
+	}
+}
+
+func (f *immediateFSMImpl) ReadData(fn func(data interface{})) {
+	fn(f.fsmData)
+}
+
 func (f *immediateFSMImpl) GetDispatcher() Dispatcher {
 	return f.dispatcher
 }
+
+func (f *immediateFSMImpl) NextDeadline() (time.Time, bool) {
+	var next time.Time
+	found := false
+	timeNow := f.clock.Now()
+	for _, transition := range f.currentState.Transitions() {
+		deadline, ok := transition.deadline()
+		// expired timers whose guards were false at expiry are only re-evaluated by a later Tick
+		if !ok || deadline.Before(timeNow) {
+			continue
+		}
+		if !found || deadline.Before(next) {
+			next = deadline
+			found = true
+		}
+	}
+	if due, ok := f.nextScheduled(); ok && (!found || due.Before(next)) {
+		// overdue scheduled events are returned, they are dispatched by the next Tick
+		next = due
+		found = true
+	}
+	return next, found
+}
+
+func (f *immediateFSMImpl) RunLoop(ctx context.Context, events <-chan Event) error {
+	timer := time.NewTimer(time.Hour)
+	stopTimer := func() {
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+	}
+	defer stopTimer()
+	for {
+		stopTimer()
+		var timerChan <-chan time.Time
+		if deadline, ok := f.NextDeadline(); ok {
+			timer.Reset(deadline.Sub(f.clock.Now()))
+			timerChan = timer.C
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ev, ok := <-events:
+			if !ok {
+				return nil
+			}
+			f.Dispatch(ev)
+		case <-timerChan:
+			f.Tick()
+		}
+	}
+}
@@ -1,22 +1,23 @@
 package fsm
 
 import (
-	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/onsi/ginkgo/v2"
 )
 
 type threadedFsmImpl struct {
 	base                *immediateFSMImpl
-	stop                chan struct{}
+	stop                chan struct{} // closed by Stop, to have the event queue go routine stop the machine
+	stopped             chan struct{} // closed once the machine has stopped
 	eventQueue          chan Event
 	mx, currStateMX     sync.RWMutex
 	evaluateFSMChan     chan struct{} // entries in here trigger a re-evaluation of the FSM
 	haltStateGoRoutines chan struct{} // closed when in-state go routines should exit (state change occurring).
 	currentState        State
 	currentStateChan    chan State
+	requeued            []Event // guarded by mx
+	running             int32   // accessed atomically, so that Dispatch does not need mx
 }
 
 const eventQueueLength = 50
@@ -38,6 +39,10 @@ func newThreadedFSM(base *immediateFSMImpl) FSM {
 		fsm.stopTransitionTimers()
 	}
 
+	fsm.base.houseKeepScheduled = func(due time.Time) {
+		time.AfterFunc(due.Sub(fsm.base.clock.Now()), fsm.requestEvaluation)
+	}
+
 	fsm.base.dispatcher = fsm
 	fsm.currentState = fsm.base.currentState
 	return fsm
@@ -45,29 +50,45 @@ func newThreadedFSM(base *immediateFSMImpl) FSM {
 
 func (f *threadedFsmImpl) Start() {
 	f.stop = make(chan struct{})
+	f.stopped = make(chan struct{})
 	f.currStateMX.Lock()
 	defer f.currStateMX.Unlock()
+	atomic.StoreInt32(&f.running, 1)
 	f.base.Start()
 	f.currentState = f.base.currentState
 	go f.runEventQueue()
 	go f.runCurrentStateChan()
 }
+
+// Stop stops the machine after the step in progress, if any, without waiting for it.  It may be
+// called from the machine's own actions.
 func (f *threadedFsmImpl) Stop() {
-	f.base.Stop()
-	close(f.stop)
+	if atomic.CompareAndSwapInt32(&f.running, 1, 0) {
+		close(f.stop)
+	}
 }
 
 func (f *threadedFsmImpl) startTransitionTimers() {
-	f.haltStateGoRoutines = make(chan struct{})
+	halt := make(chan struct{})
+	f.haltStateGoRoutines = halt
 	for _, transition := range f.base.CurrentState().Transitions() {
 		if transition.TriggerType() == TimerTrigger {
+			transition := transition
+			wait := transition.TimerDuration()
+			if deadline, ok := transition.deadline(); ok {
+				wait = deadline.Sub(f.base.clock.Now())
+			}
 			go func() {
 				select {
-				case <-time.After(transition.TimerDuration()):
-					fmt.Fprintf(ginkgo.GinkgoWriter, "%v timer expired for transition %s to %s\n", transition.TimerDuration(), transition.Source().Name(), transition.Target().Name())
-					f.evaluateFSMChan <- struct{}{}
-				case <-f.haltStateGoRoutines:
-					fmt.Fprintf(ginkgo.GinkgoWriter, "%v timer cancelled for transition %s to %s\n", transition.TimerDuration(), transition.Source().Name(), transition.Target().Name())
+				case <-time.After(wait):
+					if f.base.logger.Enabled(LogDebug) {
+						f.base.logger.Log(LogDebug, "timer expired", TransitionField(transition), TimerField(transition.TimerDuration()))
+					}
+					f.requestEvaluation()
+				case <-halt:
+					if f.base.logger.Enabled(LogDebug) {
+						f.base.logger.Log(LogDebug, "timer cancelled", TransitionField(transition), TimerField(transition.TimerDuration()))
+					}
 					return
 				}
 			}()
@@ -81,7 +102,7 @@ func (f *threadedFsmImpl) stopTransitionTimers() {
 func (f *threadedFsmImpl) runCurrentStateChan() {
 	for {
 		select {
-		case <-f.stop:
+		case <-f.stopped:
 			return
 		case s := <-f.currentStateChan:
 			f.currStateMX.Lock()
@@ -95,38 +116,122 @@ func (f *threadedFsmImpl) runEventQueue() {
 	for {
 		select {
 		case <-f.stop:
-			return
-		case ev := <-f.eventQueue:
-			fmt.Fprintf(ginkgo.GinkgoWriter, "processing event %+v\n", ev)
 			f.mx.Lock()
-			fmt.Fprintf(ginkgo.GinkgoWriter, "current state before %+v\n", f.base.currentState)
-			initialState := f.base.currentState
-			f.base.processEvent(ev)
-			fmt.Fprintf(ginkgo.GinkgoWriter, "current state after %+v\n", f.base.currentState)
-			if initialState != f.base.currentState {
-				f.currentStateChan <- f.base.currentState
-			}
+			f.base.Stop()
 			f.mx.Unlock()
+			close(f.stopped)
+			return
+		case ev := <-f.eventQueue:
+			f.step(func() {
+				f.processEvent(ev)
+			})
 		case <-time.After(dataPollPeriod):
-			f.evaluateFSMChan <- struct{}{}
+			f.requestEvaluation()
 		case <-f.evaluateFSMChan:
 			// received instruction to re-evaluate FSM, so do so
-			f.mx.Lock()
-			initialState := f.base.currentState
-			f.base.runToWaitCondition()
-			if initialState != f.base.currentState {
-				f.currentStateChan <- f.base.currentState
-			}
-			f.mx.Unlock()
+			f.step(f.base.evaluate)
 		}
 	}
 }
+
+// step runs one run-to-completion step under f.mx, unless the machine has been stopped.
+func (f *threadedFsmImpl) step(run func()) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	if !f.base.running || atomic.LoadInt32(&f.running) == 0 {
+		return
+	}
+	initialState := f.base.currentState
+	f.processRequeued()
+	run()
+	if initialState != f.base.currentState {
+		f.currentStateChan <- f.base.currentState
+	}
+}
+
+// requestEvaluation asks the event queue go routine to evaluate the machine, without blocking.
+func (f *threadedFsmImpl) requestEvaluation() {
+	select {
+	case f.evaluateFSMChan <- struct{}{}:
+	default:
+		// evaluation already pending, or picked up by the next poll
+	}
+}
+
+// processEvent must be called with f.mx held
+func (f *threadedFsmImpl) processEvent(ev Event) {
+	if f.base.logger.Enabled(LogDebug) {
+		f.base.logger.Log(LogDebug, "processing event", EventField(ev), StateField(f.base.currentState))
+	}
+	f.base.processEvent(ev)
+	if f.base.logger.Enabled(LogDebug) {
+		f.base.logger.Log(LogDebug, "processed event", EventField(ev), StateField(f.base.currentState))
+	}
+}
+
+// processRequeued processes events taken off the event queue by Snapshot, or restored from a
+// snapshot, ahead of anything still on the queue.  It must be called with f.mx held.
+func (f *threadedFsmImpl) processRequeued() {
+	for len(f.requeued) > 0 {
+		ev := f.requeued[0]
+		f.requeued = f.requeued[1:]
+		f.processEvent(ev)
+	}
+}
+
+// Snapshot captures the machine between run-to-completion steps.  Events dispatched
+// concurrently with Snapshot may or may not be included in its queue.
+func (f *threadedFsmImpl) Snapshot() (Snapshot, error) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	for drained := false; !drained; {
+		select {
+		case ev := <-f.eventQueue:
+			f.requeued = append(f.requeued, ev)
+		default:
+			drained = true
+		}
+	}
+	return f.base.snapshot(f.requeued)
+}
+
+// resume starts a machine restored from a snapshot without calling entry actions.
+func (f *threadedFsmImpl) resume(queued []Event) {
+	f.stop = make(chan struct{})
+	f.stopped = make(chan struct{})
+	f.currStateMX.Lock()
+	f.currentState = f.base.currentState
+	f.currStateMX.Unlock()
+	f.requeued = append(f.requeued, queued...)
+	atomic.StoreInt32(&f.running, 1)
+	f.startTransitionTimers()
+	go f.runEventQueue()
+	go f.runCurrentStateChan()
+}
+
 func (f *threadedFsmImpl) Dispatch(ev Event) {
-	if f.base.running {
+	if atomic.LoadInt32(&f.running) == 1 {
 		f.eventQueue <- ev
 	}
 }
 
+func (f *threadedFsmImpl) DispatchAfter(delay time.Duration, ev Event, opts ...ScheduleOption) TimerID {
+	return f.base.DispatchAfter(delay, ev, opts...)
+}
+
+func (f *threadedFsmImpl) DispatchAt(t time.Time, ev Event, opts ...ScheduleOption) TimerID {
+	return f.base.DispatchAt(t, ev, opts...)
+}
+
+func (f *threadedFsmImpl) Cancel(id TimerID) bool {
+	return f.base.Cancel(id)
+}
+
+// reportError must be called from the machine's own actions, with f.mx held
+func (f *threadedFsmImpl) reportError(err error) {
+	f.base.reportError(err)
+}
+
 func (f *threadedFsmImpl) AddTracer(t Tracer) {
 	f.mx.Lock()
 	f.base.AddTracer(t)
@@ -151,6 +256,24 @@ func (f *threadedFsmImpl) GetData() interface{} {
 	return f.base.fsmData
 }
 
+func (f *threadedFsmImpl) WithData(fn func(data interface{})) {
+	f.mx.Lock()
+	fn(f.base.fsmData)
+	if f.base.running && !f.base.evaluateOnUpdate {
+		f.base.checkpoint()
+	}
+	f.mx.Unlock()
+	if f.base.evaluateOnUpdate {
+		f.requestEvaluation()
+	}
+}
+
+func (f *threadedFsmImpl) ReadData(fn func(data interface{})) {
+	f.mx.RLock()
+	defer f.mx.RUnlock()
+	fn(f.base.fsmData)
+}
+
 func (f *threadedFsmImpl) GetDispatcher() Dispatcher {
 	// does not get changed once sm built
 	return f
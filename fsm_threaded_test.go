@@ -149,10 +149,29 @@ var _ = Describe("Threaded FSM", func() {
 			stateMachine.Start()
 
 			Consistently(currStateName).Should(Equal("off"))
-			data.followGuardOffToOn = true
+			stateMachine.WithData(func(d interface{}) {
+				d.(*fsmData).followGuardOffToOn = true
+			})
 			Eventually(currStateName).Should(Equal("on"))
 
 		})
 
 	})
+	When("stopped from an action", func() {
+		It("should stop after the step, without deadlocking", func() {
+			onState.OnEntry(func(state fsm.State, fsmData interface{}, dispatcher fsm.Dispatcher) {
+				stateMachine.Stop()
+			})
+			stateMachine, err = stateMachineBuilder.BuildThreadedFSM()
+			Expect(err).NotTo(HaveOccurred())
+			stateMachine.Start()
+			Eventually(currStateName).Should(Equal("off"))
+
+			stateMachine.Dispatch(fsm.NewEvent("TurnOn", nil))
+			Eventually(currStateName).Should(Equal("on"))
+			stateMachine.Dispatch(fsm.NewEvent("TurnOff", nil))
+			Consistently(currStateName).Should(Equal("on"))
+			stateMachine.Stop()
+		})
+	})
 })
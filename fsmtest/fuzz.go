@@ -0,0 +1,384 @@
+// Package fsmtest helps test state machines built with gofsm.
+package fsmtest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	fsm "github.com/johngrange/gofsm"
+)
+
+const DefaultMaxSteps = 50
+
+// StepKind is the kind of stimulus a fuzzing step applies to the machine.
+type StepKind int
+
+const (
+	StepEvent StepKind = iota // dispatch an event
+	StepTimer                 // advance the clock just past a timer, then tick
+	StepTick                  // tick without advancing the clock
+)
+
+// Step is one stimulus in a fuzzed sequence.
+type Step struct {
+	Kind    StepKind
+	Event   string
+	Payload interface{}
+	Advance time.Duration
+}
+
+func (s Step) String() string {
+	switch s.Kind {
+	case StepEvent:
+		if s.Payload != nil {
+			return fmt.Sprintf("dispatch %s(%v)", s.Event, s.Payload)
+		}
+		return "dispatch " + s.Event
+	case StepTimer:
+		return "advance " + s.Advance.String()
+	default:
+		return "tick"
+	}
+}
+
+// Source supplies the fuzzer's input to payload generators.  Once the input is used up, it
+// supplies zeros.
+type Source struct {
+	data []byte
+	pos  int
+}
+
+func (s *Source) Byte() byte {
+	if s.pos >= len(s.data) {
+		return 0
+	}
+	b := s.data[s.pos]
+	s.pos++
+	return b
+}
+
+// Intn returns a number in [0, n).
+func (s *Source) Intn(n int) int {
+	if n <= 0 {
+		return 0
+	}
+	v := int(s.Byte())<<8 | int(s.Byte())
+	return v % n
+}
+
+func (s *Source) Bool() bool {
+	return s.Byte()&1 == 1
+}
+
+func (s *Source) exhausted() bool {
+	return s.pos >= len(s.data)
+}
+
+// PayloadGenerator makes the payload of a fuzzed event from the fuzzer's input.
+type PayloadGenerator func(src *Source) interface{}
+
+// Check is called after the machine starts and after every step.  A non-nil error fails the
+// sequence.
+type Check func(sm fsm.ImmediateFSM) error
+
+type Option func(h *Harness)
+
+// WithPayload generates payloads for an event.  Events without a generator are dispatched
+// without a payload.
+func WithPayload(event string, gen PayloadGenerator) Option {
+	return func(h *Harness) {
+		h.payloads[event] = gen
+	}
+}
+
+// WithMaxSteps limits the length of generated sequences, DefaultMaxSteps by default.
+func WithMaxSteps(n int) Option {
+	return func(h *Harness) {
+		h.maxSteps = n
+	}
+}
+
+// WithCheck adds a check run after every step, in addition to the machine's state invariants.
+func WithCheck(check Check) Option {
+	return func(h *Harness) {
+		h.checks = append(h.checks, check)
+	}
+}
+
+// Failure is a sequence of steps that made the machine fail.
+type Failure struct {
+	Steps []Step // the steps up to and including the one that failed, empty if Start failed
+	Err   error
+}
+
+func (f *Failure) Error() string {
+	lines := []string{fmt.Sprintf("%v, after %d steps:", f.Err, len(f.Steps))}
+	for idx, step := range f.Steps {
+		lines = append(lines, fmt.Sprintf("  %d. %s", idx+1, step))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (f *Failure) Unwrap() error {
+	return f.Err
+}
+
+// InvariantError is the failure caused by a state invariant that did not hold.
+type InvariantError struct {
+	State string
+	Label string
+}
+
+func (e *InvariantError) Error() string {
+	return fmt.Sprintf("invariant [%s] violated in state %s", e.Label, e.State)
+}
+
+// Harness turns fuzzer input into event sequences for a machine definition, runs them and
+// shrinks those that fail.
+type Harness struct {
+	newMachine func(clock *fsm.FakeClock) (fsm.ImmediateFSM, error)
+	events     []string
+	timers     []time.Duration
+	payloads   map[string]PayloadGenerator
+	checks     []Check
+	maxSteps   int
+}
+
+// NewHarness builds a machine with newMachine to find the event and timer triggers it declares.
+// newMachine must return a new, unstarted machine using the given clock on every call.
+func NewHarness(newMachine func(clock *fsm.FakeClock) (fsm.ImmediateFSM, error), opts ...Option) (*Harness, error) {
+	h := &Harness{
+		newMachine: newMachine,
+		events:     []string{},
+		timers:     []time.Duration{},
+		payloads:   make(map[string]PayloadGenerator),
+		maxSteps:   DefaultMaxSteps,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	sm, err := newMachine(fsm.NewFakeClock(startTime))
+	if err != nil {
+		return nil, err
+	}
+	sm.Visit(triggerVisitor{h: h, seen: make(map[string]bool)})
+	sort.Slice(h.timers, func(i, j int) bool { return h.timers[i] < h.timers[j] })
+	return h, nil
+}
+
+var startTime = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type triggerVisitor struct {
+	h    *Harness
+	seen map[string]bool
+}
+
+func (v triggerVisitor) VisitState(state fsm.State) {}
+func (v triggerVisitor) VisitTransition(t fsm.Transition) {
+	switch t.TriggerType() {
+	case fsm.EventTrigger:
+		if !v.seen[t.EventName()] {
+			v.h.events = append(v.h.events, t.EventName())
+		}
+		v.seen[t.EventName()] = true
+	case fsm.TimerTrigger:
+		key := t.TimerDuration().String()
+		if !v.seen[key] {
+			v.h.timers = append(v.h.timers, t.TimerDuration())
+		}
+		v.seen[key] = true
+	}
+}
+
+// Steps decodes fuzzer input into a sequence of steps.  Each step takes a byte to choose an event,
+// a timer or a tick, followed by the bytes its payload generator uses.
+func (h *Harness) Steps(input []byte) []Step {
+	src := &Source{data: input}
+	choices := len(h.events) + len(h.timers) + 1
+	steps := []Step{}
+	for !src.exhausted() && len(steps) < h.maxSteps {
+		choice := int(src.Byte()) % choices
+		switch {
+		case choice < len(h.events):
+			step := Step{Kind: StepEvent, Event: h.events[choice]}
+			if gen, ok := h.payloads[step.Event]; ok {
+				step.Payload = gen(src)
+			}
+			steps = append(steps, step)
+		case choice < len(h.events)+len(h.timers):
+			steps = append(steps, Step{Kind: StepTimer, Advance: h.timers[choice-len(h.events)]})
+		default:
+			steps = append(steps, Step{Kind: StepTick})
+		}
+	}
+	return steps
+}
+
+// Seeds returns inputs that take each step once, and all of them in turn, for a seed corpus.
+func (h *Harness) Seeds() [][]byte {
+	choices := len(h.events) + len(h.timers) + 1
+	all := []byte{}
+	seeds := [][]byte{}
+	for choice := 0; choice < choices; choice++ {
+		seeds = append(seeds, []byte{byte(choice)})
+		all = append(all, byte(choice))
+	}
+	return append(seeds, all)
+}
+
+type failureRecorder struct {
+	err error
+}
+
+func (r *failureRecorder) OnEntry(state fsm.State, fsmData interface{}) {}
+func (r *failureRecorder) OnExit(state fsm.State, fsmData interface{})  {}
+func (r *failureRecorder) OnTransition(ev fsm.Event, sourceState, targetState fsm.State, fsmData interface{}) {
+}
+func (r *failureRecorder) OnRejectedEvent(ev fsm.Event, state fsm.State, fsmData interface{}) {}
+
+func (r *failureRecorder) OnInvariantViolated(state fsm.State, label string, fsmData interface{}) {
+	if r.err == nil {
+		r.err = &InvariantError{State: state.Name(), Label: label}
+	}
+}
+
+func (r *failureRecorder) OnError(err error, state fsm.State, fsmData interface{}) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// Run runs a new machine through the steps.  It returns a *Failure if a state invariant is
+// violated, the machine reports an error, a check fails or the machine panics, or the error
+// from building the machine.
+func (h *Harness) Run(steps []Step) (err error) {
+	clock := fsm.NewFakeClock(startTime)
+	sm, err := h.newMachine(clock)
+	if err != nil {
+		return err
+	}
+	recorder := &failureRecorder{}
+	sm.AddTracer(recorder)
+	taken := 0
+	defer func() {
+		if p := recover(); p != nil {
+			err = &Failure{Steps: steps[:taken], Err: fmt.Errorf("panic: %v", p)}
+		}
+	}()
+	check := func() error {
+		if recorder.err != nil {
+			return recorder.err
+		}
+		for _, c := range h.checks {
+			if err := c(sm); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	sm.Start()
+	if err := check(); err != nil {
+		return &Failure{Steps: []Step{}, Err: err}
+	}
+	for _, step := range steps {
+		taken++
+		switch step.Kind {
+		case StepEvent:
+			sm.Dispatch(fsm.NewEvent(step.Event, step.Payload))
+		case StepTimer:
+			clock.Advance(step.Advance + 1) // timers fire once their deadline has passed
+			sm.Tick()
+		default:
+			sm.Tick()
+		}
+		if err := check(); err != nil {
+			return &Failure{Steps: steps[:taken], Err: err}
+		}
+	}
+	sm.Stop()
+	return nil
+}
+
+// Shrink returns the shortest failure it can find by removing steps from a failing sequence,
+// keeping the same error message.  It returns nil if the steps do not fail.
+func (h *Harness) Shrink(steps []Step) *Failure {
+	failure, ok := h.Run(steps).(*Failure)
+	if !ok {
+		return nil
+	}
+	fails := func(candidate []Step) *Failure {
+		f, ok := h.Run(candidate).(*Failure)
+		if ok && f.Err.Error() == failure.Err.Error() {
+			return f
+		}
+		return nil
+	}
+	// delta debugging: remove ever smaller chunks until no single step can be removed
+	chunks := 2
+	for len(failure.Steps) > 1 {
+		current := failure.Steps
+		size := (len(current) + chunks - 1) / chunks
+		reduced := false
+		for start := 0; start < len(current); start += size {
+			end := start + size
+			if end > len(current) {
+				end = len(current)
+			}
+			candidate := append(append([]Step{}, current[:start]...), current[end:]...)
+			if f := fails(candidate); f != nil {
+				failure = f
+				if chunks > 2 {
+					chunks--
+				}
+				reduced = true
+				break
+			}
+		}
+		if !reduced {
+			if size == 1 {
+				break
+			}
+			chunks *= 2
+			if chunks > len(current) {
+				chunks = len(current)
+			}
+		}
+	}
+	return failure
+}
+
+// Fuzz runs the machine through sequences generated from the fuzzer's input, failing with a
+// shrunk sequence when the machine fails.  The harness's seeds are added to the corpus.
+func (h *Harness) Fuzz(f *testing.F) {
+	for _, seed := range h.Seeds() {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, input []byte) {
+		steps := h.Steps(input)
+		err := h.Run(steps)
+		if _, ok := err.(*Failure); ok {
+			t.Fatal(h.Shrink(steps))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// Fuzz fuzzes a machine definition with Go's native fuzzing, checking state invariants and any
+// checks given as options:
+//
+//	func FuzzMeter(f *testing.F) {
+//		fsmtest.Fuzz(f, newMeter, fsmtest.WithPayload("evInsertCoin", coinValue))
+//	}
+func Fuzz(f *testing.F, newMachine func(clock *fsm.FakeClock) (fsm.ImmediateFSM, error), opts ...Option) {
+	h, err := NewHarness(newMachine, opts...)
+	if err != nil {
+		f.Fatal(err)
+	}
+	h.Fuzz(f)
+}
@@ -0,0 +1,148 @@
+package fsmtest_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	fsm "github.com/johngrange/gofsm"
+	"github.com/johngrange/gofsm/fsmtest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type meter struct {
+	credit            uint
+	paymentsCollected uint
+	ticketsIssued     uint
+}
+
+// newMeterBuilder builds a payment meter.  The faulty meter issues a ticket without collecting
+// payment when the ticket is not taken in time.
+func newMeterBuilder(faulty bool) fsmtest.BuilderFunc {
+	return func(clock *fsm.FakeClock) fsm.StateMachineBuilder {
+		addCoin := func(ev fsm.Event, fsmData interface{}, dispatcher fsm.Dispatcher) {
+			fsmData.(*meter).credit += ev.Data().(uint)
+		}
+		collect := func(ev fsm.Event, fsmData interface{}, dispatcher fsm.Dispatcher) {
+			m := fsmData.(*meter)
+			m.paymentsCollected++
+			m.credit = 0
+		}
+		smb := fsm.NewFSMBuilder().SetClock(clock).SetData(&meter{})
+		idle := smb.NewState("idle").Invariant(func(fsmData interface{}) bool {
+			m := fsmData.(*meter)
+			return m.ticketsIssued <= m.paymentsCollected
+		}, "ticketsIssued <= paymentsCollected")
+		accepting := smb.NewState("accepting")
+		printing := smb.NewState("printing").OnEntry(func(state fsm.State, fsmData interface{}, dispatcher fsm.Dispatcher) {
+			fsmData.(*meter).ticketsIssued++
+		})
+		smb.GetInitialState().AddTransition(idle)
+		idle.AddTransition(accepting).SetEventTrigger("evCoin").SetEffect(addCoin)
+		accepting.AddTransition(accepting).SetEventTrigger("evCoin").SetEffect(addCoin)
+		accepting.AddTransition(printing).SetEventTrigger("evPrint").SetGuard(
+			func(fsmData, eventData interface{}) bool { return fsmData.(*meter).credit >= 200 })
+		accepting.AddTransition(idle).SetTimedTrigger(time.Minute).SetEffect(collect)
+		printing.AddTransition(idle).SetEventTrigger("evTaken").SetEffect(collect)
+		timeout := printing.AddTransition(idle).SetTimedTrigger(10 * time.Second)
+		if !faulty {
+			timeout.SetEffect(collect)
+		}
+		return smb
+	}
+}
+
+func newMeter(faulty bool) func(clock *fsm.FakeClock) (fsm.ImmediateFSM, error) {
+	return func(clock *fsm.FakeClock) (fsm.ImmediateFSM, error) {
+		return newMeterBuilder(faulty)(clock).BuildImmediateFSM()
+	}
+}
+
+var coinValues = []uint{10, 50, 100, 200}
+
+func coinValue(src *fsmtest.Source) interface{} {
+	return coinValues[src.Intn(len(coinValues))]
+}
+
+func FuzzMeter(f *testing.F) {
+	fsmtest.Fuzz(f, newMeter(false), fsmtest.WithPayload("evCoin", coinValue))
+}
+
+var _ = Describe("Fuzzing", func() {
+	var (
+		h   *fsmtest.Harness
+		err error
+	)
+
+	coin := func(value uint) fsmtest.Step {
+		return fsmtest.Step{Kind: fsmtest.StepEvent, Event: "evCoin", Payload: value}
+	}
+	event := func(name string) fsmtest.Step {
+		return fsmtest.Step{Kind: fsmtest.StepEvent, Event: name}
+	}
+	advance := func(d time.Duration) fsmtest.Step {
+		return fsmtest.Step{Kind: fsmtest.StepTimer, Advance: d}
+	}
+	tick := fsmtest.Step{Kind: fsmtest.StepTick}
+
+	BeforeEach(func() {
+		h, err = fsmtest.NewHarness(newMeter(true), fsmtest.WithPayload("evCoin", coinValue))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should generate steps from the declared triggers", func() {
+		// choices: evCoin, evPrint, evTaken, 10s, 1m, tick
+		Expect(h.Steps([]byte{0, 0, 3, 1, 2, 3, 4, 5, 6})).To(Equal([]fsmtest.Step{
+			coin(200), event("evPrint"), event("evTaken"), advance(10 * time.Second),
+			advance(time.Minute), tick, coin(10),
+		}))
+		Expect(h.Seeds()).To(HaveLen(7))
+	})
+	It("should limit the number of steps", func() {
+		h, err = fsmtest.NewHarness(newMeter(true), fsmtest.WithMaxSteps(2))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(h.Steps([]byte{1, 1, 1, 1})).To(HaveLen(2))
+	})
+	It("should pass sequences that keep the invariants", func() {
+		Expect(h.Run([]fsmtest.Step{coin(200), event("evPrint"), event("evTaken"), tick})).To(Succeed())
+	})
+	It("should report invariant violations with the steps that caused them", func() {
+		err := h.Run([]fsmtest.Step{coin(200), event("evPrint"), advance(10 * time.Second), tick})
+		var failure *fsmtest.Failure
+		Expect(errors.As(err, &failure)).To(BeTrue())
+		Expect(failure.Steps).To(HaveLen(3))
+		var invErr *fsmtest.InvariantError
+		Expect(errors.As(err, &invErr)).To(BeTrue())
+		Expect(invErr.State).To(Equal("idle"))
+		Expect(err.Error()).To(Equal(`invariant [ticketsIssued <= paymentsCollected] violated in state idle, after 3 steps:
+  1. dispatch evCoin(200)
+  2. dispatch evPrint
+  3. advance 10s`))
+	})
+	It("should report failed checks and panics", func() {
+		h, err = fsmtest.NewHarness(newMeter(false), fsmtest.WithCheck(func(sm fsm.ImmediateFSM) error {
+			if sm.CurrentState().Name() == "printing" {
+				return fmt.Errorf("printing")
+			}
+			return nil
+		}))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(h.Run([]fsmtest.Step{coin(200), event("evPrint")})).To(MatchError(ContainSubstring("printing, after 2 steps")))
+		// evCoin without a payload generator has no coin value
+		Expect(h.Run([]fsmtest.Step{event("evCoin")})).To(MatchError(ContainSubstring("panic: ")))
+	})
+	It("should shrink failing sequences", func() {
+		steps := []fsmtest.Step{
+			tick, coin(100), event("evTaken"), advance(10 * time.Second), coin(10), coin(100),
+			event("evPrint"), tick, coin(50), advance(10 * time.Second), event("evPrint"), tick,
+		}
+		failure := h.Shrink(steps)
+		Expect(failure).NotTo(BeNil())
+		Expect(failure.Steps).To(Equal([]fsmtest.Step{
+			coin(100), coin(100), event("evPrint"), advance(10 * time.Second),
+		}))
+		Expect(h.Shrink([]fsmtest.Step{tick})).To(BeNil())
+	})
+})
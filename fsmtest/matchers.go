@@ -0,0 +1,98 @@
+package fsmtest
+
+import (
+	"fmt"
+	"strings"
+
+	fsm "github.com/johngrange/gofsm"
+	"github.com/onsi/gomega/types"
+)
+
+// BeInState succeeds if the actual machine, State or Recorder is in the named state.  Use a
+// Recorder, or Eventually, with threaded machines.
+func BeInState(name string) types.GomegaMatcher {
+	return &stateMatcher{expected: name}
+}
+
+type stateMatcher struct {
+	expected string
+	actual   string
+}
+
+func (m *stateMatcher) Match(actual interface{}) (bool, error) {
+	switch a := actual.(type) {
+	case fsm.FSM:
+		m.actual = a.CurrentState().Name()
+	case fsm.State:
+		m.actual = a.Name()
+	case *Recorder:
+		m.actual = a.State()
+	default:
+		return false, fmt.Errorf("BeInState expects an fsm.FSM, fsm.State or *fsmtest.Recorder, got %T", actual)
+	}
+	return m.actual == m.expected, nil
+}
+
+func (m *stateMatcher) FailureMessage(actual interface{}) string {
+	return fmt.Sprintf("Expected machine to be in state %q, but it is in %q", m.expected, m.actual)
+}
+
+func (m *stateMatcher) NegatedFailureMessage(actual interface{}) string {
+	return fmt.Sprintf("Expected machine not to be in state %q", m.expected)
+}
+
+// HaveTransitioned succeeds if the actual Recorder has recorded a transition between the named
+// states, triggered by onEvent, or by no event if onEvent is empty.
+func HaveTransitioned(from, to, onEvent string) types.GomegaMatcher {
+	return &traceMatcher{expected: Entry{Kind: EntryTransition, From: from, To: to, Event: onEvent}}
+}
+
+// HaveRejected succeeds if the actual Recorder has recorded a rejection of the named event.
+func HaveRejected(event string) types.GomegaMatcher {
+	return &traceMatcher{expected: Entry{Kind: EntryRejected, Event: event}}
+}
+
+type traceMatcher struct {
+	expected Entry
+	trace    []Entry
+}
+
+func (m *traceMatcher) Match(actual interface{}) (bool, error) {
+	r, ok := actual.(*Recorder)
+	if !ok {
+		return false, fmt.Errorf("expected a *fsmtest.Recorder, got %T", actual)
+	}
+	m.trace = r.Trace()
+	for _, e := range m.trace {
+		if e == m.expected || (m.expected.Kind == EntryRejected && e.Kind == EntryRejected && e.Event == m.expected.Event) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *traceMatcher) describeExpected() string {
+	if m.expected.Kind == EntryRejected {
+		return "rejected " + m.expected.Event
+	}
+	return m.expected.String()
+}
+
+func (m *traceMatcher) FailureMessage(actual interface{}) string {
+	return fmt.Sprintf("Expected trace to contain\n    %s\nactual trace:\n%s", m.describeExpected(), formatTrace(m.trace))
+}
+
+func (m *traceMatcher) NegatedFailureMessage(actual interface{}) string {
+	return fmt.Sprintf("Expected trace not to contain\n    %s\nactual trace:\n%s", m.describeExpected(), formatTrace(m.trace))
+}
+
+func formatTrace(trace []Entry) string {
+	if len(trace) == 0 {
+		return "    (empty)"
+	}
+	lines := make([]string, len(trace))
+	for idx, e := range trace {
+		lines[idx] = "    " + e.String()
+	}
+	return strings.Join(lines, "\n")
+}
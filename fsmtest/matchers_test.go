@@ -0,0 +1,69 @@
+package fsmtest_test
+
+import (
+	"time"
+
+	fsm "github.com/johngrange/gofsm"
+	"github.com/johngrange/gofsm/fsmtest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Matchers", func() {
+	var (
+		sm       fsm.ImmediateFSM
+		recorder *fsmtest.Recorder
+	)
+
+	BeforeEach(func() {
+		var err error
+		sm, err = newMeter(false)(fsm.NewFakeClock(time.Now()))
+		Expect(err).NotTo(HaveOccurred())
+		recorder = fsmtest.Record(sm)
+		sm.Start()
+		sm.Dispatch(fsm.NewEvent("evCoin", uint(100)))
+		sm.Dispatch(fsm.NewEvent("evPrint", nil))
+	})
+
+	It("should match the current state", func() {
+		Expect(sm).To(fsmtest.BeInState("accepting"))
+		Expect(sm.CurrentState()).To(fsmtest.BeInState("accepting"))
+		Expect(recorder).NotTo(fsmtest.BeInState("idle"))
+		matcher := fsmtest.BeInState("idle")
+		Expect(matcher.Match(sm)).To(BeFalse())
+		Expect(matcher.FailureMessage(sm)).To(Equal(`Expected machine to be in state "idle", but it is in "accepting"`))
+		_, err := matcher.Match("idle")
+		Expect(err).To(HaveOccurred())
+	})
+	It("should match transitions and rejections", func() {
+		Expect(recorder).To(fsmtest.HaveTransitioned("initial", "idle", ""))
+		Expect(recorder).To(fsmtest.HaveTransitioned("idle", "accepting", "evCoin"))
+		Expect(recorder).NotTo(fsmtest.HaveTransitioned("accepting", "printing", "evPrint"))
+		Expect(recorder).To(fsmtest.HaveRejected("evPrint"))
+		Expect(recorder).NotTo(fsmtest.HaveRejected("evCoin"))
+	})
+	It("should show the actual trace on failure", func() {
+		matcher := fsmtest.HaveTransitioned("accepting", "printing", "evPrint")
+		Expect(matcher.Match(recorder)).To(BeFalse())
+		Expect(matcher.FailureMessage(recorder)).To(Equal(`Expected trace to contain
+    accepting --> printing : evPrint
+actual trace:
+    initial --> idle
+    idle --> accepting : evCoin
+    rejected evPrint in accepting`))
+		recorder.Reset()
+		Expect(matcher.Match(recorder)).To(BeFalse())
+		Expect(matcher.FailureMessage(recorder)).To(HaveSuffix("actual trace:\n    (empty)"))
+	})
+	It("should work with threaded machines", func() {
+		threaded, err := newMeterBuilder(false)(fsm.NewFakeClock(time.Now())).BuildThreadedFSM()
+		Expect(err).NotTo(HaveOccurred())
+		recorder := fsmtest.Record(threaded)
+		threaded.Start()
+		defer threaded.Stop()
+		threaded.Dispatch(fsm.NewEvent("evCoin", uint(200)))
+		threaded.Dispatch(fsm.NewEvent("evPrint", nil))
+		Eventually(recorder).Should(fsmtest.BeInState("printing"))
+		Expect(recorder).To(fsmtest.HaveTransitioned("accepting", "printing", "evPrint"))
+	})
+})
@@ -0,0 +1,153 @@
+package fsmtest
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	fsm "github.com/johngrange/gofsm"
+)
+
+// syncEvent is dispatched to threaded machines to find out when the steps before it have
+// completed.  It is left out of recorded traces.
+const syncEvent = "fsmtest.sync"
+
+type EntryKind int
+
+const (
+	EntryTransition EntryKind = iota
+	EntryRejected
+)
+
+// Entry is a transition taken, or an event rejected, by a machine.
+type Entry struct {
+	Kind  EntryKind
+	From  string // the state the event was rejected in, for rejections
+	To    string
+	Event string // empty for eventless and timed transitions
+}
+
+func (e Entry) String() string {
+	if e.Kind == EntryRejected {
+		return fmt.Sprintf("rejected %s in %s", e.Event, e.From)
+	}
+	s := fmt.Sprintf("%s --> %s", e.From, e.To)
+	if e.Event != "" {
+		s += " : " + e.Event
+	}
+	return s
+}
+
+// Recorder is a tracer recording the trace that the matchers check.  It is safe to read while
+// a threaded machine is running.
+type Recorder struct {
+	mx            sync.Mutex
+	entries       []Entry
+	state         string
+	evaluateSteps int
+	syncs         int
+}
+
+// Record adds a new Recorder to a machine.  Add it before starting the machine to record the
+// transitions taken by Start.
+func Record(sm fsm.Observable) *Recorder {
+	r := &Recorder{entries: []Entry{}}
+	sm.AddTracer(r)
+	return r
+}
+
+// Trace returns the entries recorded so far.
+func (r *Recorder) Trace() []Entry {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	return append([]Entry{}, r.entries...)
+}
+
+// Reset forgets the entries recorded so far.
+func (r *Recorder) Reset() {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.entries = []Entry{}
+}
+
+// State returns the name of the state last entered, as seen by the machine's own go routine.
+func (r *Recorder) State() string {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	return r.state
+}
+
+func (r *Recorder) OnEntry(state fsm.State, fsmData interface{}) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.state = state.Name()
+}
+func (r *Recorder) OnExit(state fsm.State, fsmData interface{}) {}
+func (r *Recorder) OnTransition(ev fsm.Event, sourceState, targetState fsm.State, fsmData interface{}) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	e := Entry{Kind: EntryTransition, From: sourceState.Name(), To: targetState.Name()}
+	if ev != nil {
+		e.Event = ev.Name()
+	}
+	r.entries = append(r.entries, e)
+}
+func (r *Recorder) OnRejectedEvent(ev fsm.Event, state fsm.State, fsmData interface{}) {
+	if ev.Name() == syncEvent {
+		return
+	}
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.entries = append(r.entries, Entry{Kind: EntryRejected, From: state.Name(), Event: ev.Name()})
+}
+
+func (r *Recorder) OnStart(when time.Time) {}
+func (r *Recorder) OnEventStep(ev fsm.Event, when time.Time) {
+	if ev.Name() != syncEvent {
+		return
+	}
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.syncs++
+}
+func (r *Recorder) OnEvaluateStep(when time.Time) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.evaluateSteps++
+}
+
+func (r *Recorder) counts() (evaluateSteps, syncs int) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	return r.evaluateSteps, r.syncs
+}
+
+// sync waits until a threaded machine has completed the steps it was given before the call.
+func (r *Recorder) sync(sm fsm.FSM, timeout time.Duration) error {
+	_, syncs := r.counts()
+	sm.Dispatch(fsm.NewEvent(syncEvent, nil))
+	return waitFor(timeout, func() bool {
+		_, now := r.counts()
+		return now > syncs
+	})
+}
+
+// evaluated waits until a threaded machine has started an evaluate step since the call.
+func (r *Recorder) evaluated(timeout time.Duration) error {
+	steps, _ := r.counts()
+	return waitFor(timeout, func() bool {
+		now, _ := r.counts()
+		return now > steps
+	})
+}
+
+func waitFor(timeout time.Duration, done func() bool) error {
+	deadline := time.Now().Add(timeout)
+	for !done() {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("machine did not respond within %s", timeout)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return nil
+}
@@ -0,0 +1,333 @@
+package fsmtest
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	fsm "github.com/johngrange/gofsm"
+)
+
+// DefaultTimeout is how long a scenario waits for a threaded machine to process each step.
+const DefaultTimeout = time.Second
+
+// BuilderFunc returns a new builder for the machine under test, using the given clock.  It is
+// called more than once per run.
+type BuilderFunc func(clock *fsm.FakeClock) fsm.StateMachineBuilder
+
+type effect struct {
+	description string
+	check       func(data interface{}) bool
+}
+
+type scenarioStep struct {
+	event   fsm.Event     // nil for timer steps
+	advance time.Duration // for timer steps
+	then    string        // empty if the state is not checked
+	effects []effect
+}
+
+func (s scenarioStep) String() string {
+	if s.event == nil {
+		return "after " + s.advance.String()
+	}
+	return s.event.Name()
+}
+
+// Scenario is a scripted test of a machine, written as
+//
+//	fsmtest.Given("accepting").When(fsm.NewEvent("evPrint", nil)).Then("printing").AndEffect(...)
+//
+// Each When or WhenAfter starts a step, which the following Then and AndEffect calls check.
+type Scenario struct {
+	given     string
+	givenData []func(data interface{})
+	steps     []scenarioStep
+	timeout   time.Duration
+}
+
+// Given starts a scenario with the machine in the named state.  Machines given the initial state
+// are started, running entry actions.  Machines given any other state are restored into it, as if
+// from a snapshot, without running entry actions, and with the state's timers just started.
+func Given(state string) *Scenario {
+	return &Scenario{given: state, timeout: DefaultTimeout}
+}
+
+// WithData changes the machine's data before the first step.
+func (s *Scenario) WithData(fn func(data interface{})) *Scenario {
+	s.givenData = append(s.givenData, fn)
+	return s
+}
+
+// WithTimeout sets how long to wait for a threaded machine to process each step.
+func (s *Scenario) WithTimeout(timeout time.Duration) *Scenario {
+	s.timeout = timeout
+	return s
+}
+
+// When dispatches an event.
+func (s *Scenario) When(ev fsm.Event) *Scenario {
+	s.steps = append(s.steps, scenarioStep{event: ev})
+	return s
+}
+
+// WhenAfter advances the clock just past d, so that timers of duration d fire.
+func (s *Scenario) WhenAfter(d time.Duration) *Scenario {
+	s.steps = append(s.steps, scenarioStep{advance: d})
+	return s
+}
+
+// Then checks that the machine is in the named state after the step.
+func (s *Scenario) Then(state string) *Scenario {
+	s.lastStep().then = state
+	return s
+}
+
+// AndEffect checks the machine's data after the step.
+func (s *Scenario) AndEffect(description string, check func(data interface{}) bool) *Scenario {
+	step := s.lastStep()
+	step.effects = append(step.effects, effect{description: description, check: check})
+	return s
+}
+
+func (s *Scenario) lastStep() *scenarioStep {
+	if len(s.steps) == 0 {
+		panic("fsmtest: Then or AndEffect called before When")
+	}
+	return &s.steps[len(s.steps)-1]
+}
+
+// ScenarioError describes the first step of a scenario that failed, with the expected and actual
+// traces up to that step.
+type ScenarioError struct {
+	Step     int // from 1, 0 for failures before the first step
+	Message  string
+	Expected []Entry
+	Actual   []Entry
+}
+
+func (e *ScenarioError) Error() string {
+	return fmt.Sprintf("scenario failed at step %d: %s\ntrace (- expected, + actual):\n%s",
+		e.Step, e.Message, diffTrace(e.Expected, e.Actual))
+}
+
+// driver applies steps to an immediate or threaded machine, returning once they are processed.
+type driver struct {
+	sm       fsm.FSM
+	settle   func() error // waits for the machine to process what it has been given
+	dispatch func(ev fsm.Event) error
+	advance  func(d time.Duration) error
+}
+
+// RunImmediate runs the scenario against an immediate machine.
+func (s *Scenario) RunImmediate(newBuilder BuilderFunc) error {
+	clock := fsm.NewFakeClock(startTime)
+	smb, recorder := s.builder(newBuilder, clock)
+	var sm fsm.ImmediateFSM
+	var err error
+	if s.given == fsm.InitialStateName {
+		sm, err = smb.BuildImmediateFSM()
+	} else {
+		var snapshot fsm.Snapshot
+		if snapshot, err = s.snapshot(newBuilder); err == nil {
+			sm, err = smb.RestoreImmediateFSM(snapshot)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	d := driver{
+		sm:     sm,
+		settle: func() error { return nil },
+		dispatch: func(ev fsm.Event) error {
+			sm.Dispatch(ev)
+			return nil
+		},
+		advance: func(d time.Duration) error {
+			clock.Advance(d + 1) // timers fire once their deadline has passed
+			sm.Tick()
+			return nil
+		},
+	}
+	defer sm.Stop()
+	return s.run(d, recorder)
+}
+
+// RunThreaded runs the scenario against a threaded machine, waiting for it to process each step.
+func (s *Scenario) RunThreaded(newBuilder BuilderFunc) error {
+	clock := fsm.NewFakeClock(startTime)
+	smb, recorder := s.builder(newBuilder, clock)
+	var sm fsm.FSM
+	var err error
+	if s.given == fsm.InitialStateName {
+		sm, err = smb.BuildThreadedFSM()
+	} else {
+		var snapshot fsm.Snapshot
+		if snapshot, err = s.snapshot(newBuilder); err == nil {
+			sm, err = smb.RestoreThreadedFSM(snapshot)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	d := driver{
+		sm:     sm,
+		settle: func() error { return recorder.sync(sm, s.timeout) },
+		dispatch: func(ev fsm.Event) error {
+			sm.Dispatch(ev)
+			return recorder.sync(sm, s.timeout)
+		},
+		advance: func(d time.Duration) error {
+			clock.Advance(d + 1)
+			// threaded machines poll, so wait for an evaluation that sees the new time
+			if err := recorder.evaluated(s.timeout); err != nil {
+				return err
+			}
+			return recorder.sync(sm, s.timeout)
+		},
+	}
+	defer sm.Stop()
+	return s.run(d, recorder)
+}
+
+func (s *Scenario) builder(newBuilder BuilderFunc, clock *fsm.FakeClock) (fsm.StateMachineBuilder, *Recorder) {
+	recorder := &Recorder{entries: []Entry{}, state: s.given}
+	return newBuilder(clock).AddTracer(recorder), recorder
+}
+
+// snapshot returns a snapshot of the given state, with its timers just started.
+func (s *Scenario) snapshot(newBuilder BuilderFunc) (fsm.Snapshot, error) {
+	probe, err := newBuilder(fsm.NewFakeClock(startTime)).BuildImmediateFSM()
+	if err != nil {
+		return fsm.Snapshot{}, err
+	}
+	v := &timerVisitor{state: s.given, timers: []fsm.TimerSnapshot{}}
+	probe.Visit(v)
+	if !v.found {
+		return fsm.Snapshot{}, fmt.Errorf("given state %q not found in machine", s.given)
+	}
+	return fsm.Snapshot{Version: fsm.SnapshotVersion, State: s.given, Timers: v.timers}, nil
+}
+
+type timerVisitor struct {
+	state  string
+	found  bool
+	index  int
+	timers []fsm.TimerSnapshot
+}
+
+func (v *timerVisitor) VisitState(state fsm.State) {
+	if state.Name() == v.state {
+		v.found = true
+	}
+}
+func (v *timerVisitor) VisitTransition(t fsm.Transition) {
+	if t.Source().Name() != v.state {
+		return
+	}
+	if t.TriggerType() == fsm.TimerTrigger {
+		v.timers = append(v.timers, fsm.TimerSnapshot{Transition: v.index, Target: t.Target().Name(), Remaining: t.TimerDuration()})
+	}
+	v.index++
+}
+
+func (s *Scenario) run(d driver, recorder *Recorder) error {
+	for _, fn := range s.givenData {
+		d.sm.WithData(fn)
+	}
+	if s.given == fsm.InitialStateName {
+		d.sm.Start()
+	}
+	expected := []Entry{}
+	fail := func(step int, format string, args ...interface{}) error {
+		return &ScenarioError{Step: step, Message: fmt.Sprintf(format, args...), Expected: expected, Actual: recorder.Trace()}
+	}
+	if err := d.settle(); err != nil {
+		return fail(0, "%v", err)
+	}
+	// transitions taken by Start are expected
+	expected = append(expected, recorder.Trace()...)
+
+	state := recorder.State()
+	for idx, step := range s.steps {
+		taken := len(recorder.Trace())
+		var err error
+		if step.event != nil {
+			err = d.dispatch(step.event)
+		} else {
+			err = d.advance(step.advance)
+		}
+		if err != nil {
+			return fail(idx+1, "when %s: %v", step, err)
+		}
+		actualState := recorder.State()
+		if step.then != "" && actualState != step.then {
+			// the simplest trace that would have passed
+			if step.then != state {
+				expected = append(expected, Entry{Kind: EntryTransition, From: state, To: step.then, Event: eventName(step.event)})
+			} else if step.event != nil {
+				expected = append(expected, Entry{Kind: EntryRejected, From: state, Event: step.event.Name()})
+			}
+			return fail(idx+1, "when %s: expected state %q, machine in %q", step, step.then, actualState)
+		}
+		// whatever the machine did met the expectations
+		expected = append(expected, recorder.Trace()[taken:]...)
+		for _, e := range step.effects {
+			ok := false
+			d.sm.ReadData(func(data interface{}) { ok = e.check(data) })
+			if !ok {
+				return fail(idx+1, "when %s: expected effect %q", step, e.description)
+			}
+		}
+		state = actualState
+	}
+	return nil
+}
+
+func eventName(ev fsm.Event) string {
+	if ev == nil {
+		return ""
+	}
+	return ev.Name()
+}
+
+// diffTrace lists the entries of both traces, marking those only expected with "-" and those
+// only in the actual trace with "+".
+func diffTrace(expected, actual []Entry) string {
+	// longest common subsequence
+	lcs := make([][]int, len(expected)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(actual)+1)
+	}
+	for i := len(expected) - 1; i >= 0; i-- {
+		for j := len(actual) - 1; j >= 0; j-- {
+			if expected[i] == actual[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	lines := []string{}
+	i, j := 0, 0
+	for i < len(expected) || j < len(actual) {
+		switch {
+		case i < len(expected) && j < len(actual) && expected[i] == actual[j]:
+			lines = append(lines, "    "+expected[i].String())
+			i++
+			j++
+		case j < len(actual) && (i == len(expected) || lcs[i][j+1] >= lcs[i+1][j]):
+			lines = append(lines, "  + "+actual[j].String())
+			j++
+		default:
+			lines = append(lines, "  - "+expected[i].String())
+			i++
+		}
+	}
+	if len(lines) == 0 {
+		return "    (empty)"
+	}
+	return strings.Join(lines, "\n")
+}
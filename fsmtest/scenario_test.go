@@ -0,0 +1,70 @@
+package fsmtest_test
+
+import (
+	"errors"
+	"time"
+
+	fsm "github.com/johngrange/gofsm"
+	"github.com/johngrange/gofsm/fsmtest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Scenarios", func() {
+	credit := func(data interface{}) uint { return data.(*meter).credit }
+
+	for _, runner := range []struct {
+		name string
+		run  func(s *fsmtest.Scenario, newBuilder fsmtest.BuilderFunc) error
+	}{
+		{"immediate", (*fsmtest.Scenario).RunImmediate},
+		{"threaded", (*fsmtest.Scenario).RunThreaded},
+	} {
+		runner := runner
+		When("running against "+runner.name+" machines", func() {
+			It("should pass scenarios that hold", func() {
+				scenario := fsmtest.Given(fsm.InitialStateName).
+					When(fsm.NewEvent("evCoin", uint(100))).Then("accepting").
+					AndEffect("credit 100", func(data interface{}) bool { return credit(data) == 100 }).
+					When(fsm.NewEvent("evPrint", nil)).Then("accepting").
+					When(fsm.NewEvent("evCoin", uint(100))).
+					When(fsm.NewEvent("evPrint", nil)).Then("printing").
+					AndEffect("ticket issued", func(data interface{}) bool { return data.(*meter).ticketsIssued == 1 })
+				Expect(runner.run(scenario, newMeterBuilder(false))).To(Succeed())
+			})
+			It("should start in the given state with its timers running", func() {
+				scenario := fsmtest.Given("printing").
+					WithData(func(data interface{}) { data.(*meter).ticketsIssued = 1 }).
+					WhenAfter(10*time.Second).Then("idle").
+					AndEffect("payment collected", func(data interface{}) bool { return data.(*meter).paymentsCollected == 1 })
+				Expect(runner.run(scenario, newMeterBuilder(false))).To(Succeed())
+			})
+			It("should report the failed step with a trace diff", func() {
+				scenario := fsmtest.Given("accepting").
+					WithData(func(data interface{}) { data.(*meter).credit = 150 }).
+					When(fsm.NewEvent("evCoin", uint(10))).Then("accepting").
+					When(fsm.NewEvent("evPrint", nil)).Then("printing")
+				err := runner.run(scenario, newMeterBuilder(false))
+				var scenarioErr *fsmtest.ScenarioError
+				Expect(errors.As(err, &scenarioErr)).To(BeTrue())
+				Expect(scenarioErr.Step).To(Equal(2))
+				Expect(err.Error()).To(Equal(`scenario failed at step 2: when evPrint: expected state "printing", machine in "accepting"
+trace (- expected, + actual):
+    accepting --> accepting : evCoin
+  + rejected evPrint in accepting
+  - accepting --> printing : evPrint`))
+			})
+			It("should report effects not seen", func() {
+				scenario := fsmtest.Given("idle").
+					When(fsm.NewEvent("evCoin", uint(10))).
+					AndEffect("credit 20", func(data interface{}) bool { return credit(data) == 20 })
+				Expect(runner.run(scenario, newMeterBuilder(false))).To(MatchError(
+					ContainSubstring(`scenario failed at step 1: when evCoin: expected effect "credit 20"`)))
+			})
+			It("should fail for unknown states", func() {
+				Expect(runner.run(fsmtest.Given("nowhere"), newMeterBuilder(false))).To(
+					MatchError(`given state "nowhere" not found in machine`))
+			})
+		})
+	}
+})
@@ -0,0 +1,13 @@
+package fsmtest_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestFsmtest(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Fsmtest Suite")
+}
@@ -0,0 +1,128 @@
+package fsm
+
+import "strings"
+
+// Predicate is a guard condition that knows its own label.  Predicates are made with Guard and
+// combined with AllOf, AnyOf and Negate, then set on a transition with TransitionBuilder.SetPredicate.
+type Predicate interface {
+	// Label returns the condition as guard text, e.g. "hasCredit && !doorOpen".
+	Label() string
+	// evaluate returns the result of the predicate and, if false, the label of the
+	// sub-predicate responsible.
+	evaluate(fsmData, eventData interface{}) (bool, string)
+	precedence() int
+}
+
+const (
+	orPrecedence = iota
+	andPrecedence
+	notPrecedence
+	namedPrecedence
+)
+
+type namedPredicate struct {
+	name  string
+	guard TransitionGuard
+}
+
+// Guard names a guard function so that it can be combined with other predicates.
+func Guard(name string, guard TransitionGuard) Predicate {
+	return namedPredicate{name: name, guard: guard}
+}
+
+func (p namedPredicate) Label() string {
+	return p.name
+}
+func (p namedPredicate) evaluate(fsmData, eventData interface{}) (bool, string) {
+	if p.guard(fsmData, eventData) {
+		return true, ""
+	}
+	return false, p.name
+}
+func (p namedPredicate) precedence() int {
+	return namedPrecedence
+}
+
+type andPredicate []Predicate
+
+// AllOf is true if all of its predicates are true.  Predicates are evaluated in order, stopping at
+// the first that is false, which is reported as the reason for failure.
+func AllOf(predicates ...Predicate) Predicate {
+	return andPredicate(predicates)
+}
+
+func (p andPredicate) Label() string {
+	return joinLabels(p, " && ", andPrecedence)
+}
+func (p andPredicate) evaluate(fsmData, eventData interface{}) (bool, string) {
+	for _, sub := range p {
+		if ok, failed := sub.evaluate(fsmData, eventData); !ok {
+			return false, failed
+		}
+	}
+	return true, ""
+}
+func (p andPredicate) precedence() int {
+	return andPrecedence
+}
+
+type orPredicate []Predicate
+
+// AnyOf is true if any of its predicates is true.  Predicates are evaluated in order, stopping at
+// the first that is true.  If all are false the whole AnyOf is reported as the reason for failure.
+func AnyOf(predicates ...Predicate) Predicate {
+	return orPredicate(predicates)
+}
+
+func (p orPredicate) Label() string {
+	return joinLabels(p, " || ", orPrecedence)
+}
+func (p orPredicate) evaluate(fsmData, eventData interface{}) (bool, string) {
+	for _, sub := range p {
+		if ok, _ := sub.evaluate(fsmData, eventData); ok {
+			return true, ""
+		}
+	}
+	return false, p.Label()
+}
+func (p orPredicate) precedence() int {
+	return orPrecedence
+}
+
+type notPredicate struct {
+	predicate Predicate
+}
+
+// Negate is true if its predicate is false.
+func Negate(predicate Predicate) Predicate {
+	return notPredicate{predicate: predicate}
+}
+
+func (p notPredicate) Label() string {
+	return "!" + wrapLabel(p.predicate, notPrecedence)
+}
+func (p notPredicate) evaluate(fsmData, eventData interface{}) (bool, string) {
+	if ok, _ := p.predicate.evaluate(fsmData, eventData); ok {
+		return false, p.Label()
+	}
+	return true, ""
+}
+func (p notPredicate) precedence() int {
+	return notPrecedence
+}
+
+func joinLabels(predicates []Predicate, sep string, precedence int) string {
+	labels := make([]string, len(predicates))
+	for idx, p := range predicates {
+		labels[idx] = wrapLabel(p, precedence)
+	}
+	return strings.Join(labels, sep)
+}
+
+// wrapLabel puts a predicate's label in parentheses if it binds less tightly than its parent.
+func wrapLabel(p Predicate, parentPrecedence int) string {
+	if p.precedence() < parentPrecedence {
+		return "(" + p.Label() + ")"
+	}
+	return p.Label()
+}
@@ -0,0 +1,130 @@
+package fsm_test
+
+import (
+	"bytes"
+
+	fsm "github.com/johngrange/gofsm"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type guardFailure struct {
+	event, target, failed string
+}
+
+type guardRecorder struct {
+	fsm.StateCounter
+	failures []guardFailure
+}
+
+func (g *guardRecorder) OnGuardFailed(ev fsm.Event, transition fsm.Transition, failed string, fsmData interface{}) {
+	g.failures = append(g.failures, guardFailure{ev.Name(), transition.Target().Name(), failed})
+}
+
+var _ = Describe("Guard predicates", func() {
+	type vendData struct {
+		credit    uint
+		price     uint
+		doorOpen  bool
+		inService bool
+	}
+	hasCredit := fsm.Guard("hasCredit", func(fsmData, eventData interface{}) bool {
+		d := fsmData.(*vendData)
+		return d.credit >= d.price
+	})
+	doorOpen := fsm.Guard("doorOpen", func(fsmData, eventData interface{}) bool {
+		return fsmData.(*vendData).doorOpen
+	})
+	inService := fsm.Guard("inService", func(fsmData, eventData interface{}) bool {
+		return fsmData.(*vendData).inService
+	})
+	isFree := fsm.Guard("isFree", func(fsmData, eventData interface{}) bool {
+		return fsmData.(*vendData).price == 0
+	})
+
+	It("should label combined predicates", func() {
+		Expect(fsm.AllOf(hasCredit, fsm.Negate(doorOpen)).Label()).To(Equal("hasCredit && !doorOpen"))
+		Expect(fsm.AnyOf(hasCredit, isFree).Label()).To(Equal("hasCredit || isFree"))
+		Expect(fsm.AllOf(fsm.AnyOf(hasCredit, isFree), inService).Label()).To(Equal("(hasCredit || isFree) && inService"))
+		Expect(fsm.AnyOf(fsm.AllOf(hasCredit, inService), isFree).Label()).To(Equal("hasCredit && inService || isFree"))
+		Expect(fsm.Negate(fsm.AllOf(doorOpen, inService)).Label()).To(Equal("!(doorOpen && inService)"))
+		Expect(fsm.Negate(fsm.Negate(doorOpen)).Label()).To(Equal("!!doorOpen"))
+	})
+
+	Context("on a machine", func() {
+		var (
+			sm       fsm.ImmediateFSM
+			data     *vendData
+			recorder *guardRecorder
+			logger   *fsm.Logger
+		)
+		BeforeEach(func() {
+			data = &vendData{price: 100, inService: true}
+			smb := fsm.NewFSMBuilder().SetData(data)
+			idle := smb.NewState("idle")
+			vending := smb.NewState("vending")
+			smb.GetInitialState().AddTransition(idle)
+			idle.AddTransition(vending).SetEventTrigger("evVend").
+				SetPredicate(fsm.AllOf(fsm.AnyOf(hasCredit, isFree), fsm.Negate(doorOpen), inService))
+			vending.AddTransition(idle).SetEventTrigger("evDone")
+			vending.AddTransition(idle).SetEventTrigger("evCancel").SetPredicate(doorOpen)
+			vending.AddTransition(idle).SetEventTrigger("evCancel")
+
+			var err error
+			sm, err = smb.BuildImmediateFSM()
+			Expect(err).NotTo(HaveOccurred())
+			recorder = &guardRecorder{StateCounter: *fsm.NewStateCounter()}
+			sm.AddTracer(recorder)
+			logger = fsm.NewFSMLogger()
+			sm.AddTracer(logger)
+			sm.Start()
+		})
+
+		It("should render the predicate as the guard text", func() {
+			buf := bytes.Buffer{}
+			Expect(fsm.RenderPlantUML(&buf, sm)).To(Succeed())
+			Expect(buf.String()).To(ContainSubstring("idle --> vending : evVend [(hasCredit || isFree) && !doorOpen && inService]"))
+		})
+		It("should report which sub-predicate rejected an event", func() {
+			sm.Dispatch(fsm.NewEvent("evVend", nil))
+			data.credit = 100
+			data.doorOpen = true
+			sm.Dispatch(fsm.NewEvent("evVend", nil))
+			data.doorOpen = false
+			data.inService = false
+			sm.Dispatch(fsm.NewEvent("evVend", nil))
+			Expect(sm.CurrentState().Name()).To(Equal("idle"))
+			Expect(recorder.failures).To(Equal([]guardFailure{
+				{"evVend", "vending", "hasCredit || isFree"},
+				{"evVend", "vending", "!doorOpen"},
+				{"evVend", "vending", "inService"},
+			}))
+			Expect(recorder.RejectedEventCounts).To(HaveKeyWithValue("evVend", uint64(3)))
+
+			data.inService = true
+			sm.Dispatch(fsm.NewEvent("evVend", nil))
+			Expect(sm.CurrentState().Name()).To(Equal("vending"))
+			Expect(recorder.failures).To(HaveLen(3))
+
+			buf := bytes.Buffer{}
+			Expect(logger.Fprint(&buf)).To(Succeed())
+			Expect(buf.String()).NotTo(ContainSubstring("Grd"))
+		})
+		It("should not report guard failures for an event another transition accepts", func() {
+			data.credit = 100
+			sm.Dispatch(fsm.NewEvent("evVend", nil))
+			sm.Dispatch(fsm.NewEvent("evCancel", nil))
+			Expect(sm.CurrentState().Name()).To(Equal("idle"))
+			Expect(recorder.failures).To(BeEmpty())
+		})
+		It("should log guard failures when asked to", func() {
+			logger.Guards = true
+			data.doorOpen = true
+			sm.Dispatch(fsm.NewEvent("evVend", nil))
+
+			buf := bytes.Buffer{}
+			Expect(logger.Fprint(&buf)).To(Succeed())
+			Expect(buf.String()).To(ContainSubstring("Grd : idle --> vending [evVend] failed [hasCredit || isFree]"))
+		})
+	})
+})
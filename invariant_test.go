@@ -0,0 +1,93 @@
+package fsm_test
+
+import (
+	fsm "github.com/johngrange/gofsm"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type invariantRecorder struct {
+	fsm.StateCounter
+	violations []string
+}
+
+func (r *invariantRecorder) OnInvariantViolated(state fsm.State, label string, fsmData interface{}) {
+	r.violations = append(r.violations, state.Name()+": "+label)
+}
+
+var _ = Describe("State invariants", func() {
+	type meter struct {
+		paymentsCollected uint
+		ticketsIssued     uint
+	}
+
+	var (
+		data     *meter
+		sm       fsm.ImmediateFSM
+		recorder *invariantRecorder
+	)
+
+	BeforeEach(func() {
+		data = &meter{}
+		smb := fsm.NewFSMBuilder().SetData(data)
+		idle := smb.NewState("idle").Invariant(func(fsmData interface{}) bool {
+			m := fsmData.(*meter)
+			return m.ticketsIssued <= m.paymentsCollected
+		}, "ticketsIssued <= paymentsCollected")
+		printing := smb.NewState("printing").
+			Invariant(func(fsmData interface{}) bool { return fsmData.(*meter).paymentsCollected > 0 }, "paid").
+			OnEntry(func(state fsm.State, fsmData interface{}, dispatcher fsm.Dispatcher) {
+				fsmData.(*meter).ticketsIssued++
+			})
+		flashing := smb.NewState("flashing").Invariant(func(fsmData interface{}) bool { return false }, "never")
+		smb.GetInitialState().AddTransition(idle)
+		idle.AddTransition(flashing).SetEventTrigger("evFlash")
+		flashing.AddTransition(idle)
+		idle.AddTransition(printing).SetEventTrigger("evPrint")
+		printing.AddTransition(idle).SetEventTrigger("evDone")
+		idle.AddTransition(idle).SetEventTrigger("evPay").SetEffect(
+			func(ev fsm.Event, fsmData interface{}, dispatcher fsm.Dispatcher) {
+				fsmData.(*meter).paymentsCollected++
+			})
+		var err error
+		sm, err = smb.BuildImmediateFSM()
+		Expect(err).NotTo(HaveOccurred())
+		recorder = &invariantRecorder{StateCounter: *fsm.NewStateCounter()}
+		sm.AddTracer(recorder)
+		sm.Start()
+	})
+
+	It("should label states with their invariants", func() {
+		Expect(sm.CurrentState().InvariantLabels()).To(Equal([]string{"ticketsIssued <= paymentsCollected"}))
+	})
+	It("should not report invariants that hold", func() {
+		sm.Dispatch(fsm.NewEvent("evPay", nil))
+		sm.Dispatch(fsm.NewEvent("evPrint", nil))
+		sm.Dispatch(fsm.NewEvent("evDone", nil))
+		Expect(recorder.violations).To(BeEmpty())
+	})
+	It("should check invariants once after every step", func() {
+		sm.Dispatch(fsm.NewEvent("evPrint", nil))
+		Expect(recorder.violations).To(Equal([]string{"printing: paid"}))
+		sm.Dispatch(fsm.NewEvent("evUnknown", nil))
+		Expect(recorder.violations).To(HaveLen(2))
+		sm.Dispatch(fsm.NewEvent("evDone", nil))
+		Expect(recorder.violations[2:]).To(Equal([]string{"idle: ticketsIssued <= paymentsCollected"}))
+	})
+	It("should check invariants of states entered and left within a step", func() {
+		sm.Dispatch(fsm.NewEvent("evFlash", nil))
+		Expect(sm.CurrentState().Name()).To(Equal("idle"))
+		Expect(recorder.violations).To(Equal([]string{"flashing: never"}))
+	})
+	It("should check invariants after data is updated from outside", func() {
+		sm.WithData(func(d interface{}) { d.(*meter).ticketsIssued = 2 })
+		sm.Tick()
+		Expect(recorder.violations).To(Equal([]string{"idle: ticketsIssued <= paymentsCollected"}))
+	})
+	It("should log violations", func() {
+		logger := fsm.NewFSMLogger()
+		sm.AddTracer(logger)
+		sm.Dispatch(fsm.NewEvent("evPrint", nil))
+		Expect(logger.Entries[len(logger.Entries)-1].Message).To(Equal("Inv : printing violated [paid]"))
+	})
+})
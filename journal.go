@@ -0,0 +1,272 @@
+package fsm
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+)
+
+type JournalEntryKind string
+
+const (
+	JournalStart JournalEntryKind = "start"
+	JournalEvent JournalEntryKind = "event"
+	JournalTimer JournalEntryKind = "timer" // a step with no event that caused a transition
+)
+
+// JournalEntry is one line of a journal.  Payload holds the event data encoded by a PayloadCodec,
+// Data holds it decoded when the journal is read.
+type JournalEntry struct {
+	Index   int              `json:"index"`
+	Kind    JournalEntryKind `json:"kind"`
+	Time    time.Time        `json:"time"`
+	Event   string           `json:"event,omitempty"`
+	Labels  []string         `json:"labels,omitempty"`
+	Payload []byte           `json:"payload,omitempty"`
+	Data    interface{}      `json:"-"`
+}
+
+// PayloadCodec converts event payloads to and from bytes for the journal.
+type PayloadCodec interface {
+	Encode(eventName string, data interface{}) ([]byte, error)
+	Decode(eventName string, payload []byte) (interface{}, error)
+}
+
+// JSONPayloadCodec encodes payloads as JSON.  Payloads of events registered with Register are
+// decoded into the registered type, others are decoded into interface{}.
+type JSONPayloadCodec struct {
+	types map[string]reflect.Type
+}
+
+func NewJSONPayloadCodec() *JSONPayloadCodec {
+	return &JSONPayloadCodec{
+		types: make(map[string]reflect.Type),
+	}
+}
+
+// Register sets the payload type of the named event, taken from prototype.
+func (c *JSONPayloadCodec) Register(eventName string, prototype interface{}) *JSONPayloadCodec {
+	c.types[eventName] = reflect.TypeOf(prototype)
+	return c
+}
+
+func (c *JSONPayloadCodec) Encode(eventName string, data interface{}) ([]byte, error) {
+	if data == nil {
+		return nil, nil
+	}
+	return json.Marshal(data)
+}
+
+func (c *JSONPayloadCodec) Decode(eventName string, payload []byte) (interface{}, error) {
+	if len(payload) == 0 {
+		return nil, nil
+	}
+	t, ok := c.types[eventName]
+	if !ok {
+		var data interface{}
+		err := json.Unmarshal(payload, &data)
+		return data, err
+	}
+	ptr := reflect.New(t)
+	if err := json.Unmarshal(payload, ptr.Interface()); err != nil {
+		return nil, err
+	}
+	return ptr.Elem().Interface(), nil
+}
+
+// Journal is a Tracer that appends every event processed by a machine, and every timer or
+// guard driven step that caused a transition, to a writer as JSON lines.  A journal records a
+// single run of a machine, starting from Start, and can be replayed with Replay.
+type Journal struct {
+	mx           sync.Mutex
+	w            io.Writer
+	codec        PayloadCodec
+	nextIndex    int
+	pendingTimer *JournalEntry
+	err          error
+}
+
+func NewJournal(w io.Writer, codec PayloadCodec) *Journal {
+	return &Journal{
+		w:     w,
+		codec: codec,
+	}
+}
+
+// OpenJournalFile creates a journal file, truncating any journal of an earlier run.
+// The caller must Close the returned file once the machine has stopped.
+func OpenJournalFile(path string, codec PayloadCodec) (*Journal, *os.File, error) {
+	f, err := os.OpenFile(path, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewJournal(f, codec), f, nil
+}
+
+// Err returns the first error encountered writing the journal.
+func (j *Journal) Err() error {
+	j.mx.Lock()
+	defer j.mx.Unlock()
+	return j.err
+}
+
+func (j *Journal) write(entry JournalEntry) {
+	if j.err != nil {
+		return
+	}
+	entry.Index = j.nextIndex
+	line, err := json.Marshal(entry)
+	if err != nil {
+		j.err = err
+		return
+	}
+	line = append(line, '\n')
+	if _, err = j.w.Write(line); err != nil {
+		j.err = err
+		return
+	}
+	j.nextIndex++
+}
+
+func (j *Journal) OnStart(when time.Time) {
+	j.mx.Lock()
+	defer j.mx.Unlock()
+	j.pendingTimer = nil
+	if j.nextIndex > 0 && j.err == nil {
+		j.err = errors.New("journal already records a run, and cannot record a second start")
+	}
+	j.write(JournalEntry{Kind: JournalStart, Time: when})
+}
+
+func (j *Journal) OnEventStep(ev Event, when time.Time) {
+	j.mx.Lock()
+	defer j.mx.Unlock()
+	j.pendingTimer = nil
+	payload, err := j.codec.Encode(ev.Name(), ev.Data())
+	if err != nil {
+		if j.err == nil {
+			j.err = fmt.Errorf("encoding payload of event %s: %w", ev.Name(), err)
+		}
+		return
+	}
+	j.write(JournalEntry{
+		Kind:    JournalEvent,
+		Time:    when,
+		Event:   ev.Name(),
+		Labels:  ev.Labels(),
+		Payload: payload,
+	})
+}
+
+func (j *Journal) OnEvaluateStep(when time.Time) {
+	j.mx.Lock()
+	defer j.mx.Unlock()
+	// only recorded if the step causes a transition
+	j.pendingTimer = &JournalEntry{Kind: JournalTimer, Time: when}
+}
+
+func (j *Journal) OnTransition(ev Event, sourceState, targetState State, fsmData interface{}) {
+	j.mx.Lock()
+	defer j.mx.Unlock()
+	if j.pendingTimer != nil {
+		j.write(*j.pendingTimer)
+		j.pendingTimer = nil
+	}
+}
+
+func (j *Journal) OnEntry(state State, fsmData interface{})                   {}
+func (j *Journal) OnExit(state State, fsmData interface{})                    {}
+func (j *Journal) OnRejectedEvent(ev Event, state State, fsmData interface{}) {}
+
+// ReadJournal reads the entries of a journal, decoding event payloads with codec.
+func ReadJournal(r io.Reader, codec PayloadCodec) ([]JournalEntry, error) {
+	entries := []JournalEntry{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("journal line %d: %w", line, err)
+		}
+		if entry.Kind == JournalEvent {
+			data, err := codec.Decode(entry.Event, entry.Payload)
+			if err != nil {
+				return nil, fmt.Errorf("journal line %d: decoding payload of event %s: %w", line, entry.Event, err)
+			}
+			entry.Data = data
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+type discardDispatcher struct{}
+
+func (discardDispatcher) Dispatch(Event) {}
+func (discardDispatcher) DispatchAfter(time.Duration, Event, ...ScheduleOption) TimerID {
+	return 0
+}
+func (discardDispatcher) DispatchAt(time.Time, Event, ...ScheduleOption) TimerID {
+	return 0
+}
+func (discardDispatcher) Cancel(TimerID) bool {
+	return false
+}
+
+// Replay rebuilds a machine from a journal.  definition must return a new builder for the
+// machine, including fresh data, each time it is called.  Entries are replayed in order under a
+// fake clock, up to but not including the entry with index stopAt; pass a negative stopAt to
+// replay the whole journal.  Events dispatched by actions during replay are discarded, as the
+// journal already records them.
+//
+// The returned machine is left at the point the replay stopped, with the fake clock at the time of
+// the last replayed entry, and can be inspected with CurrentState and GetData.
+func Replay(definition func() StateMachineBuilder, journal []JournalEntry, stopAt int) (ImmediateFSM, error) {
+	if len(journal) == 0 {
+		return nil, errors.New("empty journal")
+	}
+	clock := NewFakeClock(journal[0].Time)
+	built, err := definition().SetClock(clock).BuildImmediateFSM()
+	if err != nil {
+		return nil, err
+	}
+	sm, ok := built.(*immediateFSMImpl)
+	if !ok {
+		return nil, fmt.Errorf("unsupported fsm implementation %T", built)
+	}
+	sm.dispatcher = discardDispatcher{}
+
+	started := false
+	for _, entry := range journal {
+		if stopAt >= 0 && entry.Index >= stopAt {
+			break
+		}
+		clock.Set(entry.Time)
+		switch entry.Kind {
+		case JournalStart:
+			if started {
+				return nil, fmt.Errorf("journal entry %d: second start", entry.Index)
+			}
+			started = true
+			sm.Start()
+		case JournalEvent:
+			sm.Dispatch(NewEvent(entry.Event, entry.Data, entry.Labels...))
+		case JournalTimer:
+			sm.Tick()
+		default:
+			return nil, fmt.Errorf("journal entry %d: unknown kind %q", entry.Index, entry.Kind)
+		}
+	}
+	return sm, nil
+}
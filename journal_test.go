@@ -0,0 +1,146 @@
+package fsm_test
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"time"
+
+	fsm "github.com/johngrange/gofsm"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Journal and replay", func() {
+	type counterData struct {
+		total  uint
+		resets int
+	}
+	type coin struct {
+		Value uint
+	}
+
+	definition := func() fsm.StateMachineBuilder {
+		smb := fsm.NewFSMBuilder().SetData(&counterData{})
+		idle := smb.NewState("idle")
+		counting := smb.NewState("counting")
+		full := smb.NewState("full")
+		smb.GetInitialState().AddTransition(idle)
+		idle.AddTransition(counting).SetEventTrigger("coin").SetEffect(func(ev fsm.Event, fsmData interface{}, dispatcher fsm.Dispatcher) {
+			fsmData.(*counterData).total += ev.Data().(coin).Value
+		})
+		counting.AddTransition(counting).SetEventTrigger("coin").SetEffect(func(ev fsm.Event, fsmData interface{}, dispatcher fsm.Dispatcher) {
+			d := fsmData.(*counterData)
+			d.total += ev.Data().(coin).Value
+			if d.total >= 100 {
+				dispatcher.Dispatch(fsm.NewEvent("full", nil))
+			}
+		})
+		counting.AddTransition(full).SetEventTrigger("full")
+		counting.AddTransition(idle).SetTimedTrigger(time.Minute).SetEffect(func(ev fsm.Event, fsmData interface{}, dispatcher fsm.Dispatcher) {
+			d := fsmData.(*counterData)
+			d.total = 0
+			d.resets++
+		})
+		return smb
+	}
+
+	var (
+		clock   *fsm.FakeClock
+		codec   *fsm.JSONPayloadCodec
+		buf     *bytes.Buffer
+		journal *fsm.Journal
+		sm      fsm.ImmediateFSM
+	)
+
+	BeforeEach(func() {
+		clock = fsm.NewFakeClock(time.Date(2022, 10, 1, 9, 0, 0, 0, time.UTC))
+		codec = fsm.NewJSONPayloadCodec().Register("coin", coin{})
+		buf = &bytes.Buffer{}
+		journal = fsm.NewJournal(buf, codec)
+		var err error
+		sm, err = definition().SetClock(clock).BuildImmediateFSM()
+		Expect(err).NotTo(HaveOccurred())
+		sm.AddTracer(journal)
+
+		sm.Start()
+		sm.Dispatch(fsm.NewEvent("coin", coin{20}))
+		clock.Advance(30 * time.Second)
+		sm.Tick() // no timer due, not journalled
+		clock.Advance(31 * time.Second)
+		sm.Tick() // times out back to idle
+		sm.Dispatch(fsm.NewEvent("coin", coin{50}))
+		sm.Dispatch(fsm.NewEvent("coin", coin{50})) // dispatches "full" internally
+		Expect(sm.CurrentState().Name()).To(Equal("full"))
+		Expect(journal.Err()).NotTo(HaveOccurred())
+	})
+
+	It("should record events, internal events and timer firings", func() {
+		entries, err := fsm.ReadJournal(buf, codec)
+		Expect(err).NotTo(HaveOccurred())
+		kinds := []fsm.JournalEntryKind{}
+		for idx, e := range entries {
+			Expect(e.Index).To(Equal(idx))
+			kinds = append(kinds, e.Kind)
+		}
+		Expect(kinds).To(Equal([]fsm.JournalEntryKind{
+			fsm.JournalStart, fsm.JournalEvent, fsm.JournalTimer, fsm.JournalEvent, fsm.JournalEvent, fsm.JournalEvent,
+		}))
+		Expect(entries[1].Data).To(Equal(coin{20}))
+		Expect(entries[2].Time).To(Equal(clock.Now()))
+		Expect(entries[5].Event).To(Equal("full"))
+	})
+	It("should replay to the same state and data", func() {
+		entries, err := fsm.ReadJournal(buf, codec)
+		Expect(err).NotTo(HaveOccurred())
+		replayed, err := fsm.Replay(definition, entries, -1)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(replayed.CurrentState().Name()).To(Equal("full"))
+		Expect(replayed.GetData()).To(Equal(sm.GetData()))
+	})
+	It("should stop replay at a journal index", func() {
+		entries, err := fsm.ReadJournal(buf, codec)
+		Expect(err).NotTo(HaveOccurred())
+		replayed, err := fsm.Replay(definition, entries, 2)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(replayed.CurrentState().Name()).To(Equal("counting"))
+		Expect(replayed.GetData()).To(Equal(&counterData{total: 20}))
+
+		replayed, err = fsm.Replay(definition, entries, 3)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(replayed.CurrentState().Name()).To(Equal("idle"))
+		Expect(replayed.GetData()).To(Equal(&counterData{resets: 1}))
+	})
+	It("should write journal files, replacing the journal of an earlier run", func() {
+		err := os.MkdirAll(testOutputDir, 0755)
+		Expect(err).NotTo(HaveOccurred())
+		file := path.Join(testOutputDir, "journal.jsonl")
+		_ = os.Remove(file)
+		for run := 0; run < 2; run++ {
+			fileJournal, f, err := fsm.OpenJournalFile(file, codec)
+			Expect(err).NotTo(HaveOccurred())
+			fileJournal.OnStart(clock.Now())
+			Expect(fileJournal.Err()).NotTo(HaveOccurred())
+			Expect(f.Close()).To(Succeed())
+		}
+		contents, err := os.ReadFile(file)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(contents)).To(Equal(`{"index":0,"kind":"start","time":"2022-10-01T09:01:01Z"}` + "\n"))
+		Expect(os.Remove(file)).To(Succeed())
+	})
+	It("should refuse to record or replay a second start", func() {
+		again := &bytes.Buffer{}
+		j := fsm.NewJournal(again, codec)
+		j.OnStart(clock.Now())
+		j.OnStart(clock.Now())
+		Expect(j.Err()).To(MatchError("journal already records a run, and cannot record a second start"))
+		Expect(again.String()).To(Equal(`{"index":0,"kind":"start","time":"2022-10-01T09:01:01Z"}` + "\n"))
+
+		entries := []fsm.JournalEntry{
+			{Index: 0, Kind: fsm.JournalStart, Time: clock.Now()},
+			{Index: 1, Kind: fsm.JournalStart, Time: clock.Now()},
+		}
+		_, err := fsm.Replay(definition, entries, -1)
+		Expect(err).To(MatchError("journal entry 1: second start"))
+	})
+})
@@ -0,0 +1,42 @@
+package fsm
+
+import (
+	"strings"
+	"time"
+)
+
+// transitionLabel is the label the renderers draw on a transition: its trigger, guards and
+// effects, e.g. "TurnOn [power==active]" or "TurnOff/perform effect".  Each renderer writes
+// timers in its own syntax, so after formats a timer trigger's duration; a nil after leaves
+// timers unlabelled.
+func transitionLabel(t Transition, after func(time.Duration) string) string {
+	return formatTransitionLabel(t, t.GuardLabels(), after)
+}
+
+// formatTransitionLabel is transitionLabel with the guard labels given by the caller.
+func formatTransitionLabel(t Transition, guards []string, after func(time.Duration) string) string {
+	label := ""
+	switch t.TriggerType() {
+	case EventTrigger:
+		label = t.EventName()
+	case TimerTrigger:
+		if after != nil {
+			label = after(t.TimerDuration())
+		}
+	}
+	for _, g := range guards {
+		label += " [" + g + "]"
+	}
+	if len(t.EffectLabels()) > 0 {
+		if len(guards) > 0 {
+			label += " "
+		}
+		label += "/" + strings.Join(t.EffectLabels(), " ")
+	}
+	return strings.TrimSpace(label)
+}
+
+// afterLabel writes a timer as "after 5s", as DOT and the PlantUML diff do.
+func afterLabel(d time.Duration) string {
+	return "after " + d.String()
+}
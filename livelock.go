@@ -0,0 +1,100 @@
+package fsm
+
+import (
+	"fmt"
+	"strings"
+)
+
+const DefaultMicrostepLimit = 1000
+
+// LivelockError describes eventless transitions that never settle.  Path is the cycle of
+// state names, starting and ending with the same state.
+type LivelockError struct {
+	Path  []string
+	Limit int // microstep limit exceeded, 0 for cycles found at build time
+}
+
+func (e *LivelockError) Error() string {
+	cycle := strings.Join(e.Path, " --> ")
+	if e.Limit == 0 {
+		return fmt.Sprintf("unconditional eventless transition cycle: %s", cycle)
+	}
+	return fmt.Sprintf("livelock: more than %d eventless transitions in one step, cycle: %s", e.Limit, cycle)
+}
+
+// findEventlessCycle returns the first cycle formed of unguarded transitions without triggers,
+// which will always be taken, or nil if there is none.
+func findEventlessCycle(states []State) []State {
+	const (
+		unvisited = iota
+		inProgress
+		done
+	)
+	marks := make(map[State]int)
+	var stack []State
+	var cycle []State
+
+	var visit func(s State) bool
+	visit = func(s State) bool {
+		marks[s] = inProgress
+		stack = append(stack, s)
+		for _, t := range s.Transitions() {
+			if t.TriggerType() != NoTrigger || t.IsGuarded() {
+				continue
+			}
+			next := t.Target()
+			switch marks[next] {
+			case inProgress:
+				for idx, stacked := range stack {
+					if stacked == next {
+						cycle = append(cycle, stack[idx:]...)
+						cycle = append(cycle, next)
+						return true
+					}
+				}
+			case unvisited:
+				if visit(next) {
+					return true
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		marks[s] = done
+		return false
+	}
+
+	for _, s := range states {
+		if marks[s] == unvisited && visit(s) {
+			return cycle
+		}
+	}
+	return nil
+}
+
+// cycleInPath returns the trailing cycle of a path of states visited by eventless transitions.
+func cycleInPath(path []State) []string {
+	names := []string{}
+	if len(path) == 0 {
+		return names
+	}
+	last := path[len(path)-1]
+	start := 0
+	for idx := len(path) - 2; idx >= 0; idx-- {
+		if path[idx] == last {
+			start = idx
+			break
+		}
+	}
+	for _, s := range path[start:] {
+		names = append(names, s.Name())
+	}
+	return names
+}
+
+func stateNames(states []State) []string {
+	names := make([]string, 0, len(states))
+	for _, s := range states {
+		names = append(names, s.Name())
+	}
+	return names
+}
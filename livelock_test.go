@@ -0,0 +1,129 @@
+package fsm_test
+
+import (
+	"errors"
+
+	fsm "github.com/johngrange/gofsm"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type errorRecorder struct {
+	fsm.StateCounter
+	errs []error
+}
+
+func (e *errorRecorder) OnError(err error, state fsm.State, fsmData interface{}) {
+	e.errs = append(e.errs, err)
+}
+
+var _ = Describe("Livelock detection", func() {
+	var (
+		smb                  fsm.StateMachineBuilder
+		ping, pong, errState fsm.StateBuilder
+		recorder             *errorRecorder
+	)
+	alwaysTrue := func(fsmData, eventData interface{}) bool { return true }
+
+	BeforeEach(func() {
+		smb = fsm.NewFSMBuilder()
+		ping = smb.NewState("ping")
+		pong = smb.NewState("pong")
+		errState = smb.NewState("error")
+		smb.GetInitialState().AddTransition(ping).SetEventTrigger("go")
+		recorder = &errorRecorder{StateCounter: *fsm.NewStateCounter()}
+		smb.AddTracer(recorder)
+	})
+
+	It("should refuse to build unconditional eventless cycles", func() {
+		ping.AddTransition(pong)
+		pong.AddTransition(ping)
+		_, err := smb.BuildImmediateFSM()
+		Expect(err).To(HaveOccurred())
+		var livelock *fsm.LivelockError
+		Expect(errors.As(err, &livelock)).To(BeTrue())
+		Expect(livelock.Path).To(Equal([]string{"ping", "pong", "ping"}))
+		Expect(err.Error()).To(Equal("unconditional eventless transition cycle: ping --> pong --> ping"))
+	})
+	It("should refuse to build unconditional eventless self transitions", func() {
+		ping.AddTransition(ping)
+		_, err := smb.BuildThreadedFSM()
+		Expect(err).To(MatchError("unconditional eventless transition cycle: ping --> ping"))
+	})
+	When("guards never settle", func() {
+		BeforeEach(func() {
+			ping.AddTransition(pong).SetGuard(alwaysTrue, "true")
+			pong.AddTransition(ping).SetGuard(alwaysTrue, "true")
+			smb.SetMicrostepLimit(100)
+		})
+		It("should halt the immediate fsm and report the cycle", func() {
+			sm, err := smb.BuildImmediateFSM()
+			Expect(err).NotTo(HaveOccurred())
+			sm.Start()
+			sm.Dispatch(fsm.NewEvent("go", nil))
+			Expect(recorder.errs).To(HaveLen(1))
+			var livelock *fsm.LivelockError
+			Expect(errors.As(recorder.errs[0], &livelock)).To(BeTrue())
+			Expect(livelock.Limit).To(Equal(100))
+			Expect(livelock.Path).To(HaveLen(3))
+			Expect(livelock.Path[0]).To(Equal(livelock.Path[2]))
+			Expect(livelock.Path).To(ContainElements("ping", "pong"))
+
+			// halted machine ignores further events
+			state := sm.CurrentState().Name()
+			sm.Dispatch(fsm.NewEvent("go", nil))
+			Expect(sm.CurrentState().Name()).To(Equal(state))
+			Expect(recorder.errs).To(HaveLen(1))
+		})
+		It("should move the immediate fsm to the error state if one is set", func() {
+			smb.SetErrorState(errState)
+			sm, err := smb.BuildImmediateFSM()
+			Expect(err).NotTo(HaveOccurred())
+			sm.Start()
+			sm.Dispatch(fsm.NewEvent("go", nil))
+			Expect(sm.CurrentState().Name()).To(Equal("error"))
+			Expect(recorder.StateCounts["error"]).To(BeNumerically("==", 1))
+		})
+		It("should add an error state that was not added to the machine", func() {
+			newBuilder := func() (fsm.StateMachineBuilder, *countingVisitor) {
+				smb := fsm.NewFSMBuilder()
+				ping := smb.NewState("ping")
+				pong := smb.NewState("pong")
+				smb.GetInitialState().AddTransition(ping).SetEventTrigger("go")
+				ping.AddTransition(pong).SetGuard(alwaysTrue, "true")
+				pong.AddTransition(ping).SetGuard(alwaysTrue, "true")
+				recovering := fsm.NewStateBuilder("recovering")
+				recovering.AddTransition(smb.AddFinalState()).SetEventTrigger("reset")
+				smb.SetMicrostepLimit(100).SetErrorState(recovering)
+				return smb, &countingVisitor{}
+			}
+			smb, counter := newBuilder()
+			Expect(smb.Validate()).To(BeEmpty())
+			sm, err := smb.BuildImmediateFSM()
+			Expect(err).NotTo(HaveOccurred())
+			sm.Visit(counter)
+			Expect(counter.stateCount).To(Equal(5))
+			sm.Start()
+			sm.Dispatch(fsm.NewEvent("go", nil))
+			Expect(sm.CurrentState().Name()).To(Equal("recovering"))
+
+			snapshot, err := sm.Snapshot()
+			Expect(err).NotTo(HaveOccurred())
+			smb, _ = newBuilder()
+			restored, err := smb.RestoreImmediateFSM(snapshot)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(restored.CurrentState().Name()).To(Equal("recovering"))
+			restored.Dispatch(fsm.NewEvent("reset", nil))
+			Expect(restored.CurrentState().Name()).To(Equal(fsm.FinalStateName))
+		})
+		It("should not hang the threaded fsm", func() {
+			smb.SetErrorState(errState)
+			sm, err := smb.BuildThreadedFSM()
+			Expect(err).NotTo(HaveOccurred())
+			sm.Start()
+			defer sm.Stop()
+			sm.Dispatch(fsm.NewEvent("go", nil))
+			Eventually(func() string { return sm.CurrentState().Name() }).Should(Equal("error"))
+		})
+	})
+})
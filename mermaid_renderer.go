@@ -0,0 +1,165 @@
+package fsm
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type mermaidOptions struct {
+	direction   string
+	fenced      bool
+	annotations []AnnotationOption
+}
+
+// MermaidOption changes how RenderMermaid draws a machine.
+type MermaidOption func(*mermaidOptions)
+
+// MermaidDirection sets the direction of the diagram: TB (the Mermaid default), LR, BT or RL.
+func MermaidDirection(dir string) MermaidOption {
+	return func(o *mermaidOptions) {
+		o.direction = dir
+	}
+}
+
+// MermaidFenced wraps the diagram in a ```mermaid code block, ready to paste into Markdown.
+func MermaidFenced() MermaidOption {
+	return func(o *mermaidOptions) {
+		o.fenced = true
+	}
+}
+
+// MermaidAnnotate draws the running machine as chosen by annotation options.  Mermaid state
+// diagrams cannot style single transitions, so transitions are labelled with how often they were
+// taken but not coloured.
+func MermaidAnnotate(opts ...AnnotationOption) MermaidOption {
+	return func(o *mermaidOptions) {
+		o.annotations = append(o.annotations, opts...)
+	}
+}
+
+var mermaidIdentifier = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// RenderMermaid writes a Mermaid stateDiagram-v2 of the machine.  States whose names are not
+// valid Mermaid identifiers are declared with a generated id and the name as their description.
+func RenderMermaid(w io.Writer, stateMachine FSM, opts ...MermaidOption) error {
+	options := mermaidOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	switch options.direction {
+	case "", "TB", "LR", "BT", "RL":
+	default:
+		return fmt.Errorf("invalid direction %q", options.direction)
+	}
+	visitor := definitionVisitor{}
+	stateMachine.Visit(&visitor)
+	annotations := NewAnnotations(stateMachine, options.annotations...)
+
+	lines := []string{}
+	if options.fenced {
+		lines = append(lines, "```mermaid")
+	}
+	lines = append(lines, "stateDiagram-v2")
+	if options.direction != "" {
+		lines = append(lines, "    direction "+options.direction)
+	}
+
+	names := make(map[string]bool)
+	for _, state := range visitor.states {
+		names[state.Name()] = true
+	}
+	ids := make(map[string]string)
+	generated := 0
+	for _, state := range visitor.states {
+		name := state.Name()
+		switch {
+		case isPseudoState(name):
+			ids[name] = InitialFinalStateSymbol
+		case mermaidIdentifier.MatchString(name) && !isMermaidKeyword(name):
+			ids[name] = name
+		default:
+			for ids[name] == "" || names[ids[name]] {
+				generated++
+				ids[name] = fmt.Sprintf("state%d", generated)
+			}
+			lines = append(lines, fmt.Sprintf("    state \"%s\" as %s", mermaidEscape(name), ids[name]))
+		}
+	}
+
+	for _, state := range visitor.states {
+		if isPseudoState(state.Name()) {
+			continue
+		}
+		notes := append([]string{}, state.StateLabels()...)
+		for _, l := range state.EntryLabels() {
+			notes = append(notes, "entry/"+l)
+		}
+		for _, l := range state.ExitLabels() {
+			notes = append(notes, "exit/"+l)
+		}
+		notes = append(notes, annotations.Notes(state)...)
+		if len(notes) == 0 {
+			continue
+		}
+		lines = append(lines, "    note right of "+ids[state.Name()])
+		for _, n := range notes {
+			lines = append(lines, "        "+mermaidEscape(n))
+		}
+		lines = append(lines, "    end note")
+	}
+
+	for _, t := range visitor.transitions {
+		line := fmt.Sprintf("    %s --> %s", ids[t.Source().Name()], ids[t.Target().Name()])
+		label := transitionLabel(t, mermaidAfter)
+		if taken, ok := annotations.Taken(t); ok {
+			label = strings.TrimSpace(fmt.Sprintf("%s (%d)", label, taken))
+		}
+		if label != "" {
+			line += " : " + mermaidEscape(label)
+		}
+		lines = append(lines, line)
+	}
+	for _, state := range visitor.states {
+		if annotations.IsCurrent(state) && !isPseudoState(state.Name()) {
+			lines = append(lines,
+				"    classDef current fill:gold,font-weight:bold",
+				"    class "+ids[state.Name()]+" current")
+		}
+	}
+	if options.fenced {
+		lines = append(lines, "```")
+	}
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isMermaidKeyword(name string) bool {
+	switch name {
+	case "state", "note", "end", "direction", "classDef", "class", "stateDiagram", "as":
+		return true
+	}
+	return false
+}
+
+// mermaidAfter writes a timer as "after(5s)".
+func mermaidAfter(d time.Duration) string {
+	return "after(" + d.String() + ")"
+}
+
+// mermaidEscape replaces characters that end a Mermaid statement or description with entity
+// codes.
+func mermaidEscape(s string) string {
+	return strings.NewReplacer(
+		`"`, "#quot;",
+		";", "#59;",
+		"\n", " ",
+	).Replace(s)
+}
@@ -0,0 +1,109 @@
+package fsm_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	fsm "github.com/johngrange/gofsm"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Mermaid Rendering", func() {
+	type fsmData struct {
+	}
+
+	var (
+		stateMachine fsm.FSM
+		buf          *bytes.Buffer
+		err          error
+	)
+
+	BeforeEach(func() {
+		stateMachineBuilder := fsm.NewFSMBuilder().SetData(&fsmData{})
+		init := stateMachineBuilder.GetInitialState()
+
+		startingState := fsm.NewStateBuilder("starting")
+		startingState.OnEntry(func(state fsm.State, fsmData interface{}, dispatcher fsm.Dispatcher) {}, "initialise system")
+
+		onState := fsm.NewStateBuilder("on", "the on state")
+		offState := fsm.NewStateBuilder("off")
+		dimState := fsm.NewStateBuilder("low-power mode")
+		endState := fsm.NewStateBuilder("end")
+
+		init.AddTransition(startingState)
+		startingState.AddTransition(offState)
+
+		offState.AddTransition(onState).SetEventTrigger("TurnOn").SetGuard(func(fsmData, eventData interface{}) bool { return true }, "power==active")
+		onState.AddTransition(offState).SetEventTrigger("TurnOff").SetEffect(func(ev fsm.Event, fsmData interface{}, dispatcher fsm.Dispatcher) {}, "perform effect")
+		onState.AddTransition(dimState).SetTimedTrigger(5 * time.Second)
+		dimState.AddTransition(onState).SetEventTrigger("Touch")
+		dimState.AddTransition(endState).SetEventTrigger("Unplug")
+
+		onState.AddTransition(stateMachineBuilder.AddFinalState()).SetEventTrigger("FatalError").SetEffect(func(ev fsm.Event, fsmData interface{}, dispatcher fsm.Dispatcher) {}, "panic!")
+		onState.OnExit(func(state fsm.State, fsmData interface{}, dispatcher fsm.Dispatcher) {}, "turn out lights")
+
+		stateMachineBuilder.
+			AddState(startingState).
+			AddState(onState).
+			AddState(offState).
+			AddState(dimState).
+			AddState(endState)
+
+		stateMachine, err = stateMachineBuilder.BuildThreadedFSM()
+		Expect(err).NotTo(HaveOccurred())
+		buf = &bytes.Buffer{}
+	})
+
+	When("rendering mermaid", func() {
+		It("should get the output right!", func() {
+			err = fsm.RenderMermaid(buf, stateMachine)
+			Expect(err).NotTo(HaveOccurred())
+
+			expectedMermaid :=
+				`stateDiagram-v2
+    state "low-power mode" as state1
+    state "end" as state2
+    note right of starting
+        entry/initialise system
+    end note
+    note right of on
+        the on state
+        exit/turn out lights
+    end note
+    [*] --> starting
+    starting --> off
+    on --> off : TurnOff/perform effect
+    on --> state1 : after(5s)
+    on --> [*] : FatalError/panic!
+    off --> on : TurnOn [power==active]
+    state1 --> on : Touch
+    state1 --> state2 : Unplug
+`
+			fmt.Fprintf(GinkgoWriter, "%s\n", buf.String())
+			Expect(buf.String()).To(Equal(expectedMermaid))
+			err = os.MkdirAll(testOutputDir, 0755)
+			Expect(err).NotTo(HaveOccurred())
+			err = os.WriteFile(path.Join(testOutputDir, "testone.mmd"), buf.Bytes(), 0600)
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	When("rendering mermaid with options", func() {
+		It("should set the direction and fence the diagram for Markdown", func() {
+			err = fsm.RenderMermaid(buf, stateMachine, fsm.MermaidDirection("LR"), fsm.MermaidFenced())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(buf.String()).To(HavePrefix("```mermaid\nstateDiagram-v2\n    direction LR\n"))
+			Expect(buf.String()).To(HaveSuffix("    state1 --> state2 : Unplug\n```\n"))
+		})
+
+		It("should reject an unknown direction", func() {
+			err = fsm.RenderMermaid(buf, stateMachine, fsm.MermaidDirection("sideways"))
+			Expect(err).To(HaveOccurred())
+			Expect(buf.Len()).To(Equal(0))
+		})
+	})
+})
@@ -7,10 +7,14 @@ import (
 
 const InitialFinalStateSymbol = "[*]"
 
-func RenderPlantUML(w io.Writer, stateMachine FSM) error {
+// RenderPlantUML writes a PlantUML state diagram of the machine.  Annotation options draw the
+// machine as it is running: the current state filled in, transitions coloured and thickened by how
+// often they were taken, and states noted with their entries and time spent in them.
+func RenderPlantUML(w io.Writer, stateMachine FSM, opts ...AnnotationOption) error {
 	visitor := plantUMLVisitor{
-		w:    w,
-		errs: []error{},
+		w:           w,
+		errs:        []error{},
+		annotations: NewAnnotations(stateMachine, opts...),
 	}
 	_, err := fmt.Fprintln(w, "@startuml")
 	if err != nil {
@@ -28,8 +32,9 @@ func RenderPlantUML(w io.Writer, stateMachine FSM) error {
 }
 
 type plantUMLVisitor struct {
-	w    io.Writer
-	errs []error
+	w           io.Writer
+	errs        []error
+	annotations *Annotations
 }
 
 func (p *plantUMLVisitor) VisitState(state State) {
@@ -38,6 +43,9 @@ func (p *plantUMLVisitor) VisitState(state State) {
 		stateName = InitialFinalStateSymbol
 	}
 
+	if p.annotations.IsCurrent(state) && !isPseudoState(state.Name()) {
+		fmt.Fprintf(p.w, "state %s #gold\n", stateName)
+	}
 	for _, l := range state.StateLabels() {
 		fmt.Fprintf(p.w, "%s : %s\n", stateName, l)
 	}
@@ -47,28 +55,18 @@ func (p *plantUMLVisitor) VisitState(state State) {
 	for _, l := range state.ExitLabels() {
 		fmt.Fprintf(p.w, "%s : exit/%s\n", stateName, l)
 	}
+	for _, l := range p.annotations.Notes(state) {
+		fmt.Fprintf(p.w, "%s : %s\n", stateName, l)
+	}
 }
 func (p *plantUMLVisitor) VisitTransition(t Transition) {
-	evName := t.EventName()
-	if evName != "" {
-		evName = " : " + evName
-	}
-	guard := ""
-	if len(t.GuardLabels()) > 0 {
-		guard = " "
-		for _, l := range t.GuardLabels() {
-			guard += fmt.Sprintf("[%s] ", l)
-		}
+	// PlantUML diagrams leave timers unlabelled, and end a guard without effects with a space
+	label := transitionLabel(t, nil)
+	if len(t.GuardLabels()) > 0 && len(t.EffectLabels()) == 0 {
+		label += " "
 	}
-	effect := ""
-	if len(t.EffectLabels()) > 0 {
-		effect = "/"
-		for idx, l := range t.EffectLabels() {
-			effect += l
-			if idx < len(t.EffectLabels())-1 {
-				effect += " "
-			}
-		}
+	if label != "" {
+		label = " : " + label
 	}
 
 	sourceName := t.Source().Name()
@@ -79,7 +77,16 @@ func (p *plantUMLVisitor) VisitTransition(t Transition) {
 	if targetName == FinalStateName {
 		targetName = InitialFinalStateSymbol
 	}
-	_, err := fmt.Fprintf(p.w, "%s --> %s%s%s%s\n", sourceName, targetName, evName, guard, effect)
+	arrow := "-->"
+	count := ""
+	if taken, ok := p.annotations.Taken(t); ok {
+		arrow = fmt.Sprintf("-[%s,thickness=%d]->", p.annotations.heatColour(t), p.annotations.heatWidth(t))
+		count = fmt.Sprintf(" (%d)", taken)
+		if label == "" {
+			count = " :" + count
+		}
+	}
+	_, err := fmt.Fprintf(p.w, "%s %s %s%s%s\n", sourceName, arrow, targetName, label, count)
 	if err != nil {
 		p.errs = append(p.errs, err)
 	}
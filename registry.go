@@ -0,0 +1,71 @@
+package fsm
+
+// Registry binds names used in machine definitions read by ImportSCXML and LoadDefinition to Go
+// functions: guards, actions run on entry to and exit from states, and transition effects.
+type Registry struct {
+	guards  map[string]TransitionGuard
+	actions map[string]Action
+	effects map[string]TransitionEffect
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		guards:  make(map[string]TransitionGuard),
+		actions: make(map[string]Action),
+		effects: make(map[string]TransitionEffect),
+	}
+}
+
+// Guard registers a transition guard.  In SCXML it is named by a cond attribute.
+func (r *Registry) Guard(name string, guard TransitionGuard) *Registry {
+	r.guards[name] = guard
+	return r
+}
+
+// Action registers an entry or exit action.  In SCXML it is named by a <script> in <onentry> or
+// <onexit>.
+func (r *Registry) Action(name string, action Action) *Registry {
+	r.actions[name] = action
+	return r
+}
+
+// Effect registers a transition effect.  In SCXML it is named by a <script> in a <transition>.
+func (r *Registry) Effect(name string, effect TransitionEffect) *Registry {
+	r.effects[name] = effect
+	return r
+}
+
+// sequenceActions returns an action running each of actions in turn.
+func sequenceActions(actions []Action) Action {
+	return func(state State, fsmData interface{}, dispatcher Dispatcher) {
+		for _, action := range actions {
+			action(state, fsmData, dispatcher)
+		}
+	}
+}
+
+// allGuards returns a guard that is true if all of guards, registered under names, are true,
+// combining them with AllOf.
+func allGuards(names []string, guards []TransitionGuard) TransitionGuard {
+	if len(guards) == 1 {
+		return guards[0]
+	}
+	predicates := make([]Predicate, len(guards))
+	for idx, guard := range guards {
+		predicates[idx] = Guard(names[idx], guard)
+	}
+	all := AllOf(predicates...)
+	return func(fsmData, eventData interface{}) bool {
+		ok, _ := all.evaluate(fsmData, eventData)
+		return ok
+	}
+}
+
+// sequenceEffects returns an effect running each of effects in turn.
+func sequenceEffects(effects []TransitionEffect) TransitionEffect {
+	return func(ev Event, fsmData interface{}, dispatcher Dispatcher) {
+		for _, effect := range effects {
+			effect(ev, fsmData, dispatcher)
+		}
+	}
+}
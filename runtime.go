@@ -0,0 +1,491 @@
+package fsm
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// Runtime hosts many state machine instances on a fixed pool of worker
+// go routines.  Instances are sharded by ID so that all work for a given
+// instance runs on the same worker, keeping its events ordered.  Timed
+// transitions for every instance share a single timer.  The shared timer runs on
+// real time: an instance with its own Clock is woken when its deadline would be due
+// if its clock kept pace with real time, and its timers fire then only if its clock
+// has reached them.
+//
+// Unlike the threaded FSM, a Runtime does not poll guards for data changes:
+// call Evaluate after changing instance data outside of an action.
+type Runtime struct {
+	shards    []*runtimeShard
+	timers    *timerQueue
+	mx        sync.RWMutex
+	instances map[string]*runtimeInstance
+	stop      chan struct{}
+	wg        sync.WaitGroup
+}
+
+var ErrUnknownInstance = errors.New("unknown fsm instance")
+
+func NewRuntime(workers int) *Runtime {
+	if workers < 1 {
+		workers = 1
+	}
+	r := &Runtime{
+		shards:    make([]*runtimeShard, workers),
+		instances: make(map[string]*runtimeInstance),
+		stop:      make(chan struct{}),
+	}
+	r.timers = newTimerQueue(r.stop)
+	for i := range r.shards {
+		r.shards[i] = &runtimeShard{
+			queue:  make([]runtimeWork, 0),
+			signal: make(chan struct{}, 1),
+		}
+	}
+	r.wg.Add(len(r.shards) + 1)
+	for _, shard := range r.shards {
+		go func(s *runtimeShard) {
+			defer r.wg.Done()
+			s.run(r.stop)
+		}(shard)
+	}
+	go func() {
+		defer r.wg.Done()
+		r.timers.run()
+	}()
+	return r
+}
+
+// Spawn builds a new instance from the supplied builder, registers it under instanceID
+// and starts it.  Each instance needs its own builder, as builders can only be finalised once.
+func (r *Runtime) Spawn(instanceID string, smb StateMachineBuilder) error {
+	built, err := smb.BuildImmediateFSM()
+	if err != nil {
+		return err
+	}
+	base, ok := built.(*immediateFSMImpl)
+	if !ok {
+		return fmt.Errorf("unsupported fsm implementation %T", built)
+	}
+
+	inst, err := r.register(instanceID, base)
+	if err != nil {
+		return err
+	}
+	inst.shard.enqueue(runtimeWork{inst: inst, kind: workStart})
+	return nil
+}
+
+// Recover restores every instance in the store into the runtime, as RecoverImmediateFSMs.
+// Recovered instances keep checkpointing to the store.
+func (r *Runtime) Recover(store Store, definition func(instanceID string) StateMachineBuilder) error {
+	return recoverInstances(store, definition, func(instanceID string, smb StateMachineBuilder, s Snapshot) error {
+		restored, err := smb.RestoreImmediateFSM(s)
+		if err != nil {
+			return err
+		}
+		base, ok := restored.(*immediateFSMImpl)
+		if !ok {
+			return fmt.Errorf("unsupported fsm implementation %T", restored)
+		}
+		inst, err := r.register(instanceID, base)
+		if err != nil {
+			return err
+		}
+		inst.shard.enqueue(runtimeWork{inst: inst, kind: workResume})
+		return nil
+	})
+}
+
+func (r *Runtime) register(instanceID string, base *immediateFSMImpl) (*runtimeInstance, error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if _, exists := r.instances[instanceID]; exists {
+		return nil, fmt.Errorf("fsm instance %q already exists", instanceID)
+	}
+	inst := &runtimeInstance{
+		id:     instanceID,
+		base:   base,
+		shard:  r.shardFor(instanceID),
+		timers: r.timers,
+	}
+	base.dispatcher = inst
+	base.houseKeepStateEntry = inst.scheduleTimers
+	base.houseKeepStateExit = inst.cancelTimers
+	base.houseKeepScheduled = func(due time.Time) {
+		inst.timers.add(runtimeTimer{deadline: inst.wakeAt(due), inst: inst, evaluate: true})
+	}
+	r.instances[instanceID] = inst
+	return inst, nil
+}
+
+// Dispatch queues an event for the named instance.
+func (r *Runtime) Dispatch(instanceID string, ev Event) error {
+	inst, err := r.lookup(instanceID)
+	if err != nil {
+		return err
+	}
+	inst.Dispatch(ev)
+	return nil
+}
+
+// Evaluate queues a re-evaluation of eventless and timed transitions for the named instance.
+func (r *Runtime) Evaluate(instanceID string) error {
+	inst, err := r.lookup(instanceID)
+	if err != nil {
+		return err
+	}
+	inst.shard.enqueue(runtimeWork{inst: inst, kind: workEvaluate})
+	return nil
+}
+
+func (r *Runtime) CurrentState(instanceID string) (State, error) {
+	inst, err := r.lookup(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	inst.mx.RLock()
+	defer inst.mx.RUnlock()
+	return inst.base.currentState, nil
+}
+
+// Instance returns the FSM for the named instance.  Dispatching to it is
+// equivalent to calling Runtime.Dispatch.
+func (r *Runtime) Instance(instanceID string) (FSM, error) {
+	inst, err := r.lookup(instanceID)
+	if err != nil {
+		return nil, err
+	}
+	return inst, nil
+}
+
+// Remove stops the named instance and forgets it.
+func (r *Runtime) Remove(instanceID string) error {
+	r.mx.Lock()
+	inst, ok := r.instances[instanceID]
+	delete(r.instances, instanceID)
+	r.mx.Unlock()
+	if !ok {
+		return ErrUnknownInstance
+	}
+	inst.shard.enqueue(runtimeWork{inst: inst, kind: workStop})
+	return nil
+}
+
+func (r *Runtime) Len() int {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+	return len(r.instances)
+}
+
+// Stop halts all workers and the shared timer.  Queued work is discarded.
+func (r *Runtime) Stop() {
+	close(r.stop)
+	r.wg.Wait()
+}
+
+func (r *Runtime) lookup(instanceID string) (*runtimeInstance, error) {
+	r.mx.RLock()
+	inst, ok := r.instances[instanceID]
+	r.mx.RUnlock()
+	if !ok {
+		return nil, ErrUnknownInstance
+	}
+	return inst, nil
+}
+
+func (r *Runtime) shardFor(instanceID string) *runtimeShard {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(instanceID))
+	return r.shards[h.Sum32()%uint32(len(r.shards))]
+}
+
+type runtimeWorkKind uint8
+
+const (
+	workEvent runtimeWorkKind = iota
+	workEvaluate
+	workTimer
+	workStart
+	workResume
+	workStop
+)
+
+type runtimeWork struct {
+	inst  *runtimeInstance
+	kind  runtimeWorkKind
+	ev    Event
+	epoch uint64 // for workTimer, the timer epoch the work was scheduled in
+}
+
+// runtimeShard is an unbounded work queue serviced by one worker.  It is
+// unbounded so that actions dispatching to their own instance, which run on the
+// worker, can never block it.
+type runtimeShard struct {
+	mx     sync.Mutex
+	queue  []runtimeWork
+	signal chan struct{}
+}
+
+func (s *runtimeShard) enqueue(w runtimeWork) {
+	s.mx.Lock()
+	s.queue = append(s.queue, w)
+	s.mx.Unlock()
+	select {
+	case s.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (s *runtimeShard) run(stop chan struct{}) {
+	var work []runtimeWork
+	for {
+		select {
+		case <-stop:
+			return
+		case <-s.signal:
+		}
+		s.mx.Lock()
+		work, s.queue = s.queue, work[:0]
+		s.mx.Unlock()
+		for _, w := range work {
+			w.inst.process(w)
+		}
+	}
+}
+
+type runtimeInstance struct {
+	id     string
+	base   *immediateFSMImpl
+	shard  *runtimeShard
+	timers *timerQueue
+	mx     sync.RWMutex // held while the worker is running the instance
+	epoch  uint64       // incremented on state exit, invalidating pending timers
+}
+
+func (i *runtimeInstance) process(w runtimeWork) {
+	i.mx.Lock()
+	defer i.mx.Unlock()
+	switch w.kind {
+	case workStart:
+		i.base.Start()
+	case workResume:
+		// restored instance, already running
+		i.scheduleTimers()
+		i.base.rearmScheduled()
+	case workStop:
+		i.cancelTimers()
+		i.base.Stop()
+	case workEvent:
+		if i.base.running {
+			i.base.processEvent(w.ev)
+		}
+	case workTimer:
+		if w.epoch != i.epoch {
+			// state has been left since the timer was scheduled
+			return
+		}
+		i.base.Tick()
+	case workEvaluate:
+		i.base.Tick()
+	}
+}
+
+func (i *runtimeInstance) scheduleTimers() {
+	timeNow := i.base.clock.Now()
+	for _, transition := range i.base.currentState.Transitions() {
+		if transition.TriggerType() == TimerTrigger {
+			deadline := timeNow.Add(transition.TimerDuration())
+			if d, ok := transition.deadline(); ok {
+				deadline = d
+			}
+			i.timers.add(runtimeTimer{
+				deadline: i.wakeAt(deadline),
+				inst:     i,
+				epoch:    i.epoch,
+			})
+		}
+	}
+}
+
+// wakeAt converts a deadline on the instance's clock to the real time the shared timer wakes
+// the instance at.
+func (i *runtimeInstance) wakeAt(deadline time.Time) time.Time {
+	return time.Now().Add(deadline.Sub(i.base.clock.Now()))
+}
+
+func (i *runtimeInstance) cancelTimers() {
+	i.epoch++
+}
+
+// Snapshot captures the instance.  Events queued on the runtime for the instance are not included.
+func (i *runtimeInstance) Snapshot() (Snapshot, error) {
+	i.mx.Lock()
+	defer i.mx.Unlock()
+	return i.base.snapshot(nil)
+}
+
+func (i *runtimeInstance) Dispatch(ev Event) {
+	i.shard.enqueue(runtimeWork{inst: i, kind: workEvent, ev: ev})
+}
+
+func (i *runtimeInstance) DispatchAfter(delay time.Duration, ev Event, opts ...ScheduleOption) TimerID {
+	return i.base.DispatchAfter(delay, ev, opts...)
+}
+
+func (i *runtimeInstance) DispatchAt(t time.Time, ev Event, opts ...ScheduleOption) TimerID {
+	return i.base.DispatchAt(t, ev, opts...)
+}
+
+func (i *runtimeInstance) Cancel(id TimerID) bool {
+	return i.base.Cancel(id)
+}
+
+// reportError must be called from the instance's own actions, on its worker
+func (i *runtimeInstance) reportError(err error) {
+	i.base.reportError(err)
+}
+
+func (i *runtimeInstance) Start() {
+	// instances are started by Runtime.Spawn
+}
+
+func (i *runtimeInstance) Stop() {
+	i.shard.enqueue(runtimeWork{inst: i, kind: workStop})
+}
+
+func (i *runtimeInstance) CurrentState() State {
+	i.mx.RLock()
+	defer i.mx.RUnlock()
+	return i.base.currentState
+}
+
+func (i *runtimeInstance) AddTracer(t Tracer) {
+	i.mx.Lock()
+	i.base.AddTracer(t)
+	i.mx.Unlock()
+}
+
+func (i *runtimeInstance) Visit(v Visitor) {
+	i.mx.RLock()
+	defer i.mx.RUnlock()
+	i.base.Visit(v)
+}
+
+func (i *runtimeInstance) GetData() interface{} {
+	return i.base.fsmData
+}
+
+func (i *runtimeInstance) WithData(fn func(data interface{})) {
+	i.mx.Lock()
+	fn(i.base.fsmData)
+	if i.base.running && !i.base.evaluateOnUpdate {
+		i.base.checkpoint()
+	}
+	i.mx.Unlock()
+	if i.base.evaluateOnUpdate {
+		i.shard.enqueue(runtimeWork{inst: i, kind: workEvaluate})
+	}
+}
+
+func (i *runtimeInstance) ReadData(fn func(data interface{})) {
+	i.mx.RLock()
+	defer i.mx.RUnlock()
+	fn(i.base.fsmData)
+}
+
+func (i *runtimeInstance) GetDispatcher() Dispatcher {
+	return i
+}
+
+type runtimeTimer struct {
+	deadline time.Time
+	inst     *runtimeInstance
+	epoch    uint64
+	evaluate bool // scheduled event, evaluate regardless of epoch
+}
+
+type timerHeap []runtimeTimer
+
+func (h timerHeap) Len() int            { return len(h) }
+func (h timerHeap) Less(i, j int) bool  { return h[i].deadline.Before(h[j].deadline) }
+func (h timerHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *timerHeap) Push(x interface{}) { *h = append(*h, x.(runtimeTimer)) }
+func (h *timerHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	t := old[n-1]
+	*h = old[:n-1]
+	return t
+}
+
+// timerQueue drives the timed transitions of every instance in a Runtime from a
+// single go routine and time.Timer.  Cancelled timers are not removed, they are
+// discarded by the instance when they fire in a stale epoch.
+type timerQueue struct {
+	mx     sync.Mutex
+	timers timerHeap
+	wake   chan struct{}
+	stop   chan struct{}
+}
+
+func newTimerQueue(stop chan struct{}) *timerQueue {
+	return &timerQueue{
+		timers: timerHeap{},
+		wake:   make(chan struct{}, 1),
+		stop:   stop,
+	}
+}
+
+func (q *timerQueue) add(t runtimeTimer) {
+	q.mx.Lock()
+	heap.Push(&q.timers, t)
+	earliest := q.timers[0].inst == t.inst && q.timers[0].deadline.Equal(t.deadline)
+	q.mx.Unlock()
+	if earliest {
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (q *timerQueue) run() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		q.mx.Lock()
+		timeNow := time.Now()
+		for len(q.timers) > 0 && !q.timers[0].deadline.After(timeNow) {
+			t := heap.Pop(&q.timers).(runtimeTimer)
+			if t.evaluate {
+				t.inst.shard.enqueue(runtimeWork{inst: t.inst, kind: workEvaluate})
+			} else {
+				t.inst.shard.enqueue(runtimeWork{inst: t.inst, kind: workTimer, epoch: t.epoch})
+			}
+		}
+		wait := time.Hour
+		if len(q.timers) > 0 {
+			wait = q.timers[0].deadline.Sub(timeNow)
+		}
+		q.mx.Unlock()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+		select {
+		case <-q.stop:
+			return
+		case <-q.wake:
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package fsm_test
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	fsm "github.com/johngrange/gofsm"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func newSessionBuilder(timeout time.Duration) fsm.StateMachineBuilder {
+	smb := fsm.NewFSMBuilder()
+	idle := smb.NewState("idle")
+	active := smb.NewState("active")
+	expired := smb.NewState("expired")
+	smb.GetInitialState().AddTransition(idle)
+	idle.AddTransition(active).SetEventTrigger("login")
+	active.AddTransition(idle).SetEventTrigger("logout")
+	active.AddTransition(expired).SetTimedTrigger(timeout)
+	return smb
+}
+
+var _ = Describe("Runtime", func() {
+	var rt *fsm.Runtime
+
+	BeforeEach(func() {
+		rt = fsm.NewRuntime(4)
+	})
+	AfterEach(func() {
+		rt.Stop()
+	})
+
+	currStateName := func(id string) func() string {
+		return func() string {
+			s, err := rt.CurrentState(id)
+			if err != nil {
+				return err.Error()
+			}
+			return s.Name()
+		}
+	}
+
+	It("should start spawned instances", func() {
+		Expect(rt.Spawn("a", newSessionBuilder(time.Hour))).To(Succeed())
+		Eventually(currStateName("a")).Should(Equal("idle"))
+		Expect(rt.Len()).To(Equal(1))
+	})
+	It("should reject duplicate and unknown instance ids", func() {
+		Expect(rt.Spawn("a", newSessionBuilder(time.Hour))).To(Succeed())
+		Expect(rt.Spawn("a", newSessionBuilder(time.Hour))).NotTo(Succeed())
+		Expect(rt.Dispatch("b", fsm.NewEvent("login", nil))).To(MatchError(fsm.ErrUnknownInstance))
+		Expect(rt.Remove("b")).To(MatchError(fsm.ErrUnknownInstance))
+	})
+	It("should address events to individual instances", func() {
+		for i := 0; i < 20; i++ {
+			Expect(rt.Spawn(fmt.Sprint(i), newSessionBuilder(time.Hour))).To(Succeed())
+		}
+		Expect(rt.Dispatch("7", fsm.NewEvent("login", nil))).To(Succeed())
+		Eventually(currStateName("7")).Should(Equal("active"))
+		for i := 0; i < 20; i++ {
+			if i != 7 {
+				Consistently(currStateName(fmt.Sprint(i)), "20ms").Should(Equal("idle"))
+			}
+		}
+	})
+	It("should keep events for an instance in order", func() {
+		smb := newSessionBuilder(time.Hour)
+		counter := fsm.NewStateCounter()
+		smb.AddTracer(counter)
+		Expect(rt.Spawn("a", smb)).To(Succeed())
+		for i := 0; i < 50; i++ {
+			Expect(rt.Dispatch("a", fsm.NewEvent("login", nil))).To(Succeed())
+			Expect(rt.Dispatch("a", fsm.NewEvent("logout", nil))).To(Succeed())
+		}
+		Expect(rt.Dispatch("a", fsm.NewEvent("login", nil))).To(Succeed())
+		Eventually(currStateName("a")).Should(Equal("active"))
+		Expect(counter.StateCounts["active"]).To(BeNumerically("==", 51))
+		Expect(counter.RejectedEventCounts).To(BeEmpty())
+	})
+	It("should fire timed transitions from the shared timer", func() {
+		Expect(rt.Spawn("a", newSessionBuilder(50*time.Millisecond))).To(Succeed())
+		Expect(rt.Spawn("b", newSessionBuilder(50*time.Millisecond))).To(Succeed())
+		Expect(rt.Dispatch("a", fsm.NewEvent("login", nil))).To(Succeed())
+		Expect(rt.Dispatch("b", fsm.NewEvent("login", nil))).To(Succeed())
+		Eventually(currStateName("a")).Should(Equal("active"))
+		Eventually(currStateName("a"), "200ms").Should(Equal("expired"))
+		Eventually(currStateName("b"), "200ms").Should(Equal("expired"))
+	})
+	It("should fire timed transitions on the instance's own clock", func() {
+		clock := fsm.NewFakeClock(time.Now().Add(24 * time.Hour))
+		Expect(rt.Spawn("a", newSessionBuilder(200*time.Millisecond).SetClock(clock))).To(Succeed())
+		Expect(rt.Dispatch("a", fsm.NewEvent("login", nil))).To(Succeed())
+		Eventually(currStateName("a")).Should(Equal("active"))
+		clock.Advance(time.Minute)
+		Eventually(currStateName("a"), "1s").Should(Equal("expired"))
+	})
+	It("should cancel timers when their state is exited", func() {
+		Expect(rt.Spawn("a", newSessionBuilder(50*time.Millisecond))).To(Succeed())
+		Expect(rt.Dispatch("a", fsm.NewEvent("login", nil))).To(Succeed())
+		Expect(rt.Dispatch("a", fsm.NewEvent("logout", nil))).To(Succeed())
+		Eventually(currStateName("a")).Should(Equal("idle"))
+		Consistently(currStateName("a"), "150ms").Should(Equal("idle"))
+	})
+	It("should route actions' dispatches back to their own instance", func() {
+		smb := fsm.NewFSMBuilder()
+		kicker := smb.NewState("kicker")
+		done := smb.NewState("done")
+		smb.GetInitialState().AddTransition(kicker)
+		kicker.OnEntry(func(state fsm.State, fsmData interface{}, dispatcher fsm.Dispatcher) {
+			dispatcher.Dispatch(fsm.NewEvent("done", nil))
+		})
+		kicker.AddTransition(done).SetEventTrigger("done")
+		Expect(rt.Spawn("a", smb)).To(Succeed())
+		Eventually(currStateName("a")).Should(Equal("done"))
+	})
+	It("should forget removed instances", func() {
+		Expect(rt.Spawn("a", newSessionBuilder(time.Hour))).To(Succeed())
+		Expect(rt.Remove("a")).To(Succeed())
+		Expect(rt.Len()).To(Equal(0))
+		Expect(rt.Dispatch("a", fsm.NewEvent("login", nil))).To(MatchError(fsm.ErrUnknownInstance))
+	})
+})
+
+const benchInstances = 10000
+
+func heapInUse() uint64 {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapInuse
+}
+
+func waitForState(current func() fsm.State, name string) {
+	for current().Name() != name {
+		runtime.Gosched()
+	}
+}
+
+func BenchmarkThreadedFSMMemory(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		before, goroutines := heapInUse(), runtime.NumGoroutine()
+		machines := make([]fsm.FSM, benchInstances)
+		for i := range machines {
+			machines[i], _ = newSessionBuilder(time.Hour).BuildThreadedFSM()
+			machines[i].Start()
+		}
+		b.ReportMetric((float64(heapInUse())-float64(before))/benchInstances, "heap-B/instance")
+		b.ReportMetric(float64(runtime.NumGoroutine()-goroutines)/benchInstances, "goroutines/instance")
+		for _, m := range machines {
+			m.Stop()
+		}
+	}
+}
+
+func BenchmarkRuntimeMemory(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		before, goroutines := heapInUse(), runtime.NumGoroutine()
+		rt := fsm.NewRuntime(runtime.GOMAXPROCS(0))
+		for i := 0; i < benchInstances; i++ {
+			_ = rt.Spawn(fmt.Sprint(i), newSessionBuilder(time.Hour))
+		}
+		b.ReportMetric((float64(heapInUse())-float64(before))/benchInstances, "heap-B/instance")
+		b.ReportMetric(float64(runtime.NumGoroutine()-goroutines)/benchInstances, "goroutines/instance")
+		rt.Stop()
+	}
+}
+
+func BenchmarkThreadedFSMLatency(b *testing.B) {
+	sm, _ := newSessionBuilder(time.Hour).BuildThreadedFSM()
+	sm.Start()
+	defer sm.Stop()
+	waitForState(sm.CurrentState, "idle")
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		sm.Dispatch(fsm.NewEvent("login", nil))
+		waitForState(sm.CurrentState, "active")
+		sm.Dispatch(fsm.NewEvent("logout", nil))
+		waitForState(sm.CurrentState, "idle")
+	}
+}
+
+func BenchmarkRuntimeLatency(b *testing.B) {
+	rt := fsm.NewRuntime(runtime.GOMAXPROCS(0))
+	defer rt.Stop()
+	_ = rt.Spawn("bench", newSessionBuilder(time.Hour))
+	sm, _ := rt.Instance("bench")
+	waitForState(sm.CurrentState, "idle")
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		_ = rt.Dispatch("bench", fsm.NewEvent("login", nil))
+		waitForState(sm.CurrentState, "active")
+		_ = rt.Dispatch("bench", fsm.NewEvent("logout", nil))
+		waitForState(sm.CurrentState, "idle")
+	}
+}
@@ -0,0 +1,139 @@
+package fsm
+
+import (
+	"sort"
+	"time"
+)
+
+// TimerID identifies an event scheduled with DispatchAfter or DispatchAt.
+type TimerID uint64
+
+type ScheduleOption func(*scheduledEvent)
+
+// CancelOnExit cancels a scheduled event if the machine leaves the state that was current when
+// the event was scheduled.  Note that during a transition effect that is still the source state.
+func CancelOnExit() ScheduleOption {
+	return func(se *scheduledEvent) {
+		se.cancelOnExit = true
+	}
+}
+
+type scheduledEvent struct {
+	id           TimerID
+	due          time.Time
+	ev           Event
+	cancelOnExit bool
+	state        State
+}
+
+func (f *immediateFSMImpl) DispatchAfter(delay time.Duration, ev Event, opts ...ScheduleOption) TimerID {
+	return f.DispatchAt(f.clock.Now().Add(delay), ev, opts...)
+}
+
+func (f *immediateFSMImpl) DispatchAt(t time.Time, ev Event, opts ...ScheduleOption) TimerID {
+	se := scheduledEvent{due: t, ev: ev}
+	for _, opt := range opts {
+		opt(&se)
+	}
+	f.scheduleMX.Lock()
+	if se.cancelOnExit {
+		se.state = f.currentState
+	}
+	f.nextTimerID++
+	se.id = f.nextTimerID
+	f.scheduled = append(f.scheduled, se)
+	f.scheduleMX.Unlock()
+	f.houseKeepScheduled(t)
+	return se.id
+}
+
+// Cancel stops a scheduled event from being dispatched.  It returns false if the event has
+// already been dispatched or cancelled.
+func (f *immediateFSMImpl) Cancel(id TimerID) bool {
+	f.scheduleMX.Lock()
+	defer f.scheduleMX.Unlock()
+	for idx, se := range f.scheduled {
+		if se.id == id {
+			f.scheduled = append(f.scheduled[:idx], f.scheduled[idx+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// setCurrentState changes the current state under scheduleMX, as DispatchAt may read it from
+// outside the machine's go routine.
+func (f *immediateFSMImpl) setCurrentState(state State) {
+	f.scheduleMX.Lock()
+	f.currentState = state
+	f.scheduleMX.Unlock()
+}
+
+func (f *immediateFSMImpl) cancelScheduledOnExit(state State) {
+	f.scheduleMX.Lock()
+	defer f.scheduleMX.Unlock()
+	kept := f.scheduled[:0]
+	for _, se := range f.scheduled {
+		if !(se.cancelOnExit && se.state == state) {
+			kept = append(kept, se)
+		}
+	}
+	f.scheduled = kept
+}
+
+func (f *immediateFSMImpl) cancelAllScheduled() {
+	f.scheduleMX.Lock()
+	f.scheduled = nil
+	f.scheduleMX.Unlock()
+}
+
+// rearmScheduled asks the machine's housekeeping to wake it for every scheduled event, as
+// after the events are restored from a snapshot.
+func (f *immediateFSMImpl) rearmScheduled() {
+	f.scheduleMX.Lock()
+	dues := make([]time.Time, 0, len(f.scheduled))
+	for _, se := range f.scheduled {
+		dues = append(dues, se.due)
+	}
+	f.scheduleMX.Unlock()
+	for _, due := range dues {
+		f.houseKeepScheduled(due)
+	}
+}
+
+// nextScheduled returns the due time of the earliest scheduled event.
+func (f *immediateFSMImpl) nextScheduled() (time.Time, bool) {
+	f.scheduleMX.Lock()
+	defer f.scheduleMX.Unlock()
+	var next time.Time
+	for idx, se := range f.scheduled {
+		if idx == 0 || se.due.Before(next) {
+			next = se.due
+		}
+	}
+	return next, len(f.scheduled) > 0
+}
+
+// dispatchDue dispatches scheduled events that have fallen due, in due order.
+func (f *immediateFSMImpl) dispatchDue() {
+	timeNow := f.clock.Now()
+	f.scheduleMX.Lock()
+	due := []scheduledEvent{}
+	kept := f.scheduled[:0]
+	for _, se := range f.scheduled {
+		if se.due.After(timeNow) {
+			kept = append(kept, se)
+		} else {
+			due = append(due, se)
+		}
+	}
+	f.scheduled = kept
+	f.scheduleMX.Unlock()
+
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].due.Before(due[j].due)
+	})
+	for _, se := range due {
+		f.Dispatch(se.ev)
+	}
+}
@@ -0,0 +1,171 @@
+package fsm_test
+
+import (
+	"time"
+
+	fsm "github.com/johngrange/gofsm"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Scheduled events", func() {
+	var (
+		smb     fsm.StateMachineBuilder
+		clock   *fsm.FakeClock
+		timerID fsm.TimerID
+		opts    []fsm.ScheduleOption
+	)
+	start := time.Date(2022, 10, 1, 9, 0, 0, 0, time.UTC)
+
+	definition := func() fsm.StateMachineBuilder {
+		smb := fsm.NewFSMBuilder().SetClock(clock)
+		waiting := smb.NewState("waiting")
+		retrying := smb.NewState("retrying")
+		off := smb.NewState("off")
+		smb.GetInitialState().AddTransition(waiting).SetEventTrigger("send")
+		waiting.OnEntry(func(state fsm.State, fsmData interface{}, dispatcher fsm.Dispatcher) {
+			timerID = dispatcher.(fsm.Scheduler).DispatchAfter(5*time.Second, fsm.NewEvent("evRetry", nil), opts...)
+		})
+		waiting.AddTransition(retrying).SetEventTrigger("evRetry")
+		waiting.AddTransition(off).SetEventTrigger("off")
+		off.AddTransition(retrying).SetEventTrigger("evRetry")
+		return smb
+	}
+
+	BeforeEach(func() {
+		opts = nil
+		clock = fsm.NewFakeClock(start)
+		smb = definition()
+	})
+
+	Context("immediate fsm", func() {
+		var sm fsm.ImmediateFSM
+
+		JustBeforeEach(func() {
+			var err error
+			sm, err = smb.BuildImmediateFSM()
+			Expect(err).NotTo(HaveOccurred())
+			sm.Start()
+			sm.Dispatch(fsm.NewEvent("send", nil))
+			Expect(sm.CurrentState().Name()).To(Equal("waiting"))
+		})
+
+		It("should dispatch the event during the first Tick after it is due", func() {
+			deadline, ok := sm.NextDeadline()
+			Expect(ok).To(BeTrue())
+			Expect(deadline).To(Equal(start.Add(5 * time.Second)))
+
+			clock.Advance(4 * time.Second)
+			sm.Tick()
+			Expect(sm.CurrentState().Name()).To(Equal("waiting"))
+			clock.Advance(time.Second)
+			Expect(sm.CurrentState().Name()).To(Equal("waiting"))
+			sm.Tick()
+			Expect(sm.CurrentState().Name()).To(Equal("retrying"))
+			_, ok = sm.NextDeadline()
+			Expect(ok).To(BeFalse())
+		})
+		It("should dispatch events scheduled at an absolute time", func() {
+			sm.DispatchAt(start.Add(time.Second), fsm.NewEvent("off", nil))
+			deadline, _ := sm.NextDeadline()
+			Expect(deadline).To(Equal(start.Add(time.Second)))
+			clock.Advance(time.Second)
+			sm.Tick()
+			Expect(sm.CurrentState().Name()).To(Equal("off"))
+		})
+		It("should not dispatch cancelled events", func() {
+			Expect(sm.Cancel(timerID)).To(BeTrue())
+			Expect(sm.Cancel(timerID)).To(BeFalse())
+			clock.Advance(time.Minute)
+			sm.Tick()
+			Expect(sm.CurrentState().Name()).To(Equal("waiting"))
+		})
+		It("should keep events scheduled by an exited state by default", func() {
+			sm.Dispatch(fsm.NewEvent("off", nil))
+			clock.Advance(5 * time.Second)
+			sm.Tick()
+			Expect(sm.CurrentState().Name()).To(Equal("retrying"))
+		})
+		It("should snapshot pending events and dispatch them once restored", func() {
+			snap, err := sm.Snapshot()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(snap.Scheduled).To(Equal([]fsm.ScheduledEventSnapshot{{
+				ID:    timerID,
+				Due:   start.Add(5 * time.Second),
+				Event: fsm.EventSnapshot{Name: "evRetry", Labels: []string{}},
+			}}))
+
+			restored, err := definition().RestoreImmediateFSM(snap)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(restored.DispatchAfter(time.Hour, fsm.NewEvent("off", nil))).To(BeNumerically(">", timerID))
+			deadline, ok := restored.NextDeadline()
+			Expect(ok).To(BeTrue())
+			Expect(deadline).To(Equal(start.Add(5 * time.Second)))
+			clock.Advance(5 * time.Second)
+			restored.Tick()
+			Expect(restored.CurrentState().Name()).To(Equal("retrying"))
+		})
+		It("should not dispatch events once stopped", func() {
+			sm.Stop()
+			_, ok := sm.NextDeadline()
+			Expect(ok).To(BeFalse())
+		})
+		When("scheduled with CancelOnExit", func() {
+			BeforeEach(func() {
+				opts = []fsm.ScheduleOption{fsm.CancelOnExit()}
+			})
+			It("should cancel the event when the scheduling state is exited", func() {
+				sm.Dispatch(fsm.NewEvent("off", nil))
+				Expect(sm.CurrentState().Name()).To(Equal("off"))
+				clock.Advance(5 * time.Second)
+				sm.Tick()
+				Expect(sm.CurrentState().Name()).To(Equal("off"))
+				Expect(sm.Cancel(timerID)).To(BeFalse())
+			})
+			It("should still cancel the event once restored", func() {
+				snap, err := sm.Snapshot()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(snap.Scheduled).To(HaveLen(1))
+				Expect(snap.Scheduled[0].State).To(Equal("waiting"))
+
+				restored, err := definition().RestoreImmediateFSM(snap)
+				Expect(err).NotTo(HaveOccurred())
+				restored.Dispatch(fsm.NewEvent("off", nil))
+				clock.Advance(5 * time.Second)
+				restored.Tick()
+				Expect(restored.CurrentState().Name()).To(Equal("off"))
+			})
+		})
+	})
+
+	Context("threaded fsm", func() {
+		It("should dispatch the event once due", func() {
+			sm, err := smb.SetClock(fsm.RealClock()).BuildThreadedFSM()
+			Expect(err).NotTo(HaveOccurred())
+			sm.Start()
+			defer sm.Stop()
+			sm.Dispatch(fsm.NewEvent("send", nil))
+			Eventually(func() string { return sm.CurrentState().Name() }).Should(Equal("waiting"))
+			Expect(sm.Cancel(timerID)).To(BeTrue())
+			sm.DispatchAfter(50*time.Millisecond, fsm.NewEvent("evRetry", nil))
+			Consistently(func() string { return sm.CurrentState().Name() }, 30*time.Millisecond).Should(Equal("waiting"))
+			Eventually(func() string { return sm.CurrentState().Name() }).Should(Equal("retrying"))
+		})
+		It("should dispatch restored events once due", func() {
+			sm, err := smb.BuildImmediateFSM()
+			Expect(err).NotTo(HaveOccurred())
+			sm.Start()
+			sm.Dispatch(fsm.NewEvent("send", nil))
+			clock.Advance(5*time.Second - 50*time.Millisecond)
+			snap, err := sm.Snapshot()
+			Expect(err).NotTo(HaveOccurred())
+
+			restored, err := definition().RestoreThreadedFSM(snap)
+			Expect(err).NotTo(HaveOccurred())
+			defer restored.Stop()
+			Expect(restored.CurrentState().Name()).To(Equal("waiting"))
+			clock.Advance(50 * time.Millisecond)
+			Eventually(func() string { return restored.CurrentState().Name() }).Should(Equal("retrying"))
+		})
+	})
+})
@@ -0,0 +1,167 @@
+package fsm
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const SCXMLNamespace = "http://www.w3.org/2005/07/scxml"
+
+type scxmlDocument struct {
+	XMLName xml.Name     `xml:"http://www.w3.org/2005/07/scxml scxml"`
+	Version string       `xml:"version,attr"`
+	Initial string       `xml:"initial,attr,omitempty"`
+	States  []scxmlState `xml:"state"`
+	Finals  []scxmlFinal `xml:"final"`
+}
+
+type scxmlState struct {
+	ID          string            `xml:"id,attr"`
+	Comment     string            `xml:",comment"` // state labels, one per line
+	OnEntry     *scxmlExecutable  `xml:"onentry"`
+	OnExit      *scxmlExecutable  `xml:"onexit"`
+	Transitions []scxmlTransition `xml:"transition"`
+}
+
+type scxmlFinal struct {
+	ID      string           `xml:"id,attr"`
+	Comment string           `xml:",comment"`
+	OnEntry *scxmlExecutable `xml:"onentry"`
+	OnExit  *scxmlExecutable `xml:"onexit"`
+}
+
+// scxmlExecutable is the content of onentry and onexit blocks.  Scripts hold the labels of
+// actions as placeholders, sends and cancels start and stop timers.
+type scxmlExecutable struct {
+	Scripts []string      `xml:"script"`
+	Sends   []scxmlSend   `xml:"send"`
+	Cancels []scxmlCancel `xml:"cancel"`
+}
+
+type scxmlSend struct {
+	Event string `xml:"event,attr"`
+	ID    string `xml:"id,attr"`
+	Delay string `xml:"delay,attr"`
+}
+
+type scxmlCancel struct {
+	SendID string `xml:"sendid,attr"`
+}
+
+type scxmlTransition struct {
+	Event   string   `xml:"event,attr,omitempty"`
+	Cond    string   `xml:"cond,attr,omitempty"`
+	Target  string   `xml:"target,attr"`
+	Scripts []string `xml:"script"`
+}
+
+// ExportSCXML writes the machine's definition as a W3C SCXML document.  Actions are not code, so
+// entry and exit actions and transition effects are written as <script> placeholders holding
+// their labels, and guards as cond attributes holding theirs.  Timed transitions become a
+// delayed <send> on entry to the source state, cancelled on exit, and a transition on the event
+// sent.  Invariants are not exported.
+//
+// A guard with several labels is written as them joined with " && ", which ImportSCXML reads
+// back as all of the guards registered under those names.  A guard without labels is written as
+// "guard", so such machines only import again if a guard is registered under that name.
+func ExportSCXML(w io.Writer, stateMachine FSM) error {
+	visitor := definitionVisitor{}
+	stateMachine.Visit(&visitor)
+
+	doc := scxmlDocument{Version: "1.0"}
+	transitions := make(map[string][]Transition)
+	for _, t := range visitor.transitions {
+		transitions[t.Source().Name()] = append(transitions[t.Source().Name()], t)
+	}
+	// a single plain transition from the initial state becomes the document's initial attribute,
+	// otherwise the initial state is exported like any other
+	initial := transitions[InitialStateName]
+	plainInitial := len(initial) == 1 && !initial[0].IsGuarded() && len(initial[0].EffectLabels()) == 0
+	if plainInitial {
+		doc.Initial = initial[0].Target().Name()
+	} else {
+		doc.Initial = InitialStateName
+	}
+
+	timers := 0
+	for _, state := range visitor.states {
+		if state.Name() == InitialStateName && plainInitial {
+			continue
+		}
+		onEntry := &scxmlExecutable{Scripts: state.EntryLabels()}
+		onExit := &scxmlExecutable{Scripts: state.ExitLabels()}
+		if state.Name() == FinalStateName {
+			doc.Finals = append(doc.Finals, scxmlFinal{
+				ID:      state.Name(),
+				Comment: scxmlComment(state.StateLabels()),
+				OnEntry: onEntry.orNil(),
+				OnExit:  onExit.orNil(),
+			})
+			continue
+		}
+		s := scxmlState{ID: state.Name(), Comment: scxmlComment(state.StateLabels())}
+		for _, t := range transitions[state.Name()] {
+			st := scxmlTransition{Target: t.Target().Name(), Scripts: t.EffectLabels()}
+			switch t.TriggerType() {
+			case EventTrigger:
+				st.Event = t.EventName()
+			case TimerTrigger:
+				timers++
+				send := scxmlSend{
+					Event: fmt.Sprintf("fsm.timer.%d", timers),
+					ID:    fmt.Sprintf("timer%d", timers),
+					Delay: scxmlDelay(t.TimerDuration()),
+				}
+				onEntry.Sends = append(onEntry.Sends, send)
+				onExit.Cancels = append(onExit.Cancels, scxmlCancel{SendID: send.ID})
+				st.Event = send.Event
+			}
+			if t.IsGuarded() {
+				st.Cond = "guard"
+				if len(t.GuardLabels()) > 0 {
+					st.Cond = strings.Join(t.GuardLabels(), " && ")
+				}
+			}
+			s.Transitions = append(s.Transitions, st)
+		}
+		s.OnEntry, s.OnExit = onEntry.orNil(), onExit.orNil()
+		doc.States = append(doc.States, s)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func (e *scxmlExecutable) orNil() *scxmlExecutable {
+	if len(e.Scripts) == 0 && len(e.Sends) == 0 && len(e.Cancels) == 0 {
+		return nil
+	}
+	return e
+}
+
+// scxmlComment returns labels as the text of an XML comment, which may not contain "--".
+func scxmlComment(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	return " " + strings.ReplaceAll(strings.Join(labels, "\n"), "--", "- -") + " "
+}
+
+// scxmlDelay formats d as a CSS2 time, as SCXML delays are written.
+func scxmlDelay(d time.Duration) string {
+	if d%time.Second == 0 {
+		return fmt.Sprintf("%ds", d/time.Second)
+	}
+	return fmt.Sprintf("%dms", d/time.Millisecond)
+}
@@ -0,0 +1,517 @@
+package fsm
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SCXMLError is a problem with an SCXML document that prevents it being imported.
+type SCXMLError struct {
+	Line    int
+	Message string
+}
+
+func (e *SCXMLError) Error() string {
+	return fmt.Sprintf("scxml line %d: %s", e.Line, e.Message)
+}
+
+// ImportSCXML reads a machine definition written in SCXML, as ExportSCXML writes them, and returns
+// a builder for it, binding behaviour by name from the registry.  Names are also used as labels.
+//
+// Only flat machines are supported: top level <state> elements, with <onentry>, <onexit> and
+// <transition> children, and at most one <final>, which becomes the machine's final state.  The
+// initial state is set by the initial attribute, an <initial> element holding one transition, or
+// a state with id "initial", and is otherwise the first state.  A timed transition is written as
+// a delayed <send> in <onentry>, cancelled by a <cancel> in <onexit>, and a transition on the
+// event sent.  A cond holding names joined with " && " requires all of their guards.  Anything
+// else results in a *SCXMLError giving the line of the construct.
+func ImportSCXML(r io.Reader, registry *Registry) (StateMachineBuilder, error) {
+	root, err := parseXMLTree(r)
+	if err != nil {
+		return nil, err
+	}
+	if root.name != "scxml" {
+		return nil, root.errorf("root element is <%s>, not <scxml>", root.name)
+	}
+	if err := root.checkAttrs("version", "initial", "name", "datamodel"); err != nil {
+		return nil, err
+	}
+	if version, ok := root.attr("version"); ok && version != "1.0" {
+		return nil, root.errorf("unsupported SCXML version %q", version)
+	}
+	imp := &scxmlImporter{
+		registry: registry,
+		smb:      NewFSMBuilder(),
+		states:   make(map[string]StateBuilder),
+	}
+	if err := imp.declareStates(root); err != nil {
+		return nil, err
+	}
+	if err := imp.setInitial(root); err != nil {
+		return nil, err
+	}
+	for _, n := range imp.stateNodes {
+		if err := imp.defineState(n); err != nil {
+			return nil, err
+		}
+	}
+	return imp.smb, nil
+}
+
+type scxmlImporter struct {
+	registry    *Registry
+	smb         StateMachineBuilder
+	states      map[string]StateBuilder
+	stateNodes  []*xmlNode
+	initialNode *xmlNode
+	firstState  string
+}
+
+// declareStates creates a state builder for each top level state, so that transitions can refer
+// to states defined after them.
+func (imp *scxmlImporter) declareStates(root *xmlNode) error {
+	final := ""
+	for _, n := range root.children {
+		switch n.name {
+		case "state", "final":
+		case "initial":
+			if imp.initialNode != nil {
+				return n.errorf("more than one <initial>")
+			}
+			imp.initialNode = n
+			continue
+		case "parallel", "history":
+			return n.errorf("<%s> is not supported", n.name)
+		default:
+			return n.errorf("unsupported element <%s> in <scxml>", n.name)
+		}
+		if err := n.checkAttrs("id"); err != nil {
+			return err
+		}
+		id, ok := n.attr("id")
+		if !ok || id == "" {
+			return n.errorf("<%s> has no id", n.name)
+		}
+		if _, dup := imp.states[id]; dup {
+			return n.errorf("state %q is defined more than once", id)
+		}
+		switch {
+		case n.name == "final" && final != "":
+			return n.errorf("more than one <final>, %q and %q; only one final state is supported", final, id)
+		case n.name == "final":
+			final = id
+			imp.states[id] = imp.smb.AddFinalState()
+		case id == InitialStateName:
+			imp.states[id] = imp.smb.GetInitialState()
+		case id == FinalStateName:
+			return n.errorf("state id %q is reserved for <final>", id)
+		default:
+			imp.states[id] = imp.smb.NewState(id, n.commentLabels()...)
+			if imp.firstState == "" {
+				imp.firstState = id
+			}
+		}
+		imp.stateNodes = append(imp.stateNodes, n)
+	}
+	return nil
+}
+
+func (imp *scxmlImporter) setInitial(root *xmlNode) error {
+	initial := imp.smb.GetInitialState()
+	_, explicit := imp.states[InitialStateName]
+	target, hasAttr := root.attr("initial")
+	switch {
+	case hasAttr && imp.initialNode != nil:
+		return imp.initialNode.errorf("<initial> given as well as the initial attribute")
+	case hasAttr && target == InitialStateName && explicit:
+		return nil
+	case hasAttr:
+		sb, err := imp.target(root, target)
+		if err != nil {
+			return err
+		}
+		initial.AddTransition(sb)
+	case imp.initialNode != nil:
+		n := imp.initialNode
+		if explicit {
+			return n.errorf("<initial> given as well as a state with id %q", InitialStateName)
+		}
+		if err := n.checkAttrs(); err != nil {
+			return err
+		}
+		if len(n.children) != 1 || n.children[0].name != "transition" {
+			return n.errorf("<initial> must hold exactly one <transition>")
+		}
+		t := n.children[0]
+		if err := t.checkAttrs("target"); err != nil {
+			return err
+		}
+		return imp.defineTransition(initial, t, nil)
+	case !explicit && imp.firstState != "":
+		initial.AddTransition(imp.states[imp.firstState])
+	}
+	return nil
+}
+
+// scxmlTimer is a delayed <send> started on entry to a state.
+type scxmlTimer struct {
+	node      *xmlNode
+	delay     time.Duration
+	cancelled bool
+	used      bool
+}
+
+func (imp *scxmlImporter) defineState(n *xmlNode) error {
+	id, _ := n.attr("id")
+	sb := imp.states[id]
+	final := n.name == "final"
+	timers := make(map[string]*scxmlTimer) // by event
+	timersByID := make(map[string]*scxmlTimer)
+	sends := []*scxmlTimer{}
+	cancels := []*xmlNode{}
+	entry, exit := []*xmlNode{}, []*xmlNode{}
+	transitions := []*xmlNode{}
+
+	for _, c := range n.children {
+		switch {
+		case c.name == "onentry" || c.name == "onexit":
+			if err := c.checkAttrs(); err != nil {
+				return err
+			}
+			for _, e := range c.children {
+				switch {
+				case e.name == "script":
+					if c.name == "onentry" {
+						entry = append(entry, e)
+					} else {
+						exit = append(exit, e)
+					}
+				case e.name == "send" && c.name == "onentry" && !final:
+					if err := e.checkAttrs("event", "id", "delay"); err != nil {
+						return err
+					}
+					event, _ := e.attr("event")
+					sendID, _ := e.attr("id")
+					delay, _ := e.attr("delay")
+					if event == "" || sendID == "" || delay == "" {
+						return e.errorf("<send> must have event, id and delay attributes; only delayed sends for timed transitions are supported")
+					}
+					d, err := parseSCXMLDelay(delay)
+					if err != nil {
+						return e.errorf("%v", err)
+					}
+					if _, dup := timers[event]; dup {
+						return e.errorf("more than one <send> of event %q", event)
+					}
+					timer := &scxmlTimer{node: e, delay: d}
+					timers[event] = timer
+					sends = append(sends, timer)
+					timersByID[sendID] = timer
+				case e.name == "cancel" && c.name == "onexit":
+					if err := e.checkAttrs("sendid"); err != nil {
+						return err
+					}
+					cancels = append(cancels, e)
+				default:
+					return e.errorf("<%s> is not supported in <%s> of state %q", e.name, c.name, id)
+				}
+			}
+		case c.name == "transition" && !final:
+			transitions = append(transitions, c)
+		case c.name == "state" || c.name == "parallel" || c.name == "history" || c.name == "initial":
+			return c.errorf("nested <%s> in state %q is not supported", c.name, id)
+		default:
+			return c.errorf("unsupported element <%s> in <%s>", c.name, n.name)
+		}
+	}
+
+	for _, c := range cancels {
+		sendID, _ := c.attr("sendid")
+		timer, ok := timersByID[sendID]
+		if !ok {
+			return c.errorf("<cancel> of %q, which is not sent on entry to state %q", sendID, id)
+		}
+		timer.cancelled = true
+	}
+
+	if len(entry) > 0 {
+		action, labels, err := imp.actions(entry)
+		if err != nil {
+			return err
+		}
+		sb.OnEntry(action, labels...)
+	}
+	if len(exit) > 0 {
+		action, labels, err := imp.actions(exit)
+		if err != nil {
+			return err
+		}
+		sb.OnExit(action, labels...)
+	}
+	for _, t := range transitions {
+		if err := t.checkAttrs("event", "cond", "target", "type"); err != nil {
+			return err
+		}
+		if err := imp.defineTransition(sb, t, timers); err != nil {
+			return err
+		}
+	}
+	for _, timer := range sends {
+		event, _ := timer.node.attr("event")
+		switch {
+		case !timer.used:
+			return timer.node.errorf("no transition of state %q on event %q sent", id, event)
+		case !timer.cancelled:
+			return timer.node.errorf("event %q is not cancelled in <onexit>; only timers cancelled on exit are supported", event)
+		}
+	}
+	return nil
+}
+
+func (imp *scxmlImporter) defineTransition(source StateBuilder, n *xmlNode, timers map[string]*scxmlTimer) error {
+	if kind, ok := n.attr("type"); ok && kind != "external" {
+		return n.errorf("transition type %q is not supported", kind)
+	}
+	target, ok := n.attr("target")
+	if !ok {
+		return n.errorf("transitions without a target are not supported")
+	}
+	targetBuilder, err := imp.target(n, target)
+	if err != nil {
+		return err
+	}
+	tb := source.AddTransition(targetBuilder)
+
+	if event, ok := n.attr("event"); ok {
+		switch {
+		case strings.ContainsAny(event, " \t\n") || strings.Contains(event, "*"):
+			return n.errorf("event %q: multiple and wildcard event descriptors are not supported", event)
+		case timers[event] != nil:
+			timers[event].used = true
+			tb.SetTimedTrigger(timers[event].delay)
+		case event != "":
+			tb.SetEventTrigger(event)
+		}
+	}
+	if cond, ok := n.attr("cond"); ok {
+		// guards with several labels are exported joined with " && "
+		names := strings.Split(cond, " && ")
+		guards := make([]TransitionGuard, len(names))
+		for idx, name := range names {
+			guard, registered := imp.registry.guards[name]
+			if !registered {
+				return n.errorf("guard %q is not registered", name)
+			}
+			guards[idx] = guard
+		}
+		tb.SetGuard(allGuards(names, guards), names...)
+	}
+
+	scripts := []*xmlNode{}
+	for _, c := range n.children {
+		if c.name != "script" {
+			return c.errorf("<%s> is not supported in <transition>", c.name)
+		}
+		scripts = append(scripts, c)
+	}
+	if len(scripts) > 0 {
+		effects := []TransitionEffect{}
+		labels := []string{}
+		for _, s := range scripts {
+			name, err := s.scriptName()
+			if err != nil {
+				return err
+			}
+			effect, registered := imp.registry.effects[name]
+			if !registered {
+				return s.errorf("effect %q is not registered", name)
+			}
+			effects = append(effects, effect)
+			labels = append(labels, name)
+		}
+		tb.SetEffect(sequenceEffects(effects), labels...)
+	}
+	return nil
+}
+
+func (imp *scxmlImporter) target(n *xmlNode, target string) (StateBuilder, error) {
+	if strings.ContainsAny(target, " \t\n") {
+		return nil, n.errorf("multiple targets %q are not supported", target)
+	}
+	sb, ok := imp.states[target]
+	if !ok {
+		return nil, n.errorf("target state %q is not defined", target)
+	}
+	return sb, nil
+}
+
+// actions returns one action running the actions registered for the scripts, in order.
+func (imp *scxmlImporter) actions(scripts []*xmlNode) (Action, []string, error) {
+	actions := []Action{}
+	labels := []string{}
+	for _, s := range scripts {
+		name, err := s.scriptName()
+		if err != nil {
+			return nil, nil, err
+		}
+		action, registered := imp.registry.actions[name]
+		if !registered {
+			return nil, nil, s.errorf("action %q is not registered", name)
+		}
+		actions = append(actions, action)
+		labels = append(labels, name)
+	}
+	return sequenceActions(actions), labels, nil
+}
+
+// parseSCXMLDelay parses a CSS2 time, a number of seconds or milliseconds such as "5s" or
+// "1500ms".  Any other unit is an error.
+func parseSCXMLDelay(delay string) (time.Duration, error) {
+	var unit time.Duration
+	var number string
+	switch {
+	case strings.HasSuffix(delay, "ms"):
+		unit, number = time.Millisecond, strings.TrimSuffix(delay, "ms")
+	case strings.HasSuffix(delay, "s"):
+		unit, number = time.Second, strings.TrimSuffix(delay, "s")
+	}
+	v, err := strconv.ParseFloat(number, 64)
+	if unit == 0 || err != nil || v < 0 || math.IsInf(v, 0) || math.IsNaN(v) {
+		return 0, fmt.Errorf("invalid delay %q, expected a time in s or ms such as 5s or 1500ms", delay)
+	}
+	return time.Duration(v * float64(unit)), nil
+}
+
+// xmlNode is an element of an XML document, with the line it starts on.
+type xmlNode struct {
+	name     string
+	attrs    []xml.Attr
+	children []*xmlNode
+	text     string
+	comments []string
+	line     int
+}
+
+func (n *xmlNode) errorf(format string, args ...interface{}) error {
+	return &SCXMLError{Line: n.line, Message: fmt.Sprintf(format, args...)}
+}
+
+func (n *xmlNode) attr(name string) (string, bool) {
+	for _, a := range n.attrs {
+		if a.Name.Local == name && a.Name.Space == "" {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// checkAttrs returns an error for any attribute not allowed, ignoring namespace declarations.
+func (n *xmlNode) checkAttrs(allowed ...string) error {
+	for _, a := range n.attrs {
+		if a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns") {
+			continue
+		}
+		ok := false
+		for _, name := range allowed {
+			if a.Name.Space == "" && a.Name.Local == name {
+				ok = true
+			}
+		}
+		if !ok {
+			return n.errorf("attribute %q of <%s> is not supported", a.Name.Local, n.name)
+		}
+	}
+	return nil
+}
+
+// commentLabels returns the lines of the element's comments, where ExportSCXML writes state labels.
+func (n *xmlNode) commentLabels() []string {
+	labels := []string{}
+	for _, c := range n.comments {
+		for _, l := range strings.Split(c, "\n") {
+			if l = strings.TrimSpace(l); l != "" {
+				labels = append(labels, l)
+			}
+		}
+	}
+	return labels
+}
+
+func (n *xmlNode) scriptName() (string, error) {
+	if _, ok := n.attr("src"); ok {
+		return "", n.errorf("<script src> is not supported")
+	}
+	if err := n.checkAttrs(); err != nil {
+		return "", err
+	}
+	if len(n.children) > 0 {
+		return "", n.children[0].errorf("<%s> is not supported in <script>", n.children[0].name)
+	}
+	name := strings.TrimSpace(n.text)
+	if name == "" {
+		return "", n.errorf("empty <script>")
+	}
+	return name, nil
+}
+
+// parseXMLTree reads an XML document into a tree of elements in the SCXML namespace, or with no
+// namespace.
+func parseXMLTree(r io.Reader) (*xmlNode, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	lineAt := func(offset int64) int {
+		return 1 + bytes.Count(data[:offset], []byte("\n"))
+	}
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var root *xmlNode
+	stack := []*xmlNode{}
+	for {
+		line := lineAt(dec.InputOffset())
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if se, ok := err.(*xml.SyntaxError); ok {
+				return nil, &SCXMLError{Line: se.Line, Message: se.Msg}
+			}
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &xmlNode{name: t.Name.Local, attrs: t.Attr, line: line}
+			if t.Name.Space != "" && t.Name.Space != SCXMLNamespace {
+				return nil, n.errorf("element <%s> in namespace %q is not supported", t.Name.Local, t.Name.Space)
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, n)
+			} else {
+				root = n
+			}
+			stack = append(stack, n)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text += string(t)
+			}
+		case xml.Comment:
+			if len(stack) > 0 {
+				stack[len(stack)-1].comments = append(stack[len(stack)-1].comments, string(t))
+			}
+		}
+	}
+	if root == nil {
+		return nil, &SCXMLError{Line: lineAt(int64(len(data))), Message: "no root element"}
+	}
+	return root, nil
+}
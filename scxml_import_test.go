@@ -0,0 +1,331 @@
+package fsm_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	fsm "github.com/johngrange/gofsm"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SCXML Import", func() {
+	type meterData struct {
+		credit  uint
+		tickets uint
+		entries []string
+	}
+
+	var registry *fsm.Registry
+
+	BeforeEach(func() {
+		registry = fsm.NewRegistry().
+			Guard("hasCredit", func(fsmData, eventData interface{}) bool { return fsmData.(*meterData).credit >= 100 }).
+			Effect("addCoin", func(ev fsm.Event, fsmData interface{}, dispatcher fsm.Dispatcher) {
+				fsmData.(*meterData).credit += ev.Data().(uint)
+			}).
+			Effect("printTicket", func(ev fsm.Event, fsmData interface{}, dispatcher fsm.Dispatcher) {
+				fsmData.(*meterData).tickets++
+			}).
+			Action("clearCredit", func(state fsm.State, fsmData interface{}, dispatcher fsm.Dispatcher) {
+				fsmData.(*meterData).credit = 0
+			}).
+			Action("log", func(state fsm.State, fsmData interface{}, dispatcher fsm.Dispatcher) {
+				data := fsmData.(*meterData)
+				data.entries = append(data.entries, state.Name())
+			})
+	})
+
+	importSCXML := func(scxml string) (fsm.StateMachineBuilder, error) {
+		return fsm.ImportSCXML(strings.NewReader(scxml), registry)
+	}
+
+	When("importing scxml", func() {
+		It("should build a machine with the behaviour registered", func() {
+			smb, err := importSCXML(`<?xml version="1.0"?>
+<scxml xmlns="http://www.w3.org/2005/07/scxml" version="1.0" initial="idle">
+  <state id="idle">
+    <!-- waiting for coins -->
+    <onentry><script>log</script></onentry>
+    <transition event="evInsertCoin" target="accepting"><script>addCoin</script></transition>
+  </state>
+  <state id="accepting">
+    <onentry>
+      <script>log</script>
+      <send event="timeout" id="t" delay="30s"/>
+    </onentry>
+    <onexit>
+      <cancel sendid="t"/>
+      <script>clearCredit</script>
+    </onexit>
+    <transition event="evInsertCoin" target="accepting"><script>addCoin</script></transition>
+    <transition event="evPrint" cond="hasCredit" target="idle"><script>printTicket</script></transition>
+    <transition event="timeout" target="idle"/>
+  </state>
+</scxml>`)
+			Expect(err).NotTo(HaveOccurred())
+			data := &meterData{}
+			clock := fsm.NewFakeClock(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+			sm, err := smb.SetData(data).SetClock(clock).BuildImmediateFSM()
+			Expect(err).NotTo(HaveOccurred())
+
+			sm.Start()
+			Expect(sm.CurrentState().Name()).To(Equal("idle"))
+			Expect(sm.CurrentState().StateLabels()).To(Equal([]string{"waiting for coins"}))
+			sm.Dispatch(fsm.NewEvent("evInsertCoin", uint(50)))
+			sm.Dispatch(fsm.NewEvent("evPrint", nil))
+			Expect(sm.CurrentState().Name()).To(Equal("accepting"))
+			sm.Dispatch(fsm.NewEvent("evInsertCoin", uint(50)))
+			sm.Dispatch(fsm.NewEvent("evPrint", nil))
+			Expect(sm.CurrentState().Name()).To(Equal("idle"))
+			Expect(data.tickets).To(BeNumerically("==", 1))
+			Expect(data.credit).To(BeNumerically("==", 0))
+
+			sm.Dispatch(fsm.NewEvent("evInsertCoin", uint(50)))
+			clock.Advance(30*time.Second + 1)
+			sm.Tick()
+			Expect(sm.CurrentState().Name()).To(Equal("idle"))
+			Expect(data.credit).To(BeNumerically("==", 0))
+			// the self transition on evInsertCoin does not re-enter accepting
+			Expect(data.entries).To(Equal([]string{"idle", "accepting", "idle", "accepting", "idle"}))
+		})
+
+		It("should import what ExportSCXML writes", func() {
+			smb := fsm.NewFSMBuilder()
+			idle := smb.NewState("idle", "waiting for coins")
+			accepting := smb.NewState("accepting")
+			smb.GetInitialState().AddTransition(idle).
+				SetEffect(func(ev fsm.Event, fsmData interface{}, dispatcher fsm.Dispatcher) {}, "addCoin")
+			idle.OnEntry(func(state fsm.State, fsmData interface{}, dispatcher fsm.Dispatcher) {}, "log")
+			idle.AddTransition(accepting).SetEventTrigger("evInsertCoin").
+				SetEffect(func(ev fsm.Event, fsmData interface{}, dispatcher fsm.Dispatcher) {}, "addCoin")
+			accepting.AddTransition(idle).SetEventTrigger("evPrint").
+				SetGuard(func(fsmData, eventData interface{}) bool { return true }, "hasCredit").
+				SetEffect(func(ev fsm.Event, fsmData interface{}, dispatcher fsm.Dispatcher) {}, "printTicket")
+			accepting.AddTransition(idle).SetTimedTrigger(1500 * time.Millisecond)
+			accepting.OnExit(func(state fsm.State, fsmData interface{}, dispatcher fsm.Dispatcher) {}, "clearCredit", "log")
+			accepting.AddTransition(smb.AddFinalState()).SetEventTrigger("evShutdown")
+			original, err := smb.BuildImmediateFSM()
+			Expect(err).NotTo(HaveOccurred())
+
+			exported := &bytes.Buffer{}
+			Expect(fsm.ExportSCXML(exported, original)).To(Succeed())
+			imported, err := importSCXML(exported.String())
+			Expect(err).NotTo(HaveOccurred())
+			sm, err := imported.BuildImmediateFSM()
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fsm.Diff(original, sm)).To(BeEmpty())
+			again := &bytes.Buffer{}
+			Expect(fsm.ExportSCXML(again, sm)).To(Succeed())
+			Expect(again.String()).To(Equal(exported.String()))
+		})
+
+		It("should round trip guards with several labels", func() {
+			inService := true
+			registry.Guard("inService", func(fsmData, eventData interface{}) bool { return inService })
+			smb := fsm.NewFSMBuilder()
+			idle := smb.NewState("idle")
+			printing := smb.NewState("printing")
+			smb.GetInitialState().AddTransition(idle)
+			idle.AddTransition(printing).SetEventTrigger("evPrint").
+				SetPredicate(fsm.AllOf(
+					fsm.Guard("hasCredit", func(fsmData, eventData interface{}) bool { return true }),
+					fsm.Guard("inService", func(fsmData, eventData interface{}) bool { return true })))
+			original, err := smb.BuildImmediateFSM()
+			Expect(err).NotTo(HaveOccurred())
+			exported := &bytes.Buffer{}
+			Expect(fsm.ExportSCXML(exported, original)).To(Succeed())
+			Expect(exported.String()).To(ContainSubstring(`cond="hasCredit &amp;&amp; inService"`))
+
+			imported, err := importSCXML(exported.String())
+			Expect(err).NotTo(HaveOccurred())
+			data := &meterData{credit: 100}
+			sm, err := imported.SetData(data).BuildImmediateFSM()
+			Expect(err).NotTo(HaveOccurred())
+			again := &bytes.Buffer{}
+			Expect(fsm.ExportSCXML(again, sm)).To(Succeed())
+			Expect(again.String()).To(Equal(exported.String()))
+
+			sm.Start()
+			inService = false
+			sm.Dispatch(fsm.NewEvent("evPrint", nil))
+			Expect(sm.CurrentState().Name()).To(Equal("idle"))
+			inService = true
+			sm.Dispatch(fsm.NewEvent("evPrint", nil))
+			Expect(sm.CurrentState().Name()).To(Equal("printing"))
+		})
+		It("should only import guards without labels if registered as \"guard\"", func() {
+			smb := fsm.NewFSMBuilder()
+			idle := smb.NewState("idle")
+			smb.GetInitialState().AddTransition(idle)
+			idle.AddTransition(idle).SetEventTrigger("evReset").
+				SetGuard(func(fsmData, eventData interface{}) bool { return true })
+			original, err := smb.BuildImmediateFSM()
+			Expect(err).NotTo(HaveOccurred())
+			exported := &bytes.Buffer{}
+			Expect(fsm.ExportSCXML(exported, original)).To(Succeed())
+
+			_, err = importSCXML(exported.String())
+			var scxmlErr *fsm.SCXMLError
+			Expect(errors.As(err, &scxmlErr)).To(BeTrue(), "error %v", err)
+			Expect(scxmlErr.Message).To(Equal(`guard "guard" is not registered`))
+
+			registry.Guard("guard", func(fsmData, eventData interface{}) bool { return true })
+			_, err = importSCXML(exported.String())
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("should take the initial transition from an <initial> element, or the first state", func() {
+			smb, err := importSCXML(`<scxml xmlns="http://www.w3.org/2005/07/scxml">
+  <initial><transition target="on"><script>printTicket</script></transition></initial>
+  <state id="off"/>
+  <state id="on"/>
+</scxml>`)
+			Expect(err).NotTo(HaveOccurred())
+			data := &meterData{}
+			sm, err := smb.SetData(data).BuildImmediateFSM()
+			Expect(err).NotTo(HaveOccurred())
+			sm.Start()
+			Expect(sm.CurrentState().Name()).To(Equal("on"))
+			Expect(data.tickets).To(BeNumerically("==", 1))
+
+			smb, err = importSCXML(`<scxml xmlns="http://www.w3.org/2005/07/scxml"><state id="off"/><state id="on"/></scxml>`)
+			Expect(err).NotTo(HaveOccurred())
+			sm, err = smb.BuildImmediateFSM()
+			Expect(err).NotTo(HaveOccurred())
+			sm.Start()
+			Expect(sm.CurrentState().Name()).To(Equal("off"))
+		})
+
+		It("should drive the paymentmeter example", func() {
+			f, err := os.Open("examples/paymentmeter/paymentmeter.scxml")
+			Expect(err).NotTo(HaveOccurred())
+			defer f.Close()
+			var effects []string
+			record := func(name string) fsm.TransitionEffect {
+				return func(ev fsm.Event, fsmData interface{}, dispatcher fsm.Dispatcher) { effects = append(effects, name) }
+			}
+			paid := false
+			smb, err := fsm.ImportSCXML(f, fsm.NewRegistry().
+				Effect("addCoin", record("addCoin")).
+				Guard("paidEnough", func(fsmData, eventData interface{}) bool { return paid }).
+				Effect("printTicket", record("printTicket")).
+				Action("clearPayment", func(state fsm.State, fsmData interface{}, dispatcher fsm.Dispatcher) {}))
+			Expect(err).NotTo(HaveOccurred())
+			sm, err := smb.BuildImmediateFSM()
+			Expect(err).NotTo(HaveOccurred())
+
+			sm.Start()
+			sm.Dispatch(fsm.NewEvent("evInsertCoin", uint(50)))
+			sm.Dispatch(fsm.NewEvent("evPrintTicket", nil))
+			Expect(sm.CurrentState().Name()).To(Equal("acceptingPayment"))
+			paid = true
+			sm.Dispatch(fsm.NewEvent("evPrintTicket", nil))
+			Expect(sm.CurrentState().Name()).To(Equal("idle"))
+			Expect(effects).To(Equal([]string{"addCoin", "printTicket"}))
+		})
+	})
+
+	DescribeTable("rejecting what it cannot import",
+		func(scxml string, line int, message string) {
+			_, err := importSCXML(scxml)
+			var scxmlErr *fsm.SCXMLError
+			Expect(errors.As(err, &scxmlErr)).To(BeTrue(), "error %v", err)
+			Expect(scxmlErr.Line).To(Equal(line))
+			Expect(scxmlErr.Message).To(ContainSubstring(message))
+		},
+		Entry("malformed xml", `<scxml>
+  <state id="a">
+</scxml>`, 3, "element <state> closed by </scxml>"),
+		Entry("another root", `<statechart/>`, 1, "root element is <statechart>"),
+		Entry("nested states", `<scxml>
+  <state id="a">
+    <state id="b"/>
+  </state>
+</scxml>`, 3, `nested <state> in state "a" is not supported`),
+		Entry("parallel states", `<scxml>
+
+  <parallel id="p"/>
+</scxml>`, 3, "<parallel> is not supported"),
+		Entry("unknown targets", `<scxml>
+  <state id="a">
+    <transition event="go" target="b"/>
+  </state>
+</scxml>`, 3, `target state "b" is not defined`),
+		Entry("unregistered guards", `<scxml>
+  <state id="a">
+    <transition event="go" cond="x &gt; 1" target="a"/>
+  </state>
+</scxml>`, 3, `guard "x > 1" is not registered`),
+		Entry("unregistered actions", `<scxml>
+  <state id="a">
+    <onentry>
+      <script>beep</script>
+    </onentry>
+  </state>
+</scxml>`, 4, `action "beep" is not registered`),
+		Entry("unregistered effects", `<scxml>
+  <state id="a">
+    <transition event="go" target="a">
+      <script>clearCredit</script>
+    </transition>
+  </state>
+</scxml>`, 4, `effect "clearCredit" is not registered`),
+		Entry("unsupported executable content", `<scxml>
+  <state id="a">
+    <onentry>
+      <log expr="'hello'"/>
+    </onentry>
+  </state>
+</scxml>`, 4, `<log> is not supported in <onentry> of state "a"`),
+		Entry("unsupported attributes", `<scxml>
+  <state id="a">
+    <transition event="go" target="a" type="internal"/>
+  </state>
+</scxml>`, 3, `transition type "internal" is not supported`),
+		Entry("wildcard events", `<scxml>
+  <state id="a">
+    <transition event="error.*" target="a"/>
+  </state>
+</scxml>`, 3, "wildcard event descriptors are not supported"),
+		Entry("timers that are not cancelled", `<scxml>
+  <state id="a">
+    <onentry>
+      <send event="timeout" id="t" delay="5s"/>
+    </onentry>
+    <transition event="timeout" target="a"/>
+  </state>
+</scxml>`, 4, `event "timeout" is not cancelled in <onexit>`),
+		Entry("invalid delays", `<scxml>
+  <state id="a">
+    <onentry>
+      <send event="timeout" id="t" delay="soon"/>
+    </onentry>
+  </state>
+</scxml>`, 4, `invalid delay "soon"`),
+		Entry("delays in units other than s and ms", `<scxml>
+  <state id="a">
+    <onentry>
+      <send event="timeout" id="t" delay="5m"/>
+    </onentry>
+  </state>
+</scxml>`, 4, `invalid delay "5m", expected a time in s or ms`),
+		Entry("delays that are not finite", `<scxml>
+  <state id="a">
+    <onentry>
+      <send event="timeout" id="t" delay="infs"/>
+    </onentry>
+  </state>
+</scxml>`, 4, `invalid delay "infs"`),
+		Entry("more than one final state", `<scxml>
+  <final id="done"/>
+  <final id="failed"/>
+</scxml>`, 3, "only one final state is supported"),
+		Entry("elements from other namespaces", `<scxml xmlns="http://www.w3.org/2005/07/scxml" xmlns:x="urn:example">
+  <x:state id="a"/>
+</scxml>`, 2, `element <state> in namespace "urn:example" is not supported`),
+	)
+})
@@ -0,0 +1,200 @@
+package fsm_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	fsm "github.com/johngrange/gofsm"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// the parts of an SCXML document the export writes, read independently of the package's own types
+type scxmlExecutable struct {
+	Scripts []string `xml:"script"`
+	Sends   []struct {
+		Event string `xml:"event,attr"`
+		ID    string `xml:"id,attr"`
+		Delay string `xml:"delay,attr"`
+	} `xml:"send"`
+	Cancels []struct {
+		SendID string `xml:"sendid,attr"`
+	} `xml:"cancel"`
+}
+
+type scxmlDocument struct {
+	XMLName xml.Name `xml:"http://www.w3.org/2005/07/scxml scxml"`
+	Version string   `xml:"version,attr"`
+	Initial string   `xml:"initial,attr"`
+	States  []struct {
+		ID          string           `xml:"id,attr"`
+		Comment     string           `xml:",comment"`
+		OnEntry     *scxmlExecutable `xml:"onentry"`
+		OnExit      *scxmlExecutable `xml:"onexit"`
+		Transitions []struct {
+			Event   string   `xml:"event,attr,omitempty"`
+			Cond    string   `xml:"cond,attr,omitempty"`
+			Target  string   `xml:"target,attr"`
+			Scripts []string `xml:"script"`
+		} `xml:"transition"`
+	} `xml:"state"`
+	Finals []struct {
+		ID string `xml:"id,attr"`
+	} `xml:"final"`
+}
+
+var _ = Describe("SCXML Export", func() {
+	type fsmData struct {
+	}
+
+	var (
+		stateMachineBuilder fsm.StateMachineBuilder
+		init                fsm.StateBuilder
+		buf                 *bytes.Buffer
+		err                 error
+	)
+
+	BeforeEach(func() {
+		stateMachineBuilder = fsm.NewFSMBuilder().SetData(&fsmData{})
+		init = stateMachineBuilder.GetInitialState()
+		buf = &bytes.Buffer{}
+	})
+
+	addStates := func() {
+		startingState := fsm.NewStateBuilder("starting")
+		startingState.OnEntry(func(state fsm.State, fsmData interface{}, dispatcher fsm.Dispatcher) {}, "initialise system")
+
+		onState := fsm.NewStateBuilder("on", "the on state")
+		offState := fsm.NewStateBuilder("off")
+		dimState := fsm.NewStateBuilder("dim")
+
+		init.AddTransition(startingState)
+		startingState.AddTransition(offState)
+
+		offState.AddTransition(onState).SetEventTrigger("TurnOn").SetGuard(func(fsmData, eventData interface{}) bool { return true }, "credit < price && open")
+		onState.AddTransition(offState).SetEventTrigger("TurnOff").SetEffect(func(ev fsm.Event, fsmData interface{}, dispatcher fsm.Dispatcher) {}, "perform effect")
+		onState.AddTransition(dimState).SetTimedTrigger(5 * time.Minute)
+		dimState.AddTransition(offState).SetTimedTrigger(1500 * time.Millisecond)
+		dimState.AddTransition(onState).SetEventTrigger("Touch")
+
+		onState.AddTransition(stateMachineBuilder.AddFinalState()).SetEventTrigger("FatalError").SetEffect(func(ev fsm.Event, fsmData interface{}, dispatcher fsm.Dispatcher) {}, "panic!")
+		onState.OnExit(func(state fsm.State, fsmData interface{}, dispatcher fsm.Dispatcher) {}, "turn out lights")
+
+		stateMachineBuilder.
+			AddState(startingState).
+			AddState(onState).
+			AddState(offState).
+			AddState(dimState)
+	}
+
+	export := func() string {
+		stateMachine, err := stateMachineBuilder.BuildImmediateFSM()
+		Expect(err).NotTo(HaveOccurred())
+		err = fsm.ExportSCXML(buf, stateMachine)
+		Expect(err).NotTo(HaveOccurred())
+		fmt.Fprintf(GinkgoWriter, "%s\n", buf.String())
+		return buf.String()
+	}
+
+	When("exporting scxml", func() {
+		It("should get the output right!", func() {
+			addStates()
+			expectedSCXML :=
+				`<?xml version="1.0" encoding="UTF-8"?>
+<scxml xmlns="http://www.w3.org/2005/07/scxml" version="1.0" initial="starting">
+  <state id="starting">
+    <onentry>
+      <script>initialise system</script>
+    </onentry>
+    <transition target="off"></transition>
+  </state>
+  <state id="on">
+    <!-- the on state -->
+    <onentry>
+      <send event="fsm.timer.1" id="timer1" delay="300s"></send>
+    </onentry>
+    <onexit>
+      <script>turn out lights</script>
+      <cancel sendid="timer1"></cancel>
+    </onexit>
+    <transition event="TurnOff" target="off">
+      <script>perform effect</script>
+    </transition>
+    <transition event="fsm.timer.1" target="dim"></transition>
+    <transition event="FatalError" target="FinalState">
+      <script>panic!</script>
+    </transition>
+  </state>
+  <state id="off">
+    <transition event="TurnOn" cond="credit &lt; price &amp;&amp; open" target="on"></transition>
+  </state>
+  <state id="dim">
+    <onentry>
+      <send event="fsm.timer.2" id="timer2" delay="1500ms"></send>
+    </onentry>
+    <onexit>
+      <cancel sendid="timer2"></cancel>
+    </onexit>
+    <transition event="fsm.timer.2" target="off"></transition>
+    <transition event="Touch" target="on"></transition>
+  </state>
+  <final id="FinalState"></final>
+</scxml>
+`
+			Expect(export()).To(Equal(expectedSCXML))
+			err = os.MkdirAll(testOutputDir, 0755)
+			Expect(err).NotTo(HaveOccurred())
+			err = os.WriteFile(path.Join(testOutputDir, "testone.scxml"), buf.Bytes(), 0600)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should write well-formed xml that round-trips", func() {
+			addStates()
+			output := export()
+
+			decoder := xml.NewDecoder(bytes.NewBufferString(output))
+			for {
+				_, err = decoder.Token()
+				if err == io.EOF {
+					break
+				}
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			doc := scxmlDocument{}
+			Expect(xml.Unmarshal([]byte(output), &doc)).To(Succeed())
+			Expect(doc.XMLName.Space).To(Equal(fsm.SCXMLNamespace))
+			Expect(doc.Initial).To(Equal("starting"))
+			Expect(doc.States).To(HaveLen(4))
+			Expect(doc.Finals).To(HaveLen(1))
+			off := doc.States[2]
+			Expect(off.ID).To(Equal("off"))
+			Expect(off.Transitions[0].Cond).To(Equal("credit < price && open"))
+			Expect(doc.States[1].Comment).To(Equal(" the on state "))
+			Expect(doc.States[3].OnEntry.Sends[0].Delay).To(Equal("1500ms"))
+
+			// marshalling what was read gives the same document back
+			again, err := xml.MarshalIndent(doc, "", "  ")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(xml.Header + string(again) + "\n").To(Equal(output))
+		})
+
+		It("should export an initial state with effects or guards as a state", func() {
+			offState := fsm.NewStateBuilder("off")
+			init.AddTransition(offState).SetEffect(func(ev fsm.Event, fsmData interface{}, dispatcher fsm.Dispatcher) {}, "power up")
+			stateMachineBuilder.AddState(offState)
+
+			doc := scxmlDocument{}
+			Expect(xml.Unmarshal([]byte(export()), &doc)).To(Succeed())
+			Expect(doc.Initial).To(Equal(fsm.InitialStateName))
+			Expect(doc.States[0].ID).To(Equal(fsm.InitialStateName))
+			Expect(doc.States[0].Transitions[0].Target).To(Equal("off"))
+			Expect(doc.States[0].Transitions[0].Scripts).To(Equal([]string{"power up"}))
+		})
+	})
+})
@@ -0,0 +1,238 @@
+package fsm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+const SnapshotVersion = 1
+
+// Snapshot captures a running machine so that it can be restored later, possibly in another
+// process, with StateMachineBuilder.RestoreImmediateFSM or RestoreThreadedFSM.
+type Snapshot struct {
+	Version int             `json:"version"`
+	State   string          `json:"state"`
+	Timers  []TimerSnapshot `json:"timers,omitempty"`
+	Queue   []EventSnapshot `json:"queue,omitempty"`
+	// Scheduled holds the events waiting to be dispatched by DispatchAfter or DispatchAt.
+	Scheduled []ScheduledEventSnapshot `json:"scheduled,omitempty"`
+	Data      []byte                   `json:"data,omitempty"`
+}
+
+// TimerSnapshot records the time remaining on a pending timed transition of the current state.
+type TimerSnapshot struct {
+	Transition int           `json:"transition"` // index of the transition in the state
+	Target     string        `json:"target"`
+	Remaining  time.Duration `json:"remaining"` // nanoseconds
+}
+
+type EventSnapshot struct {
+	Name    string   `json:"name"`
+	Labels  []string `json:"labels,omitempty"`
+	Payload []byte   `json:"payload,omitempty"`
+}
+
+// ScheduledEventSnapshot records an event scheduled with DispatchAfter or DispatchAt.  State is
+// the state that cancels the event on exit, if it was scheduled with CancelOnExit.
+type ScheduledEventSnapshot struct {
+	ID    TimerID       `json:"id"`
+	Due   time.Time     `json:"due"`
+	Event EventSnapshot `json:"event"`
+	State string        `json:"state,omitempty"`
+}
+
+// DataCodec converts FSM data to and from bytes for snapshots.  DecodeData is given the data
+// set on the builder being restored, which it may decode into, and returns the restored data.
+type DataCodec interface {
+	EncodeData(data interface{}) ([]byte, error)
+	DecodeData(encoded []byte, data interface{}) (interface{}, error)
+}
+
+type jsonDataCodec struct{}
+
+// NewJSONDataCodec returns the default DataCodec.  It decodes into the builder's data, so
+// that data should be a pointer with exported fields.
+func NewJSONDataCodec() DataCodec {
+	return jsonDataCodec{}
+}
+
+func (jsonDataCodec) EncodeData(data interface{}) ([]byte, error) {
+	if data == nil {
+		return nil, nil
+	}
+	return json.Marshal(data)
+}
+
+func (jsonDataCodec) DecodeData(encoded []byte, data interface{}) (interface{}, error) {
+	if len(encoded) == 0 {
+		return data, nil
+	}
+	if data == nil {
+		err := json.Unmarshal(encoded, &data)
+		return data, err
+	}
+	err := json.Unmarshal(encoded, data)
+	return data, err
+}
+
+func WriteSnapshot(w io.Writer, s Snapshot) error {
+	return json.NewEncoder(w).Encode(s)
+}
+
+func ReadSnapshot(r io.Reader) (Snapshot, error) {
+	var s Snapshot
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return Snapshot{}, err
+	}
+	if s.Version != SnapshotVersion {
+		return Snapshot{}, fmt.Errorf("unsupported snapshot version %d", s.Version)
+	}
+	return s, nil
+}
+
+// snapshot captures the machine.  queued holds events waiting to be processed, in order.
+func (f *immediateFSMImpl) snapshot(queued []Event) (Snapshot, error) {
+	s := Snapshot{
+		Version: SnapshotVersion,
+		State:   f.currentState.Name(),
+		Timers:  []TimerSnapshot{},
+		Queue:   []EventSnapshot{},
+	}
+	timeNow := f.clock.Now()
+	for idx, transition := range f.currentState.Transitions() {
+		deadline, ok := transition.deadline()
+		if !ok {
+			continue
+		}
+		remaining := deadline.Sub(timeNow)
+		if remaining < 0 {
+			remaining = 0
+		}
+		s.Timers = append(s.Timers, TimerSnapshot{
+			Transition: idx,
+			Target:     transition.Target().Name(),
+			Remaining:  remaining,
+		})
+	}
+	for _, ev := range queued {
+		payload, err := f.payloadCodec.Encode(ev.Name(), ev.Data())
+		if err != nil {
+			return Snapshot{}, fmt.Errorf("encoding payload of event %s: %w", ev.Name(), err)
+		}
+		s.Queue = append(s.Queue, EventSnapshot{Name: ev.Name(), Labels: ev.Labels(), Payload: payload})
+	}
+	f.scheduleMX.Lock()
+	scheduled := append([]scheduledEvent(nil), f.scheduled...)
+	f.scheduleMX.Unlock()
+	for _, se := range scheduled {
+		payload, err := f.payloadCodec.Encode(se.ev.Name(), se.ev.Data())
+		if err != nil {
+			return Snapshot{}, fmt.Errorf("encoding payload of scheduled event %s: %w", se.ev.Name(), err)
+		}
+		ss := ScheduledEventSnapshot{
+			ID:    se.id,
+			Due:   se.due,
+			Event: EventSnapshot{Name: se.ev.Name(), Labels: se.ev.Labels(), Payload: payload},
+		}
+		if se.cancelOnExit {
+			ss.State = se.state.Name()
+		}
+		s.Scheduled = append(s.Scheduled, ss)
+	}
+	var err error
+	s.Data, err = f.dataCodec.EncodeData(f.fsmData)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("encoding fsm data: %w", err)
+	}
+	return s, nil
+}
+
+func (f *immediateFSMImpl) Snapshot() (Snapshot, error) {
+	// the queue only holds events when called from within an action
+	queued := make([]Event, 0, len(f.eventQueue))
+	for len(f.eventQueue) > 0 {
+		queued = append(queued, <-f.eventQueue)
+	}
+	for _, ev := range queued {
+		f.eventQueue <- ev
+	}
+	return f.snapshot(queued)
+}
+
+// restore puts a built, but not started, machine into the snapshot's state and marks it as
+// running, without calling entry actions.  It returns the snapshot's queued events.
+func (f *immediateFSMImpl) restore(s Snapshot) ([]Event, error) {
+	if s.Version != SnapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", s.Version)
+	}
+	state := f.findState(s.State)
+	if state == nil {
+		return nil, fmt.Errorf("snapshot state %q not found in machine", s.State)
+	}
+	data, err := f.dataCodec.DecodeData(s.Data, f.fsmData)
+	if err != nil {
+		return nil, fmt.Errorf("decoding fsm data: %w", err)
+	}
+	queued := make([]Event, 0, len(s.Queue))
+	for _, es := range s.Queue {
+		payload, err := f.payloadCodec.Decode(es.Name, es.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("decoding payload of event %s: %w", es.Name, err)
+		}
+		queued = append(queued, NewEvent(es.Name, payload, es.Labels...))
+	}
+	scheduled := make([]scheduledEvent, 0, len(s.Scheduled))
+	nextTimerID := TimerID(0)
+	for _, ss := range s.Scheduled {
+		payload, err := f.payloadCodec.Decode(ss.Event.Name, ss.Event.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("decoding payload of scheduled event %s: %w", ss.Event.Name, err)
+		}
+		se := scheduledEvent{id: ss.ID, due: ss.Due, ev: NewEvent(ss.Event.Name, payload, ss.Event.Labels...)}
+		if ss.State != "" {
+			se.cancelOnExit = true
+			se.state = f.findState(ss.State)
+			if se.state == nil {
+				return nil, fmt.Errorf("scheduled event %s cancels on exit from state %q, not found in machine", ss.Event.Name, ss.State)
+			}
+		}
+		if se.id > nextTimerID {
+			nextTimerID = se.id
+		}
+		scheduled = append(scheduled, se)
+	}
+
+	timeNow := f.clock.Now()
+	transitions := state.Transitions()
+	for _, ts := range s.Timers {
+		if ts.Transition < 0 || ts.Transition >= len(transitions) ||
+			transitions[ts.Transition].Target().Name() != ts.Target ||
+			transitions[ts.Transition].TriggerType() != TimerTrigger {
+			return nil, fmt.Errorf("snapshot timer %d to %q does not match a timed transition of state %q", ts.Transition, ts.Target, s.State)
+		}
+		transition := transitions[ts.Transition]
+		transition.startTimer(timeNow.Add(ts.Remaining - transition.TimerDuration()))
+	}
+
+	f.fsmData = data
+	f.setCurrentState(state)
+	f.blockedInState = make(map[Transition]bool)
+	f.scheduleMX.Lock()
+	f.scheduled = scheduled
+	f.nextTimerID = nextTimerID
+	f.scheduleMX.Unlock()
+	f.running = true
+	f.rearmScheduled()
+	return queued, nil
+}
+
+func (f *immediateFSMImpl) findState(name string) State {
+	for _, candidate := range f.states {
+		if candidate.Name() == name {
+			return candidate
+		}
+	}
+	return nil
+}
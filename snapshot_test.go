@@ -0,0 +1,135 @@
+package fsm_test
+
+import (
+	"bytes"
+	"time"
+
+	fsm "github.com/johngrange/gofsm"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Snapshot and restore", func() {
+	type orderData struct {
+		Items   int
+		Entries int
+	}
+
+	var (
+		clock    *fsm.FakeClock
+		snapshot func() fsm.Snapshot // set by the snapshotting effect
+	)
+
+	definition := func(data *orderData, timeout time.Duration) fsm.StateMachineBuilder {
+		smb := fsm.NewFSMBuilder().SetData(data).SetClock(clock)
+		open := smb.NewState("open")
+		packing := smb.NewState("packing")
+		shipped := smb.NewState("shipped")
+		cancelled := smb.NewState("cancelled")
+		smb.GetInitialState().AddTransition(open)
+		open.OnEntry(func(state fsm.State, fsmData interface{}, dispatcher fsm.Dispatcher) {
+			fsmData.(*orderData).Entries++
+		})
+		open.AddTransition(open).SetEventTrigger("add").SetEffect(func(ev fsm.Event, fsmData interface{}, dispatcher fsm.Dispatcher) {
+			fsmData.(*orderData).Items += int(ev.Data().(float64))
+		})
+		open.AddTransition(cancelled).SetTimedTrigger(timeout)
+		open.AddTransition(packing).SetEventTrigger("checkout").SetEffect(func(ev fsm.Event, fsmData interface{}, dispatcher fsm.Dispatcher) {
+			dispatcher.Dispatch(fsm.NewEvent("ship", nil))
+			if snapshot != nil {
+				snapshot()
+			}
+		})
+		packing.AddTransition(shipped).SetEventTrigger("ship")
+		return smb
+	}
+
+	BeforeEach(func() {
+		clock = fsm.NewFakeClock(time.Date(2022, 10, 1, 9, 0, 0, 0, time.UTC))
+		snapshot = nil
+	})
+
+	It("should restore state, data and remaining timer time without re-entering", func() {
+		sm, err := definition(&orderData{}, time.Hour).BuildImmediateFSM()
+		Expect(err).NotTo(HaveOccurred())
+		sm.Start()
+		sm.Dispatch(fsm.NewEvent("add", float64(3)))
+		clock.Advance(20 * time.Minute)
+
+		snap, err := sm.Snapshot()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(snap.State).To(Equal("open"))
+		Expect(snap.Timers).To(Equal([]fsm.TimerSnapshot{{Transition: 1, Target: "cancelled", Remaining: 40 * time.Minute}}))
+
+		buf := bytes.Buffer{}
+		Expect(fsm.WriteSnapshot(&buf, snap)).To(Succeed())
+		decoded, err := fsm.ReadSnapshot(&buf)
+		Expect(err).NotTo(HaveOccurred())
+
+		clock.Advance(24 * time.Hour) // downtime
+		data := &orderData{}
+		restored, err := definition(data, time.Hour).RestoreImmediateFSM(decoded)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(restored.CurrentState().Name()).To(Equal("open"))
+		Expect(data).To(Equal(&orderData{Items: 3, Entries: 1}))
+
+		clock.Advance(39 * time.Minute)
+		restored.Tick()
+		Expect(restored.CurrentState().Name()).To(Equal("open"))
+		clock.Advance(2 * time.Minute)
+		restored.Tick()
+		Expect(restored.CurrentState().Name()).To(Equal("cancelled"))
+	})
+	It("should capture and restore queued events", func() {
+		var sm fsm.ImmediateFSM
+		var snap fsm.Snapshot
+		snapshot = func() fsm.Snapshot {
+			var err error
+			snap, err = sm.Snapshot()
+			Expect(err).NotTo(HaveOccurred())
+			return snap
+		}
+		var err error
+		sm, err = definition(&orderData{}, time.Hour).BuildImmediateFSM()
+		Expect(err).NotTo(HaveOccurred())
+		sm.Start()
+		sm.Dispatch(fsm.NewEvent("checkout", nil))
+		Expect(sm.CurrentState().Name()).To(Equal("shipped"))
+		Expect(snap.State).To(Equal("open")) // effect runs before the state is left
+		Expect(snap.Queue).To(Equal([]fsm.EventSnapshot{{Name: "ship", Labels: []string{}}}))
+
+		snapshot = nil
+		snap.State = "packing" // as if taken after the step completed
+		snap.Timers = nil
+		restored, err := definition(&orderData{}, time.Hour).RestoreImmediateFSM(snap)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(restored.CurrentState().Name()).To(Equal("shipped"))
+	})
+	It("should resume threaded machines with their timers", func() {
+		sm, err := definition(&orderData{}, time.Hour).BuildImmediateFSM()
+		Expect(err).NotTo(HaveOccurred())
+		sm.Start()
+		clock.Advance(time.Hour - 50*time.Millisecond)
+		snap, err := sm.Snapshot()
+		Expect(err).NotTo(HaveOccurred())
+
+		restoredBuilder := definition(&orderData{}, time.Hour).SetClock(fsm.RealClock())
+		restored, err := restoredBuilder.RestoreThreadedFSM(snap)
+		Expect(err).NotTo(HaveOccurred())
+		defer restored.Stop()
+		Expect(restored.CurrentState().Name()).To(Equal("open"))
+		Eventually(func() string { return restored.CurrentState().Name() }, "500ms").Should(Equal("cancelled"))
+
+		snap, err = restored.Snapshot()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(snap.State).To(Equal("cancelled"))
+	})
+	It("should reject snapshots that don't match the machine", func() {
+		_, err := definition(&orderData{}, time.Hour).RestoreImmediateFSM(fsm.Snapshot{Version: fsm.SnapshotVersion, State: "nosuch"})
+		Expect(err).To(MatchError(`snapshot state "nosuch" not found in machine`))
+		_, err = definition(&orderData{}, time.Hour).RestoreImmediateFSM(fsm.Snapshot{Version: 99, State: "open"})
+		Expect(err).To(MatchError("unsupported snapshot version 99"))
+		_, err = fsm.ReadSnapshot(bytes.NewBufferString(`{"version":2,"state":"open"}`))
+		Expect(err).To(MatchError("unsupported snapshot version 2"))
+	})
+})
@@ -8,6 +8,12 @@ type fsmStateImpl struct {
 	stateLabels []string
 	entryLabels []string
 	exitLabels  []string
+	invariants  []stateInvariant
+}
+
+type stateInvariant struct {
+	check StateInvariant
+	label string
 }
 
 func (s *fsmStateImpl) Name() string {
@@ -32,6 +38,24 @@ func (s *fsmStateImpl) ExitLabels() []string {
 	return s.exitLabels
 }
 
+func (s *fsmStateImpl) InvariantLabels() []string {
+	labels := make([]string, len(s.invariants))
+	for idx, inv := range s.invariants {
+		labels[idx] = inv.label
+	}
+	return labels
+}
+
+func (s *fsmStateImpl) violatedInvariants(fsmData interface{}) []string {
+	var violated []string
+	for _, inv := range s.invariants {
+		if !inv.check(fsmData) {
+			violated = append(violated, inv.label)
+		}
+	}
+	return violated
+}
+
 func (s *fsmStateImpl) doEntry(fsm FSM) {
 	s.onEntry(s, fsm.GetData(), fsm.GetDispatcher())
 }
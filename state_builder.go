@@ -8,6 +8,7 @@ type fsmStateBuilder struct {
 	stateLabels    []string
 	entryLabels    []string
 	exitLabels     []string
+	invariants     []stateInvariant
 	finalisedState *fsmStateImpl
 }
 
@@ -37,6 +38,11 @@ func (sb *fsmStateBuilder) OnExit(f Action, labels ...string) StateBuilder {
 	return sb
 }
 
+func (sb *fsmStateBuilder) Invariant(check StateInvariant, label string) StateBuilder {
+	sb.invariants = append(sb.invariants, stateInvariant{check: check, label: label})
+	return sb
+}
+
 func (sb *fsmStateBuilder) AddTransition(target StateBuilder, labels ...string) TransitionBuilder {
 	t := newTransitionBuilder(sb, target, labels...)
 
@@ -44,6 +50,14 @@ func (sb *fsmStateBuilder) AddTransition(target StateBuilder, labels ...string)
 	return t
 }
 
+func (sb *fsmStateBuilder) stateName() string {
+	return sb.name
+}
+
+func (sb *fsmStateBuilder) transitionBuilders() []TransitionBuilder {
+	return sb.transitions
+}
+
 func (sb *fsmStateBuilder) build() (State, error) {
 	if sb.finalisedState != nil {
 		return sb.finalisedState, nil
@@ -56,6 +70,7 @@ func (sb *fsmStateBuilder) build() (State, error) {
 		stateLabels: sb.stateLabels,
 		entryLabels: sb.entryLabels,
 		exitLabels:  sb.exitLabels,
+		invariants:  sb.invariants,
 	}
 	sb.finalisedState = state
 	return state, nil
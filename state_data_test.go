@@ -127,3 +127,87 @@ var _ = Describe("Tests for data in states", func() {
 	})
 
 })
+
+var _ = Describe("Accessing fsm data from outside the machine", func() {
+	type doorData struct {
+		obstructed bool
+		closeCount int
+	}
+	var (
+		smb  fsm.StateMachineBuilder
+		data *doorData
+	)
+
+	BeforeEach(func() {
+		data = &doorData{obstructed: true}
+		smb = fsm.NewFSMBuilder().SetData(data)
+		open := smb.NewState("open")
+		closed := smb.NewState("closed")
+		smb.GetInitialState().AddTransition(open)
+		open.AddTransition(closed).SetGuard(func(fsmData, eventData interface{}) bool {
+			return !fsmData.(*doorData).obstructed
+		}, "!obstructed").SetEffect(func(ev fsm.Event, fsmData interface{}, dispatcher fsm.Dispatcher) {
+			fsmData.(*doorData).closeCount++
+		})
+		closed.AddTransition(open).SetEventTrigger("evOpen")
+	})
+
+	readCloseCount := func(sm fsm.FSM) int {
+		count := 0
+		sm.ReadData(func(d interface{}) {
+			count = d.(*doorData).closeCount
+		})
+		return count
+	}
+
+	It("should only re-evaluate an immediate fsm on Tick by default", func() {
+		sm, err := smb.BuildImmediateFSM()
+		Expect(err).NotTo(HaveOccurred())
+		sm.Start()
+		sm.WithData(func(d interface{}) {
+			d.(*doorData).obstructed = false
+		})
+		Expect(sm.CurrentState().Name()).To(Equal("open"))
+		sm.Tick()
+		Expect(sm.CurrentState().Name()).To(Equal("closed"))
+		Expect(readCloseCount(sm)).To(Equal(1))
+	})
+	It("should re-evaluate an immediate fsm on data updates when enabled", func() {
+		sm, err := smb.SetEvaluateOnDataUpdate(true).BuildImmediateFSM()
+		Expect(err).NotTo(HaveOccurred())
+		sm.Start()
+		sm.WithData(func(d interface{}) {
+			d.(*doorData).obstructed = false
+		})
+		Expect(sm.CurrentState().Name()).To(Equal("closed"))
+	})
+	It("should serialise data access with a running threaded fsm", func() {
+		sm, err := smb.SetEvaluateOnDataUpdate(true).BuildThreadedFSM()
+		Expect(err).NotTo(HaveOccurred())
+		sm.Start()
+		defer sm.Stop()
+		for i := 1; i <= 20; i++ {
+			sm.WithData(func(d interface{}) {
+				d.(*doorData).obstructed = false
+			})
+			Eventually(func() int { return readCloseCount(sm) }).Should(Equal(i))
+			sm.WithData(func(d interface{}) {
+				d.(*doorData).obstructed = true
+			})
+			sm.Dispatch(fsm.NewEvent("evOpen", nil))
+			Eventually(func() string { return sm.CurrentState().Name() }).Should(Equal("open"))
+		}
+	})
+	It("should serialise data access with a runtime instance", func() {
+		rt := fsm.NewRuntime(2)
+		defer rt.Stop()
+		Expect(rt.Spawn("door", smb.SetEvaluateOnDataUpdate(true))).To(Succeed())
+		sm, err := rt.Instance("door")
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(func() string { return sm.CurrentState().Name() }).Should(Equal("open"))
+		sm.WithData(func(d interface{}) {
+			d.(*doorData).obstructed = false
+		})
+		Eventually(func() int { return readCloseCount(sm) }).Should(Equal(1))
+	})
+})
@@ -0,0 +1,316 @@
+package fsm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrNotFound        = errors.New("fsm instance not found in store")
+	ErrVersionConflict = errors.New("fsm instance version conflict")
+)
+
+// Store persists machine snapshots by instance ID.  Every save increments the version of the
+// instance.  Save and Delete take the version the caller last loaded or saved, 0 for an instance
+// that has never been saved, and fail with ErrVersionConflict if the stored version differs.
+type Store interface {
+	Load(instanceID string) (Snapshot, uint64, error)
+	Save(instanceID string, s Snapshot, expectedVersion uint64) (uint64, error)
+	Delete(instanceID string, expectedVersion uint64) error
+	List() ([]string, error)
+}
+
+type storeRecord struct {
+	Version  uint64   `json:"version"`
+	Snapshot Snapshot `json:"snapshot"`
+}
+
+type MemoryStore struct {
+	mx      sync.Mutex
+	records map[string]storeRecord
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		records: make(map[string]storeRecord),
+	}
+}
+
+func (m *MemoryStore) Load(instanceID string) (Snapshot, uint64, error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	r, ok := m.records[instanceID]
+	if !ok {
+		return Snapshot{}, 0, ErrNotFound
+	}
+	return r.Snapshot, r.Version, nil
+}
+
+func (m *MemoryStore) Save(instanceID string, s Snapshot, expectedVersion uint64) (uint64, error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	if m.records[instanceID].Version != expectedVersion {
+		return 0, ErrVersionConflict
+	}
+	r := storeRecord{Version: expectedVersion + 1, Snapshot: s}
+	m.records[instanceID] = r
+	return r.Version, nil
+}
+
+func (m *MemoryStore) Delete(instanceID string, expectedVersion uint64) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	r, ok := m.records[instanceID]
+	if !ok {
+		return ErrNotFound
+	}
+	if r.Version != expectedVersion {
+		return ErrVersionConflict
+	}
+	delete(m.records, instanceID)
+	return nil
+}
+
+func (m *MemoryStore) List() ([]string, error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	ids := make([]string, 0, len(m.records))
+	for id := range m.records {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// FileStore keeps one JSON file per instance in a directory.  Files are replaced atomically by
+// writing and syncing a temporary file, then renaming it over the old one.  Version checks are
+// only safe between users of the same FileStore.
+type FileStore struct {
+	mx  sync.Mutex
+	dir string
+}
+
+const fileStoreExt = ".fsm.json"
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (fs *FileStore) path(instanceID string) string {
+	return filepath.Join(fs.dir, url.PathEscape(instanceID)+fileStoreExt)
+}
+
+func (fs *FileStore) read(instanceID string) (storeRecord, error) {
+	contents, err := os.ReadFile(fs.path(instanceID))
+	if errors.Is(err, os.ErrNotExist) {
+		return storeRecord{}, ErrNotFound
+	}
+	if err != nil {
+		return storeRecord{}, err
+	}
+	var r storeRecord
+	if err := json.Unmarshal(contents, &r); err != nil {
+		return storeRecord{}, fmt.Errorf("reading %s: %w", fs.path(instanceID), err)
+	}
+	return r, nil
+}
+
+func (fs *FileStore) Load(instanceID string) (Snapshot, uint64, error) {
+	fs.mx.Lock()
+	defer fs.mx.Unlock()
+	r, err := fs.read(instanceID)
+	if err != nil {
+		return Snapshot{}, 0, err
+	}
+	return r.Snapshot, r.Version, nil
+}
+
+func (fs *FileStore) Save(instanceID string, s Snapshot, expectedVersion uint64) (uint64, error) {
+	fs.mx.Lock()
+	defer fs.mx.Unlock()
+	existing, err := fs.read(instanceID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return 0, err
+	}
+	if existing.Version != expectedVersion {
+		return 0, ErrVersionConflict
+	}
+	r := storeRecord{Version: expectedVersion + 1, Snapshot: s}
+	contents, err := json.Marshal(r)
+	if err != nil {
+		return 0, err
+	}
+	if err := fs.writeAtomic(fs.path(instanceID), contents); err != nil {
+		return 0, err
+	}
+	return r.Version, nil
+}
+
+func (fs *FileStore) writeAtomic(path string, contents []byte) error {
+	tmp, err := os.CreateTemp(fs.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+	if _, err := tmp.Write(contents); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return fs.syncDir()
+}
+
+func (fs *FileStore) syncDir() error {
+	d, err := os.Open(fs.dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func (fs *FileStore) Delete(instanceID string, expectedVersion uint64) error {
+	fs.mx.Lock()
+	defer fs.mx.Unlock()
+	existing, err := fs.read(instanceID)
+	if err != nil {
+		return err
+	}
+	if existing.Version != expectedVersion {
+		return ErrVersionConflict
+	}
+	if err := os.Remove(fs.path(instanceID)); err != nil {
+		return err
+	}
+	return fs.syncDir()
+}
+
+func (fs *FileStore) List() ([]string, error) {
+	fs.mx.Lock()
+	defer fs.mx.Unlock()
+	entries, err := os.ReadDir(fs.dir)
+	if err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, fileStoreExt) {
+			continue
+		}
+		id, err := url.PathUnescape(strings.TrimSuffix(name, fileStoreExt))
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// checkpoint saves the machine to its store at the end of a step, or after its data is updated,
+// unless nothing has changed since the last save.
+func (f *immediateFSMImpl) checkpoint() {
+	if f.store == nil {
+		return
+	}
+	transitioned := f.transitionedInStep
+	f.transitionedInStep = false
+	s, err := f.Snapshot()
+	if err == nil {
+		if !transitioned && f.lastCheckpoint != nil && sameCheckpoint(*f.lastCheckpoint, s) {
+			return
+		}
+		f.storeVersion, err = f.store.Save(f.instanceID, s, f.storeVersion)
+		if err == nil {
+			f.lastCheckpoint = &s
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("checkpointing fsm instance %s: %w", f.instanceID, err)
+		if f.logger.Enabled(LogError) {
+			f.logger.Log(LogError, err.Error(), StateField(f.currentState))
+		}
+		f.traceError(err, f.currentState, f.fsmData)
+	}
+}
+
+// sameCheckpoint compares snapshots ignoring their timers, which only change on a transition.
+func sameCheckpoint(a, b Snapshot) bool {
+	a.Timers, b.Timers = nil, nil
+	return reflect.DeepEqual(a, b)
+}
+
+// RecoverImmediateFSMs restores every instance in the store.  definition must return a new
+// builder for the given instance each time it is called.  Recovered machines keep
+// checkpointing to the store.
+func RecoverImmediateFSMs(store Store, definition func(instanceID string) StateMachineBuilder) (map[string]ImmediateFSM, error) {
+	machines := make(map[string]ImmediateFSM)
+	err := recoverInstances(store, definition, func(instanceID string, smb StateMachineBuilder, s Snapshot) error {
+		sm, err := smb.RestoreImmediateFSM(s)
+		if err != nil {
+			return err
+		}
+		machines[instanceID] = sm
+		return nil
+	})
+	return machines, err
+}
+
+// RecoverThreadedFSMs restores and resumes every instance in the store, as RecoverImmediateFSMs.
+func RecoverThreadedFSMs(store Store, definition func(instanceID string) StateMachineBuilder) (map[string]FSM, error) {
+	machines := make(map[string]FSM)
+	err := recoverInstances(store, definition, func(instanceID string, smb StateMachineBuilder, s Snapshot) error {
+		sm, err := smb.RestoreThreadedFSM(s)
+		if err != nil {
+			return err
+		}
+		machines[instanceID] = sm
+		return nil
+	})
+	return machines, err
+}
+
+func recoverInstances(store Store, definition func(instanceID string) StateMachineBuilder,
+	restore func(instanceID string, smb StateMachineBuilder, s Snapshot) error) error {
+	ids, err := store.List()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		s, version, err := store.Load(id)
+		if err != nil {
+			return fmt.Errorf("loading fsm instance %s: %w", id, err)
+		}
+		smb := definition(id).SetCheckpointStore(store, id)
+		fb, ok := smb.(*fsmBuilder)
+		if !ok {
+			return fmt.Errorf("restoring fsm instance %s: unsupported builder %T", id, smb)
+		}
+		fb.storeVersion = version
+		if err := restore(id, smb, s); err != nil {
+			return fmt.Errorf("restoring fsm instance %s: %w", id, err)
+		}
+	}
+	return nil
+}
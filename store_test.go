@@ -0,0 +1,186 @@
+package fsm_test
+
+import (
+	"os"
+
+	fsm "github.com/johngrange/gofsm"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Persistence stores", func() {
+	newFileStore := func() fsm.Store {
+		dir, err := os.MkdirTemp("", "gofsm-store")
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(os.RemoveAll, dir)
+		store, err := fsm.NewFileStore(dir)
+		Expect(err).NotTo(HaveOccurred())
+		return store
+	}
+	newMemoryStore := func() fsm.Store {
+		return fsm.NewMemoryStore()
+	}
+
+	DescribeTable("store semantics",
+		func(newStore func() fsm.Store) {
+			store := newStore()
+			_, _, err := store.Load("order/1")
+			Expect(err).To(MatchError(fsm.ErrNotFound))
+
+			snap := fsm.Snapshot{Version: fsm.SnapshotVersion, State: "open", Data: []byte(`{"Items":1}`)}
+			version, err := store.Save("order/1", snap, 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(version).To(BeNumerically("==", 1))
+			_, err = store.Save("order/1", snap, 0)
+			Expect(err).To(MatchError(fsm.ErrVersionConflict))
+
+			snap.State = "packing"
+			version, err = store.Save("order/1", snap, 1)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(version).To(BeNumerically("==", 2))
+			loaded, version, err := store.Load("order/1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(version).To(BeNumerically("==", 2))
+			Expect(loaded.State).To(Equal("packing"))
+			Expect(loaded.Data).To(Equal(snap.Data))
+
+			_, err = store.Save("order/2", snap, 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(store.List()).To(Equal([]string{"order/1", "order/2"}))
+
+			Expect(store.Delete("order/1", 1)).To(MatchError(fsm.ErrVersionConflict))
+			Expect(store.Delete("order/1", 2)).To(Succeed())
+			Expect(store.Delete("order/1", 2)).To(MatchError(fsm.ErrNotFound))
+			Expect(store.List()).To(Equal([]string{"order/2"}))
+		},
+		Entry("in memory", newMemoryStore),
+		Entry("file system", newFileStore),
+	)
+
+	Describe("checkpointing", func() {
+		type orderData struct {
+			Items int
+		}
+		definition := func(instanceID string) fsm.StateMachineBuilder {
+			smb := fsm.NewFSMBuilder().SetData(&orderData{})
+			open := smb.NewState("open")
+			packing := smb.NewState("packing")
+			smb.GetInitialState().AddTransition(open)
+			open.AddTransition(open).SetEventTrigger("add").SetEffect(func(ev fsm.Event, fsmData interface{}, dispatcher fsm.Dispatcher) {
+				fsmData.(*orderData).Items++
+			})
+			open.AddTransition(packing).SetEventTrigger("checkout")
+			return smb
+		}
+		var store fsm.Store
+
+		BeforeEach(func() {
+			store = newFileStore()
+		})
+
+		It("should save after every step that changes the machine", func() {
+			sm, err := definition("a").SetCheckpointStore(store, "a").BuildImmediateFSM()
+			Expect(err).NotTo(HaveOccurred())
+			sm.Start()
+			snap, version, err := store.Load("a")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(version).To(BeNumerically("==", 1))
+			Expect(snap.State).To(Equal("open"))
+
+			sm.Dispatch(fsm.NewEvent("add", nil))
+			sm.Dispatch(fsm.NewEvent("nosuch", nil))
+			snap, version, err = store.Load("a")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(version).To(BeNumerically("==", 2))
+			Expect(string(snap.Data)).To(Equal(`{"Items":1}`))
+
+			// data updated outside a transition is saved, steps that change nothing are not
+			sm.WithData(func(data interface{}) { data.(*orderData).Items++ })
+			sm.Tick()
+			snap, version, err = store.Load("a")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(version).To(BeNumerically("==", 3))
+			Expect(string(snap.Data)).To(Equal(`{"Items":2}`))
+		})
+		It("should save data changed by actions of steps that do not transition", func() {
+			smb := fsm.NewFSMBuilder().SetData(&orderData{}).SetCheckpointStore(store, "a")
+			open := smb.NewState("open")
+			smb.GetInitialState().AddTransition(open)
+			open.AddTransition(open).SetEventTrigger("add").SetGuard(func(fsmData, eventData interface{}) bool {
+				fsmData.(*orderData).Items++ // counts attempts, then refuses
+				return false
+			})
+			sm, err := smb.BuildImmediateFSM()
+			Expect(err).NotTo(HaveOccurred())
+			sm.Start()
+			sm.Dispatch(fsm.NewEvent("add", nil))
+			snap, version, err := store.Load("a")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(version).To(BeNumerically("==", 2))
+			Expect(string(snap.Data)).To(Equal(`{"Items":1}`))
+		})
+		It("should report version conflicts through tracers", func() {
+			sm, err := definition("a").SetCheckpointStore(store, "a").BuildImmediateFSM()
+			Expect(err).NotTo(HaveOccurred())
+			sm.Start()
+			recorder := &errorRecorder{StateCounter: *fsm.NewStateCounter()}
+			duplicate, err := definition("a").SetCheckpointStore(store, "a").AddTracer(recorder).BuildImmediateFSM()
+			Expect(err).NotTo(HaveOccurred())
+			duplicate.Start()
+			Expect(recorder.errs).To(HaveLen(1))
+			Expect(recorder.errs[0]).To(MatchError(fsm.ErrVersionConflict))
+		})
+		It("should recover all instances from the store", func() {
+			for _, id := range []string{"a", "b"} {
+				sm, err := definition(id).SetCheckpointStore(store, id).BuildImmediateFSM()
+				Expect(err).NotTo(HaveOccurred())
+				sm.Start()
+				sm.Dispatch(fsm.NewEvent("add", nil))
+			}
+			machines, err := fsm.RecoverImmediateFSMs(store, definition)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(machines).To(HaveLen(2))
+			Expect(machines["a"].CurrentState().Name()).To(Equal("open"))
+			Expect(machines["b"].GetData()).To(Equal(&orderData{Items: 1}))
+
+			// recovered machines carry on checkpointing
+			machines["b"].Dispatch(fsm.NewEvent("checkout", nil))
+			snap, version, err := store.Load("b")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(version).To(BeNumerically("==", 3))
+			Expect(snap.State).To(Equal("packing"))
+		})
+		It("should refuse to recover with a builder it did not make", func() {
+			sm, err := definition("a").SetCheckpointStore(store, "a").BuildImmediateFSM()
+			Expect(err).NotTo(HaveOccurred())
+			sm.Start()
+			_, err = fsm.RecoverImmediateFSMs(store, func(instanceID string) fsm.StateMachineBuilder {
+				return &wrappedBuilder{definition(instanceID)}
+			})
+			Expect(err).To(MatchError("restoring fsm instance a: unsupported builder *fsm_test.wrappedBuilder"))
+		})
+		It("should recover instances into a runtime", func() {
+			sm, err := definition("a").SetCheckpointStore(store, "a").BuildImmediateFSM()
+			Expect(err).NotTo(HaveOccurred())
+			sm.Start()
+			rt := fsm.NewRuntime(2)
+			defer rt.Stop()
+			Expect(rt.Recover(store, definition)).To(Succeed())
+			Expect(rt.Dispatch("a", fsm.NewEvent("checkout", nil))).To(Succeed())
+			Eventually(func() string {
+				snap, _, _ := store.Load("a")
+				return snap.State
+			}).Should(Equal("packing"))
+		})
+	})
+})
+
+// wrappedBuilder is a StateMachineBuilder implemented outside the package
+type wrappedBuilder struct {
+	fsm.StateMachineBuilder
+}
+
+func (w *wrappedBuilder) SetCheckpointStore(store fsm.Store, instanceID string) fsm.StateMachineBuilder {
+	w.StateMachineBuilder.SetCheckpointStore(store, instanceID)
+	return w
+}
@@ -1,6 +1,7 @@
 package fsm_test
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -70,13 +71,76 @@ var _ = Describe("Timed transition tests", func() {
 					return stateMachine.CurrentState().Name()
 				}
 				stateMachine.Start()
+				defer stateMachine.Stop()
 				Eventually(currStateName, "200ms").Should(Equal("off"))
 				Eventually(currStateName, "500ms").Should(Equal("on"))
 				Consistently(currStateName, "100ms").Should(Equal("on"))
-				data.followGuardOnToOff = true
+				stateMachine.WithData(func(d interface{}) {
+					d.(*fsmData).followGuardOnToOff = true
+				})
 				Eventually(currStateName, "200ms").Should(Equal("off"))
 			})
 		})
+		When("querying the next deadline on immediate fsm", func() {
+			It("should report the pending timer in the current state", func() {
+				stateMachine, err := stateMachineBuilder.BuildImmediateFSM()
+				Expect(err).NotTo(HaveOccurred())
+
+				_, ok := stateMachine.NextDeadline()
+				Expect(ok).To(BeFalse())
+				before := time.Now()
+				stateMachine.Start()
+				deadline, ok := stateMachine.NextDeadline()
+				Expect(ok).To(BeTrue())
+				Expect(deadline).To(BeTemporally("~", before.Add(100*time.Millisecond), 20*time.Millisecond))
+			})
+			It("should not report expired timers", func() {
+				stateMachine, err := stateMachineBuilder.BuildImmediateFSM()
+				Expect(err).NotTo(HaveOccurred())
+				stateMachine.Start()
+				time.Sleep(110 * time.Millisecond)
+				stateMachine.Tick()
+				Expect(stateMachine.CurrentState().Name()).To(Equal("on"))
+				_, ok := stateMachine.NextDeadline()
+				Expect(ok).To(BeTrue())
+				time.Sleep(160 * time.Millisecond)
+				// guard is false, so timer has expired without transitioning
+				_, ok = stateMachine.NextDeadline()
+				Expect(ok).To(BeFalse())
+			})
+		})
+		When("running the immediate fsm in a run loop", func() {
+			It("should fire timers without Tick() calls", func() {
+				stateMachine, err := stateMachineBuilder.BuildImmediateFSM()
+				Expect(err).NotTo(HaveOccurred())
+				stateMachine.AddTracer(logger)
+				stateMachine.Start()
+				data.followGuardOnToOff = true
+
+				ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+				defer cancel()
+				events := make(chan fsm.Event)
+				start := time.Now()
+				err = stateMachine.RunLoop(ctx, events)
+				Expect(err).To(MatchError(context.DeadlineExceeded))
+				// off --100ms--> on --150ms--> off
+				Expect(stateMachine.CurrentState().Name()).To(Equal("off"))
+				Expect(time.Since(start)).To(BeNumerically(">=", 300*time.Millisecond))
+			})
+			It("should dispatch events and return when the channel is closed", func() {
+				stateMachine, err := stateMachineBuilder.BuildImmediateFSM()
+				Expect(err).NotTo(HaveOccurred())
+				counter := fsm.NewStateCounter()
+				stateMachine.AddTracer(counter)
+				stateMachine.Start()
+
+				events := make(chan fsm.Event, 2)
+				events <- fsm.NewEvent("unknown", nil)
+				close(events)
+				Expect(stateMachine.RunLoop(context.Background(), events)).To(Succeed())
+				Expect(counter.RejectedEventCounts).To(HaveKeyWithValue("unknown", uint64(1)))
+			})
+		})
 		When("processing timers on immediate fsm", func() {
 			It("should only transition during Tick() calls", func() {
 				stateMachine, err := stateMachineBuilder.BuildImmediateFSM()
@@ -157,6 +221,7 @@ var _ = Describe("Timed transition tests", func() {
 				}
 				data.abGuard = true
 				stateMachine.Start()
+				defer stateMachine.Stop()
 				Eventually(currStateName, "1ms").Should(Equal("stateA"))
 				Eventually(currStateName, "500ms").Should(Equal("stateB"))
 			})
@@ -172,6 +237,7 @@ var _ = Describe("Timed transition tests", func() {
 				data.abGuard = true
 				data.acGuard = true
 				stateMachine.Start()
+				defer stateMachine.Stop()
 				Eventually(currStateName, "1ms").Should(Equal("stateA"))
 				Eventually(currStateName, "300ms").Should(Equal("stateC"))
 				// Give time for the ab timer to have occurred
@@ -44,6 +44,8 @@ type LogEntry struct {
 type Logger struct {
 	Entries  []LogEntry
 	Detailed bool
+	// Guards also logs the guards that were false for each rejected event.
+	Guards bool
 }
 
 func NewFSMLogger() *Logger {
@@ -98,6 +100,31 @@ func (l *Logger) OnRejectedEvent(ev Event, state State, fsmData interface{}) {
 	})
 }
 
+func (l *Logger) OnGuardFailed(ev Event, transition Transition, failed string, fsmData interface{}) {
+	if !l.Guards {
+		return
+	}
+	detail := ""
+	if l.Detailed {
+		detail = fmt.Sprintf(":  event, %+v, fsm: %+v", ev, fsmData)
+	}
+	l.Entries = append(l.Entries, LogEntry{
+		time.Now(),
+		fmt.Sprintf("Grd : %s --> %s [%s] failed [%s]%s", transition.Source().Name(), transition.Target().Name(), ev.Name(), failed, detail),
+	})
+}
+
+func (l *Logger) OnInvariantViolated(state State, label string, fsmData interface{}) {
+	detail := ""
+	if l.Detailed {
+		detail = fmt.Sprintf(":  fsm: %+v", fsmData)
+	}
+	l.Entries = append(l.Entries, LogEntry{
+		time.Now(),
+		fmt.Sprintf("Inv : %s violated [%s]%s", state.Name(), label, detail),
+	})
+}
+
 func (l *Logger) Fprint(w io.Writer) error {
 	for _, entry := range l.Entries {
 		_, err := fmt.Fprintf(w, "%s: %s\n", entry.When.Format(time.RFC3339Nano), entry.Message)
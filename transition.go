@@ -1,11 +1,18 @@
 package fsm
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 type transitionImpl struct {
 	source         State
 	target         State
 	guard          TransitionGuard
+	predicate      Predicate // may be nil
+	lastFailure    string
+	guarded        bool
+	accepts        func(eventData interface{}) bool // may be nil, for any payload
 	action         TransitionEffect
 	triggerEvent   string
 	labels         []string
@@ -28,16 +35,41 @@ func (t *transitionImpl) shouldTransitionEv(ev Event, fsmData interface{}) bool
 	if t.triggerType != EventTrigger {
 		return false
 	}
-	return ev.Name() == t.triggerEvent && t.guard(fsmData, ev.Data())
+	return ev.Name() == t.triggerEvent && t.acceptsPayload(ev.Data()) && t.checkGuard(fsmData, ev.Data())
 }
-func (t *transitionImpl) shouldTransitionNoEv(fsmData interface{}) bool {
+
+func (t *transitionImpl) acceptsPayload(eventData interface{}) bool {
+	return t.accepts == nil || t.accepts(eventData)
+}
+
+// checkGuard evaluates the guard, recording the reason it failed.
+func (t *transitionImpl) checkGuard(fsmData, eventData interface{}) bool {
+	if t.predicate == nil {
+		return t.guard(fsmData, eventData)
+	}
+	ok, failed := t.predicate.evaluate(fsmData, eventData)
+	t.lastFailure = failed
+	return ok
+}
+
+func (t *transitionImpl) failedGuard() string {
+	if t.predicate == nil {
+		return strings.Join(t.guardLabels, " ")
+	}
+	return t.lastFailure
+}
+func (t *transitionImpl) shouldTransitionNoEv(fsmData interface{}, now time.Time) bool {
+	return t.due(now) && t.checkGuard(fsmData, nil)
+}
+
+func (t *transitionImpl) due(now time.Time) bool {
 	switch t.triggerType {
 	case EventTrigger:
 		return false
 	case NoTrigger:
-		return t.guard(fsmData, nil)
+		return true
 	case TimerTrigger:
-		return time.Now().After(t.timerDeadline) && t.guard(fsmData, nil)
+		return now.After(t.timerDeadline)
 	default:
 		// shouldn't happen
 		return false
@@ -49,6 +81,12 @@ func (t *transitionImpl) startTimer(timeFrom time.Time) {
 		t.timerDeadline = timeFrom.Add(t.timeoutTrigger)
 	}
 }
+func (t *transitionImpl) deadline() (time.Time, bool) {
+	if t.triggerType != TimerTrigger || t.timerDeadline.IsZero() {
+		return time.Time{}, false
+	}
+	return t.timerDeadline, true
+}
 func (t *transitionImpl) TriggerType() TriggerType {
 	return t.triggerType
 }
@@ -71,6 +109,10 @@ func (t *transitionImpl) IsLocal() bool {
 	return t.source == t.target
 }
 
+func (t *transitionImpl) IsGuarded() bool {
+	return t.guarded
+}
+
 func (t *transitionImpl) TriggerLabels() []string {
 	return t.triggerLabels
 }
@@ -6,6 +6,9 @@ type transitionBuilderImpl struct {
 	source              StateBuilder
 	target              StateBuilder
 	guard               TransitionGuard
+	predicate           Predicate // may be nil
+	guarded             bool
+	accepts             func(eventData interface{}) bool // may be nil, for any payload
 	action              TransitionEffect
 	triggerEvent        string
 	labels              []string
@@ -53,8 +56,27 @@ func (tb *transitionBuilderImpl) SetTimedTrigger(timer time.Duration, labels ...
 func (tb *transitionBuilderImpl) SetGuard(guard TransitionGuard, labels ...string) TransitionBuilder {
 	tb.guardLabels = append(tb.guardLabels, labels...)
 	tb.guard = guard
+	tb.predicate = nil
+	tb.guarded = true
 	return tb
 }
+func (tb *transitionBuilderImpl) SetPredicate(predicate Predicate) TransitionBuilder {
+	tb.guardLabels = append(tb.guardLabels, predicate.Label())
+	tb.guard = func(fsmData, eventData interface{}) bool {
+		ok, _ := predicate.evaluate(fsmData, eventData)
+		return ok
+	}
+	tb.predicate = predicate
+	tb.guarded = true
+	return tb
+}
+
+// setPayloadCheck restricts the trigger to events whose payload passes accepts.  Unlike a guard,
+// the transition is not marked as guarded.
+func (tb *transitionBuilderImpl) setPayloadCheck(accepts func(eventData interface{}) bool) {
+	tb.accepts = accepts
+}
+
 func (tb *transitionBuilderImpl) SetEffect(effect TransitionEffect, labels ...string) TransitionBuilder {
 	tb.effectLabels = append(tb.effectLabels, labels...)
 	tb.action = effect
@@ -77,6 +99,9 @@ func (tb *transitionBuilderImpl) build(source, target State) (Transition, error)
 		source:         source,
 		target:         target,
 		guard:          tb.guard,
+		predicate:      tb.predicate,
+		guarded:        tb.guarded,
+		accepts:        tb.accepts,
 		action:         tb.action,
 		triggerEvent:   tb.triggerEvent,
 		labels:         tb.labels,
@@ -89,6 +114,18 @@ func (tb *transitionBuilderImpl) build(source, target State) (Transition, error)
 	return tb.finalisedTransition, nil
 }
 
+func (tb *transitionBuilderImpl) eventName() string {
+	return tb.triggerEvent
+}
+
+func (tb *transitionBuilderImpl) timerDuration() time.Duration {
+	return tb.timeoutTrigger
+}
+
+func (tb *transitionBuilderImpl) isGuarded() bool {
+	return tb.guarded
+}
+
 func (tb *transitionBuilderImpl) TriggerType() TriggerType {
 	return tb.triggerType
 }
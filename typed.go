@@ -0,0 +1,230 @@
+package fsm
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Type-safe wrappers around the builder API.  FSM data is of type D, and events are declared
+// with EventType[P] so their payloads are of type P.  Events whose payload is not a P do not
+// trigger typed transitions, and are rejected rather than causing a panic in user callbacks.
+
+type TypedAction[D any] func(state State, data D, dispatcher Dispatcher)
+type TypedGuard[D, P any] func(data D, payload P) bool
+type TypedEffect[D, P any] func(ev Event, data D, payload P, dispatcher Dispatcher)
+
+// NoPayload is the payload type of events that carry no data, and of eventless and timed transitions.
+type NoPayload struct{}
+
+type PayloadTypeError struct {
+	EventName string
+	Expected  reflect.Type
+	Actual    reflect.Type
+}
+
+func (e *PayloadTypeError) Error() string {
+	return fmt.Sprintf("event %s: expected payload of type %v, got %v", e.EventName, e.Expected, e.Actual)
+}
+
+type EventType[P any] struct {
+	name string
+}
+
+func NewEventType[P any](name string) EventType[P] {
+	return EventType[P]{name: name}
+}
+
+func (et EventType[P]) Name() string {
+	return et.name
+}
+
+// New creates an event of this type.
+func (et EventType[P]) New(payload P, labels ...string) Event {
+	return NewEvent(et.name, payload, labels...)
+}
+
+// Dispatch creates an event of this type and dispatches it.
+func (et EventType[P]) Dispatch(dispatcher Dispatcher, payload P, labels ...string) {
+	dispatcher.Dispatch(et.New(payload, labels...))
+}
+
+// Payload extracts the typed payload from an event.  A nil payload is accepted as the zero value
+// of P when P is NoPayload or can hold nil.
+func (et EventType[P]) Payload(ev Event) (P, error) {
+	return payloadAs[P](ev)
+}
+
+func payloadAs[P any](ev Event) (P, error) {
+	var zero P
+	if ev == nil || ev.Data() == nil {
+		if nilablePayload[P]() {
+			return zero, nil
+		}
+		name := ""
+		if ev != nil {
+			name = ev.Name()
+		}
+		return zero, &PayloadTypeError{EventName: name, Expected: reflect.TypeOf(&zero).Elem()}
+	}
+	p, ok := ev.Data().(P)
+	if !ok {
+		return zero, &PayloadTypeError{
+			EventName: ev.Name(),
+			Expected:  reflect.TypeOf(&zero).Elem(),
+			Actual:    reflect.TypeOf(ev.Data()),
+		}
+	}
+	return p, nil
+}
+
+func nilablePayload[P any]() bool {
+	t := reflect.TypeOf((*P)(nil)).Elem()
+	if t == reflect.TypeOf(NoPayload{}) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		return true
+	}
+	return false
+}
+
+// dataAs panics if the machine's data is not a D, as typed callbacks cannot run without it.
+func dataAs[D any](fsmData interface{}) D {
+	d, ok := fsmData.(D)
+	if !ok && (fsmData != nil || !nilablePayload[D]()) {
+		panic(fmt.Sprintf("fsm: typed machine data is %T, not %v", fsmData, reflect.TypeOf((*D)(nil)).Elem()))
+	}
+	return d
+}
+
+// DataOf returns the data of a machine built by a TypedFSMBuilder[D].
+func DataOf[D any](f FSM) D {
+	return dataAs[D](f.GetData())
+}
+
+type TypedFSMBuilder[D any] struct {
+	StateMachineBuilder
+}
+
+func NewTypedFSMBuilder[D any](data D) *TypedFSMBuilder[D] {
+	return &TypedFSMBuilder[D]{
+		StateMachineBuilder: NewFSMBuilder().SetData(data),
+	}
+}
+
+func (b *TypedFSMBuilder[D]) NewState(name string, labels ...string) *TypedStateBuilder[D] {
+	return &TypedStateBuilder[D]{b.StateMachineBuilder.NewState(name, labels...)}
+}
+
+func (b *TypedFSMBuilder[D]) AddState(sb *TypedStateBuilder[D]) *TypedFSMBuilder[D] {
+	b.StateMachineBuilder.AddState(sb.StateBuilder)
+	return b
+}
+
+func (b *TypedFSMBuilder[D]) GetInitialState() *TypedStateBuilder[D] {
+	return &TypedStateBuilder[D]{b.StateMachineBuilder.GetInitialState()}
+}
+
+func (b *TypedFSMBuilder[D]) AddFinalState() *TypedStateBuilder[D] {
+	return &TypedStateBuilder[D]{b.StateMachineBuilder.AddFinalState()}
+}
+
+func (b *TypedFSMBuilder[D]) GetFinalState() *TypedStateBuilder[D] {
+	fs := b.StateMachineBuilder.GetFinalState()
+	if fs == nil {
+		return nil
+	}
+	return &TypedStateBuilder[D]{fs}
+}
+
+type TypedStateBuilder[D any] struct {
+	StateBuilder
+}
+
+func NewTypedStateBuilder[D any](name string, labels ...string) *TypedStateBuilder[D] {
+	return &TypedStateBuilder[D]{NewStateBuilder(name, labels...)}
+}
+
+func (sb *TypedStateBuilder[D]) OnEntry(action TypedAction[D], labels ...string) *TypedStateBuilder[D] {
+	sb.StateBuilder.OnEntry(func(state State, fsmData interface{}, dispatcher Dispatcher) {
+		action(state, dataAs[D](fsmData), dispatcher)
+	}, labels...)
+	return sb
+}
+
+func (sb *TypedStateBuilder[D]) OnExit(action TypedAction[D], labels ...string) *TypedStateBuilder[D] {
+	sb.StateBuilder.OnExit(func(state State, fsmData interface{}, dispatcher Dispatcher) {
+		action(state, dataAs[D](fsmData), dispatcher)
+	}, labels...)
+	return sb
+}
+
+func (sb *TypedStateBuilder[D]) Invariant(check func(data D) bool, label string) *TypedStateBuilder[D] {
+	sb.StateBuilder.Invariant(func(fsmData interface{}) bool {
+		return check(dataAs[D](fsmData))
+	}, label)
+	return sb
+}
+
+// AddTransition adds an eventless transition, which may be given a timed trigger.
+// Use AddEventTransition for event triggered transitions.
+func (sb *TypedStateBuilder[D]) AddTransition(target *TypedStateBuilder[D], labels ...string) *TypedTransitionBuilder[D, NoPayload] {
+	return &TypedTransitionBuilder[D, NoPayload]{
+		tb: sb.StateBuilder.AddTransition(target.StateBuilder, labels...),
+	}
+}
+
+// AddEventTransition adds a transition triggered by events of type evType.
+func AddEventTransition[D, P any](source, target *TypedStateBuilder[D], evType EventType[P], labels ...string) *TypedTransitionBuilder[D, P] {
+	tb := &TypedTransitionBuilder[D, P]{
+		tb: source.StateBuilder.AddTransition(target.StateBuilder, labels...),
+	}
+	tb.tb.SetEventTrigger(evType.Name())
+	if pc, ok := tb.tb.(payloadChecker); ok {
+		pc.setPayloadCheck(func(eventData interface{}) bool {
+			_, ok := eventData.(P)
+			return ok || (eventData == nil && nilablePayload[P]())
+		})
+	}
+	return tb
+}
+
+type payloadChecker interface {
+	setPayloadCheck(accepts func(eventData interface{}) bool)
+}
+
+type TypedTransitionBuilder[D, P any] struct {
+	tb TransitionBuilder
+}
+
+func (t *TypedTransitionBuilder[D, P]) SetTimedTrigger(delay time.Duration, labels ...string) *TypedTransitionBuilder[D, P] {
+	t.tb.SetTimedTrigger(delay, labels...)
+	return t
+}
+
+// SetGuard sets the guard for this transition.  It is only called for events whose payload is a P.
+func (t *TypedTransitionBuilder[D, P]) SetGuard(guard TypedGuard[D, P], labels ...string) *TypedTransitionBuilder[D, P] {
+	t.tb.SetGuard(func(fsmData, eventData interface{}) bool {
+		payload, _ := eventData.(P)
+		return guard(dataAs[D](fsmData), payload)
+	}, labels...)
+	return t
+}
+
+func (t *TypedTransitionBuilder[D, P]) SetEffect(effect TypedEffect[D, P], labels ...string) *TypedTransitionBuilder[D, P] {
+	t.tb.SetEffect(func(ev Event, fsmData interface{}, dispatcher Dispatcher) {
+		var payload P
+		if ev != nil {
+			payload, _ = payloadAs[P](ev)
+		}
+		effect(ev, dataAs[D](fsmData), payload, dispatcher)
+	}, labels...)
+	return t
+}
+
+// Builder returns the underlying untyped transition builder.
+func (t *TypedTransitionBuilder[D, P]) Builder() TransitionBuilder {
+	return t.tb
+}
@@ -0,0 +1,130 @@
+package fsm_test
+
+import (
+	"errors"
+	"time"
+
+	fsm "github.com/johngrange/gofsm"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Typed FSM", func() {
+	type meter struct {
+		credit     uint
+		ticketCost uint
+		tickets    uint
+	}
+
+	var (
+		evInsertCoin  = fsm.NewEventType[uint]("evInsertCoin")
+		evPrintTicket = fsm.NewEventType[fsm.NoPayload]("evPrintTicket")
+		data          *meter
+		smb           *fsm.TypedFSMBuilder[*meter]
+		counter       *fsm.StateCounter
+	)
+
+	BeforeEach(func() {
+		data = &meter{ticketCost: 100}
+		smb = fsm.NewTypedFSMBuilder(data)
+		counter = fsm.NewStateCounter()
+		smb.AddTracer(counter)
+
+		idle := smb.NewState("idle")
+		paying := smb.NewState("paying")
+		printing := smb.NewState("printing")
+		smb.GetInitialState().AddTransition(idle)
+
+		addCoin := func(ev fsm.Event, m *meter, coin uint, dispatcher fsm.Dispatcher) {
+			m.credit += coin
+		}
+		fsm.AddEventTransition(idle, paying, evInsertCoin).SetEffect(addCoin, "credit += coin")
+		fsm.AddEventTransition(paying, paying, evInsertCoin).SetEffect(addCoin, "credit += coin")
+		fsm.AddEventTransition(paying, printing, evPrintTicket).
+			SetGuard(func(m *meter, _ fsm.NoPayload) bool {
+				return m.credit >= m.ticketCost
+			}, "credit >= ticketCost")
+		printing.OnEntry(func(state fsm.State, m *meter, dispatcher fsm.Dispatcher) {
+			m.tickets++
+			m.credit = 0
+		})
+		printing.AddTransition(idle).SetTimedTrigger(10*time.Millisecond, "printed")
+	})
+
+	It("should pass typed data and payloads to callbacks", func() {
+		sm, err := smb.BuildImmediateFSM()
+		Expect(err).NotTo(HaveOccurred())
+		sm.Start()
+		evInsertCoin.Dispatch(sm, 50)
+		evInsertCoin.Dispatch(sm, 50)
+		Expect(data.credit).To(BeNumerically("==", 100))
+		evPrintTicket.Dispatch(sm, fsm.NoPayload{})
+		Expect(sm.CurrentState().Name()).To(Equal("printing"))
+		Expect(fsm.DataOf[*meter](sm).tickets).To(BeNumerically("==", 1))
+	})
+	It("should reject events with the wrong payload type instead of panicking", func() {
+		sm, err := smb.BuildImmediateFSM()
+		Expect(err).NotTo(HaveOccurred())
+		sm.Start()
+		Expect(func() { sm.Dispatch(fsm.NewEvent("evInsertCoin", "fifty")) }).NotTo(Panic())
+		Expect(sm.CurrentState().Name()).To(Equal("idle"))
+		Expect(counter.RejectedEventCounts).To(HaveKeyWithValue("evInsertCoin", uint64(1)))
+	})
+	It("should accept a nil payload for NoPayload events", func() {
+		sm, err := smb.BuildImmediateFSM()
+		Expect(err).NotTo(HaveOccurred())
+		sm.Start()
+		evInsertCoin.Dispatch(sm, 100)
+		sm.Dispatch(fsm.NewEvent("evPrintTicket", nil))
+		Expect(sm.CurrentState().Name()).To(Equal("printing"))
+	})
+	It("should report payload mismatches as errors", func() {
+		_, err := evInsertCoin.Payload(fsm.NewEvent("evInsertCoin", 3))
+		var payloadErr *fsm.PayloadTypeError
+		Expect(errors.As(err, &payloadErr)).To(BeTrue())
+		Expect(err.Error()).To(Equal("event evInsertCoin: expected payload of type uint, got int"))
+		coin, err := evInsertCoin.Payload(evInsertCoin.New(20))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(coin).To(BeNumerically("==", 20))
+	})
+	It("should not mark event transitions without guards as guarded", func() {
+		sm, err := smb.BuildImmediateFSM()
+		Expect(err).NotTo(HaveOccurred())
+		guarded := []bool{}
+		sm.Visit(&transitionVisitor{visit: func(t fsm.Transition) {
+			if t.TriggerType() == fsm.EventTrigger {
+				guarded = append(guarded, t.IsGuarded())
+			}
+		}})
+		Expect(guarded).To(Equal([]bool{false, false, true}))
+
+		idle := smb.NewState("broken")
+		fsm.AddEventTransition(idle, idle, evInsertCoin)
+		fsm.AddEventTransition(idle, idle, evInsertCoin)
+		smb.AddState(idle)
+		issues := smb.Validate()
+		Expect(issues).To(ContainElement(HaveField("Code", fsm.IssueAmbiguousTransitions)))
+	})
+	It("should panic with a clear message when the machine data is not a D", func() {
+		smb.SetData("not a meter")
+		sm, err := smb.BuildImmediateFSM()
+		Expect(err).NotTo(HaveOccurred())
+		sm.Start()
+		Expect(func() { evInsertCoin.Dispatch(sm, 100) }).To(PanicWith("fsm: typed machine data is string, not *fsm_test.meter"))
+	})
+	It("should render the same diagram as the untyped api", func() {
+		sm, err := smb.BuildImmediateFSM()
+		Expect(err).NotTo(HaveOccurred())
+		counter := countingVisitor{}
+		sm.Visit(&counter)
+		Expect(counter.stateCount).To(Equal(4))
+		Expect(counter.transitionCount).To(Equal(5))
+	})
+})
+
+type transitionVisitor struct {
+	visit func(t fsm.Transition)
+}
+
+func (v *transitionVisitor) VisitState(state fsm.State)       {}
+func (v *transitionVisitor) VisitTransition(t fsm.Transition) { v.visit(t) }
@@ -1,6 +1,7 @@
 package fsm
 
 import (
+	"context"
 	"time"
 )
 
@@ -19,6 +20,31 @@ type StateMachineBuilder interface {
 	AddState(StateBuilder) StateMachineBuilder
 	NewState(name string, labels ...string) StateBuilder
 	AddTracer(Tracer) StateMachineBuilder
+	SetDiagnosticLogger(DiagnosticLogger) StateMachineBuilder
+	SetClock(Clock) StateMachineBuilder
+	SetDataCodec(DataCodec) StateMachineBuilder
+	SetPayloadCodec(PayloadCodec) StateMachineBuilder
+	// SetCheckpointStore makes the machine save a snapshot to the store, under instanceID,
+	// after every run-to-completion step and WithData call that changes the machine.
+	SetCheckpointStore(store Store, instanceID string) StateMachineBuilder
+	// RestoreImmediateFSM and RestoreThreadedFSM build the machine and resume it from a snapshot,
+	// without calling entry actions.  The builder is finalised as if built.
+	RestoreImmediateFSM(Snapshot) (ImmediateFSM, error)
+	RestoreThreadedFSM(Snapshot) (FSM, error)
+	// SetMicrostepLimit sets the maximum number of eventless transitions taken in one
+	// run-to-completion step before the machine is considered livelocked.
+	SetMicrostepLimit(limit int) StateMachineBuilder
+	// SetErrorState sets the state entered when the machine livelocks.  If no error state
+	// is set, a livelocked machine halts.  The error state is added to the machine if it has
+	// not been added with AddState or NewState.
+	SetErrorState(StateBuilder) StateMachineBuilder
+	// SetEvaluateOnDataUpdate makes every WithData call re-evaluate guarded eventless transitions,
+	// rather than waiting for the next Tick or data poll.
+	SetEvaluateOnDataUpdate(enabled bool) StateMachineBuilder
+	// Validate checks the machine definition for likely mistakes.  Build*FSM fails if validation
+	// finds errors, or warnings too with ValidateStrict.
+	Validate() []Issue
+	SetValidation(strictness Strictness, suppress ...IssueCode) StateMachineBuilder
 	AddFinalState() StateBuilder
 	GetInitialState() StateBuilder
 	GetFinalState() StateBuilder
@@ -31,8 +57,19 @@ type Dispatcher interface {
 	Dispatch(Event)
 }
 
+// Scheduler dispatches events later, as measured by the machine's clock.  Every FSM is a
+// Scheduler, as is the Dispatcher passed to its actions, which reach it with
+// dispatcher.(fsm.Scheduler).  Immediate machines dispatch scheduled events during Tick.
+// Scheduled events are dropped when the machine stops.
+type Scheduler interface {
+	DispatchAfter(delay time.Duration, ev Event, opts ...ScheduleOption) TimerID
+	DispatchAt(t time.Time, ev Event, opts ...ScheduleOption) TimerID
+	Cancel(TimerID) bool
+}
+
 type FSM interface {
 	Dispatcher
+	Scheduler
 	Visitable
 	Observable
 
@@ -40,12 +77,24 @@ type FSM interface {
 	Start()
 	Stop()
 	GetData() interface{}
+	// WithData and ReadData run fn with the machine's data, excluding the machine's own actions,
+	// guards and effects.  Use them rather than GetData to access the data of a threaded machine
+	// from other go routines.  They must not be called from within the machine's own actions.
+	WithData(fn func(data interface{}))
+	ReadData(fn func(data interface{}))
 	GetDispatcher() Dispatcher
+	// Snapshot captures the current state, pending timers, queued events and data of the machine.
+	Snapshot() (Snapshot, error)
 }
 
 type ImmediateFSM interface {
 	FSM
 	Tick() // Manually check for and progress state changes that are not event driven
+	// NextDeadline returns the earliest pending timer deadline in the current state, if there is one.
+	NextDeadline() (time.Time, bool)
+	// RunLoop dispatches events from the channel and calls Tick as timers fall due, until the context is
+	// done or the channel is closed.  The machine must already have been started.
+	RunLoop(ctx context.Context, events <-chan Event) error
 }
 
 type Event interface {
@@ -58,8 +107,14 @@ type StateBuilder interface {
 	AddTransition(target StateBuilder, labels ...string) TransitionBuilder
 	OnEntry(action Action, labels ...string) StateBuilder
 	OnExit(action Action, labels ...string) StateBuilder
+	// Invariant adds a condition that must hold while the machine is in the state.  Invariants are
+	// checked after the state is entered and at the end of every run-to-completion step that did
+	// not enter it, and violations are reported to tracers implementing InvariantTracer.
+	Invariant(check StateInvariant, label string) StateBuilder
 	build() (State, error)
 	buildTransitions() error
+	stateName() string
+	transitionBuilders() []TransitionBuilder
 }
 
 type State interface {
@@ -68,6 +123,8 @@ type State interface {
 	StateLabels() []string
 	EntryLabels() []string
 	ExitLabels() []string
+	InvariantLabels() []string
+	violatedInvariants(fsmData interface{}) []string // labels of the invariants that do not hold
 	doExit(fsm FSM)
 	doEntry(fsm FSM)
 }
@@ -79,19 +136,61 @@ type Tracer interface {
 	OnRejectedEvent(ev Event, state State, fmsData interface{})
 }
 
+// ErrorTracer may be implemented by a Tracer to be told about errors raised while the
+// machine is running, such as a *LivelockError.
+type ErrorTracer interface {
+	OnError(err error, state State, fsmData interface{})
+}
+
+// StepTracer may be implemented by a Tracer to be told when the machine starts, and at the
+// start of each run-to-completion step.  Evaluate steps are those with no event, caused by
+// Tick, timer expiry or data polling, and may not result in a transition.
+type StepTracer interface {
+	OnStart(when time.Time)
+	OnEventStep(ev Event, when time.Time)
+	OnEvaluateStep(when time.Time)
+}
+
+// GuardTracer may be implemented by a Tracer to be told when the guard of a transition triggered
+// by an event is false and the event is rejected, so that rejected events can be explained.  failed is the label of the
+// predicate that was false, for guards set with SetPredicate, or the guard labels otherwise.
+type GuardTracer interface {
+	OnGuardFailed(ev Event, transition Transition, failed string, fsmData interface{})
+}
+
+// TransitionTracer may be implemented by a Tracer to be told which transition of the definition
+// was taken, and when a guarded transition whose trigger was satisfied was blocked by its guard.
+// ev is nil for eventless and timed transitions.  Blocked eventless and timed transitions are
+// reported once each time their source state is entered, however often they are evaluated.
+type TransitionTracer interface {
+	OnTransitionTaken(ev Event, transition Transition, fsmData interface{})
+	OnTransitionBlocked(ev Event, transition Transition, fsmData interface{})
+}
+
+// InvariantTracer may be implemented by a Tracer to be told when a state invariant does not hold.
+type InvariantTracer interface {
+	OnInvariantViolated(state State, label string, fsmData interface{})
+}
+
 type Action func(state State, fsmData interface{}, dispatcher Dispatcher)
 type TransitionEffect func(ev Event, fsmData interface{}, dispatcher Dispatcher)
 type TransitionGuard func(fsmData, eventData interface{}) bool
+type StateInvariant func(fsmData interface{}) bool
 
 type TransitionBuilder interface {
 	SetEventTrigger(eventName string, labels ...string) TransitionBuilder
 	SetTimedTrigger(delay time.Duration, labels ...string) TransitionBuilder
 	SetGuard(guard TransitionGuard, labels ...string) TransitionBuilder
+	// SetPredicate sets a guard built from predicates, labelled with the predicate's text.
+	SetPredicate(predicate Predicate) TransitionBuilder
 	SetEffect(efffect TransitionEffect, labels ...string) TransitionBuilder
 	Source() StateBuilder
 	Target() StateBuilder
 	TriggerType() TriggerType
 	build(source, target State) (Transition, error)
+	eventName() string
+	timerDuration() time.Duration
+	isGuarded() bool
 }
 type TriggerType uint8
 
@@ -111,11 +210,16 @@ type Transition interface {
 	EventName() string
 	TriggerType() TriggerType
 	TimerDuration() time.Duration
-	shouldTransitionEv(ev Event, fsmData interface{}) bool // If this transition accepts supplied event and guard is met, then return true
-	shouldTransitionNoEv(fsmData interface{}) bool         // If this transition guard is met, with no need for event, or timer has expired and event guard is true, then return true.
+	IsGuarded() bool                                              // Returns true if a guard has been set on the transition
+	shouldTransitionEv(ev Event, fsmData interface{}) bool        // If this transition accepts supplied event and guard is met, then return true
+	acceptsPayload(eventData interface{}) bool                    // Returns false if the trigger is restricted to other payload types
+	shouldTransitionNoEv(fsmData interface{}, now time.Time) bool // If this transition guard is met, with no need for event, or timer has expired and event guard is true, then return true.
 	// will always return false if trigger event set.
+	due(now time.Time) bool // Returns true if the transition is eventless, or its timer has expired.  Always false if trigger event set.
 
 	startTimer(fromTime time.Time) // Starts timers if present on a transition - the timers will trigger at fromTime + TimerDuration
+	deadline() (time.Time, bool)   // Returns the time a started timer will trigger, false if no timer
+	failedGuard() string           // Returns the reason the guard was last false, if known
 	doAction(ev Event, fsm FSM)
 }
 
@@ -0,0 +1,214 @@
+package fsm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type Severity int
+
+const (
+	SeverityWarning Severity = iota + 1
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	default:
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+}
+
+// IssueCode identifies a validation check.  Codes are stable, so they can be used to suppress
+// checks with SetValidation.
+type IssueCode string
+
+const (
+	IssueDuplicateState       IssueCode = "duplicate-state"       // two states with the same name
+	IssueUnregisteredState    IssueCode = "unregistered-state"    // transition target never added to the machine
+	IssueUnreachableState     IssueCode = "unreachable-state"     // no path from the initial state
+	IssueDeadEndState         IssueCode = "dead-end-state"        // non-final state with no outgoing transitions
+	IssueNonPositiveTimer     IssueCode = "non-positive-timer"    // timed trigger of zero or less
+	IssueAmbiguousTransitions IssueCode = "ambiguous-transitions" // unguarded transitions from a state on the same trigger
+)
+
+var issueSeverities = map[IssueCode]Severity{
+	IssueDuplicateState:       SeverityError,
+	IssueUnregisteredState:    SeverityError,
+	IssueUnreachableState:     SeverityWarning,
+	IssueDeadEndState:         SeverityWarning,
+	IssueNonPositiveTimer:     SeverityError,
+	IssueAmbiguousTransitions: SeverityWarning,
+}
+
+// Issue is a problem found in a machine definition by Validate.
+type Issue struct {
+	Code     IssueCode
+	Severity Severity
+	State    string // the state the issue was found in
+	Message  string
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("%s %s: %s", i.Severity, i.Code, i.Message)
+}
+
+// Strictness sets which validation issues make Build*FSM fail.
+type Strictness int
+
+const (
+	ValidateErrors Strictness = iota // fail on errors, the default
+	ValidateStrict                   // fail on warnings and errors
+	ValidateOff                      // do not validate
+)
+
+// ValidationError is returned by Build*FSM when validation fails.  Issues holds the issues that
+// caused the failure.
+type ValidationError struct {
+	Issues []Issue
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Issues))
+	for idx, issue := range e.Issues {
+		msgs[idx] = issue.String()
+	}
+	return "invalid state machine: " + strings.Join(msgs, "; ")
+}
+
+// SetValidation sets the strictness used when building, and suppresses the given checks in
+// both Validate and Build*FSM.
+func (b *fsmBuilder) SetValidation(strictness Strictness, suppress ...IssueCode) StateMachineBuilder {
+	b.strictness = strictness
+	b.suppressed = make(map[IssueCode]bool)
+	for _, code := range suppress {
+		b.suppressed[code] = true
+	}
+	return b
+}
+
+// registeredStates returns the state builders that make up the machine, in order.
+func (b *fsmBuilder) registeredStates() []StateBuilder {
+	states := []StateBuilder{b.initialState}
+	states = append(states, b.machineStateBuilders()...)
+	if b.finalState != nil {
+		states = append(states, b.finalState)
+	}
+	return states
+}
+
+func (b *fsmBuilder) Validate() []Issue {
+	issues := []Issue{}
+	report := func(code IssueCode, state string, format string, args ...interface{}) {
+		if b.suppressed[code] {
+			return
+		}
+		issues = append(issues, Issue{
+			Code:     code,
+			Severity: issueSeverities[code],
+			State:    state,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	states := b.registeredStates()
+	registered := make(map[StateBuilder]bool)
+	byName := make(map[string]int)
+	for _, sb := range states {
+		name := sb.stateName()
+		if registered[sb] {
+			report(IssueDuplicateState, name, "state %q added more than once", name)
+		} else if byName[name] > 0 {
+			report(IssueDuplicateState, name, "more than one state named %q", name)
+		}
+		registered[sb] = true
+		byName[name]++
+	}
+
+	for _, sb := range states {
+		name := sb.stateName()
+		triggers := make(map[string]int)
+		for _, tb := range sb.transitionBuilders() {
+			target := tb.Target()
+			if !registered[target] {
+				report(IssueUnregisteredState, name, "transition %s --> %s targets a state that was not added to the machine", name, target.stateName())
+			}
+			if tb.TriggerType() == TimerTrigger && tb.timerDuration() <= 0 {
+				report(IssueNonPositiveTimer, name, "transition %s --> %s has timed trigger %s", name, target.stateName(), tb.timerDuration())
+			}
+			if !tb.isGuarded() {
+				trigger := triggerDescription(tb)
+				triggers[trigger]++
+				if triggers[trigger] == 2 {
+					report(IssueAmbiguousTransitions, name, "state %q has more than one unguarded transition on %s", name, trigger)
+				}
+			}
+		}
+	}
+
+	// reachability, following every transition, including those to unregistered states
+	reached := map[StateBuilder]bool{b.initialState: true}
+	queue := []StateBuilder{b.initialState}
+	if b.errorState != nil && !reached[b.errorState] {
+		reached[b.errorState] = true
+		queue = append(queue, b.errorState)
+	}
+	for len(queue) > 0 {
+		sb := queue[0]
+		queue = queue[1:]
+		for _, tb := range sb.transitionBuilders() {
+			if target := tb.Target(); !reached[target] {
+				reached[target] = true
+				queue = append(queue, target)
+			}
+		}
+	}
+	for _, sb := range states {
+		name := sb.stateName()
+		if !reached[sb] {
+			report(IssueUnreachableState, name, "state %q is not reachable from the initial state", name)
+		}
+		if sb != b.finalState && sb != b.errorState && len(sb.transitionBuilders()) == 0 {
+			report(IssueDeadEndState, name, "state %q has no outgoing transitions and is not the final state", name)
+		}
+	}
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		return issues[i].Severity > issues[j].Severity
+	})
+	return issues
+}
+
+func triggerDescription(tb TransitionBuilder) string {
+	switch tb.TriggerType() {
+	case EventTrigger:
+		return fmt.Sprintf("event %q", tb.eventName())
+	case TimerTrigger:
+		return fmt.Sprintf("timer %s", tb.timerDuration())
+	default:
+		return "no trigger"
+	}
+}
+
+// validationFailures returns the issues that should stop the machine being built.
+func (b *fsmBuilder) validationFailures() []Issue {
+	if b.strictness == ValidateOff {
+		return nil
+	}
+	failOn := SeverityError
+	if b.strictness == ValidateStrict {
+		failOn = SeverityWarning
+	}
+	failures := []Issue{}
+	for _, issue := range b.Validate() {
+		if issue.Severity >= failOn {
+			failures = append(failures, issue)
+		}
+	}
+	return failures
+}
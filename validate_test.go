@@ -0,0 +1,113 @@
+package fsm_test
+
+import (
+	"errors"
+	"time"
+
+	fsm "github.com/johngrange/gofsm"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Validation", func() {
+	var (
+		smb      fsm.StateMachineBuilder
+		on, off  fsm.StateBuilder
+		codes    func() []fsm.IssueCode
+		alwaysOn = func(fsmData, eventData interface{}) bool { return true }
+	)
+
+	BeforeEach(func() {
+		smb = fsm.NewFSMBuilder()
+		off = smb.NewState("off")
+		on = smb.NewState("on")
+		smb.GetInitialState().AddTransition(off)
+		off.AddTransition(on).SetEventTrigger("evOn")
+		on.AddTransition(off).SetEventTrigger("evOff")
+		codes = func() []fsm.IssueCode {
+			result := []fsm.IssueCode{}
+			for _, issue := range smb.Validate() {
+				result = append(result, issue.Code)
+			}
+			return result
+		}
+	})
+
+	It("should find no issues in a valid machine", func() {
+		Expect(smb.Validate()).To(BeEmpty())
+	})
+	It("should report duplicate state names", func() {
+		smb.NewState("on").AddTransition(off)
+		Expect(codes()).To(ContainElement(fsm.IssueDuplicateState))
+		_, err := smb.BuildImmediateFSM()
+		var validationErr *fsm.ValidationError
+		Expect(errors.As(err, &validationErr)).To(BeTrue())
+		Expect(validationErr.Issues).To(HaveLen(1))
+		Expect(validationErr.Issues[0].State).To(Equal("on"))
+		Expect(err.Error()).To(Equal(`invalid state machine: error duplicate-state: more than one state named "on"`))
+	})
+	It("should report states added twice", func() {
+		smb.AddState(on)
+		Expect(codes()).To(Equal([]fsm.IssueCode{fsm.IssueDuplicateState}))
+	})
+	It("should report transitions to states that were never added", func() {
+		stray := fsm.NewStateBuilder("stray")
+		on.AddTransition(stray).SetEventTrigger("evStray")
+		stray.AddTransition(off)
+		Expect(codes()).To(Equal([]fsm.IssueCode{fsm.IssueUnregisteredState}))
+		_, err := smb.BuildThreadedFSM()
+		Expect(err).To(MatchError(ContainSubstring("transition on --> stray targets a state that was not added")))
+	})
+	It("should warn about unreachable and dead-end states", func() {
+		smb.NewState("orphan").AddTransition(off)
+		smb.NewState("stuck")
+		on.AddTransition(smb.AddFinalState()).SetEventTrigger("evDone")
+		issues := smb.Validate()
+		Expect(issues).To(HaveLen(3))
+		Expect(issues).To(ContainElements(
+			fsm.Issue{Code: fsm.IssueUnreachableState, Severity: fsm.SeverityWarning, State: "orphan",
+				Message: `state "orphan" is not reachable from the initial state`},
+			fsm.Issue{Code: fsm.IssueUnreachableState, Severity: fsm.SeverityWarning, State: "stuck",
+				Message: `state "stuck" is not reachable from the initial state`},
+			fsm.Issue{Code: fsm.IssueDeadEndState, Severity: fsm.SeverityWarning, State: "stuck",
+				Message: `state "stuck" has no outgoing transitions and is not the final state`},
+		))
+		_, err := smb.BuildImmediateFSM()
+		Expect(err).NotTo(HaveOccurred())
+	})
+	It("should not report the error state as unreachable or a dead end", func() {
+		smb.SetErrorState(smb.NewState("error"))
+		Expect(smb.Validate()).To(BeEmpty())
+	})
+	It("should report non-positive timers", func() {
+		on.AddTransition(off).SetTimedTrigger(0)
+		off.AddTransition(on).SetTimedTrigger(-time.Second).SetGuard(alwaysOn)
+		Expect(codes()).To(Equal([]fsm.IssueCode{fsm.IssueNonPositiveTimer, fsm.IssueNonPositiveTimer}))
+	})
+	It("should warn about unguarded transitions on the same trigger", func() {
+		off.AddTransition(smb.AddFinalState()).SetEventTrigger("evOn")
+		Expect(codes()).To(Equal([]fsm.IssueCode{fsm.IssueAmbiguousTransitions}))
+		on.AddTransition(on).SetEventTrigger("evOff").SetGuard(alwaysOn)
+		Expect(codes()).To(HaveLen(1))
+	})
+	When("configuring strictness", func() {
+		BeforeEach(func() {
+			smb.NewState("orphan").AddTransition(off)
+		})
+		It("should fail on warnings when strict", func() {
+			_, err := smb.SetValidation(fsm.ValidateStrict).BuildImmediateFSM()
+			Expect(err).To(MatchError(`invalid state machine: warning unreachable-state: state "orphan" is not reachable from the initial state`))
+		})
+		It("should suppress checks by code", func() {
+			smb.SetValidation(fsm.ValidateStrict, fsm.IssueUnreachableState)
+			Expect(smb.Validate()).To(BeEmpty())
+			_, err := smb.BuildImmediateFSM()
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("should build anything when validation is off", func() {
+			smb.NewState("on")
+			_, err := smb.SetValidation(fsm.ValidateOff).BuildImmediateFSM()
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+})